@@ -83,26 +83,26 @@ const runtimeimport = "" +
 	"\x15selectrecv2\x00\b\x17\"\xb6\x02\x00\x00\x1f\x02:\xfe\x01\x00\x00\x17:\x80\x02\x00\x00\x17\x00\x15rece" +
 	"ived·5\x00\x00\x02\x00\xb8\x02\x00\x00\t\x19selectdefault\x00\x02\x17\"\xb6\x02\x00\x00\x02\x00" +
 	"\xb8\x02\x00\x00\t\x0fselectgo\x00\x02\x17\"\xae\x02\x00\x00\x00\t\tblock\x00\x00\x00\t\x11makes" +
-	"lice\x00\x06\x17\"\b\x00\x00\n\vnel·3\x00\x00\n\vcap·4\x00\x00\x02\x11:\vary·" +
-	"1\x00\x00\t\x11growslice\x00\x06\x17\"\b\x00\x00\x11:\vold·3\x00\x00\x02\xca\x02\x00\x00\x02\x11:" +
-	"\xcc\x02\x00\x00\t\rmemmove\x00\x06\x17:\tto·1\x00\x00\x17:\vfrm·2\x00\x00\x16\x11le" +
-	"ngth·3\x00d\x00\t\vmemclr\x00\x04\x17\"\vptr·1\x00\x00\x16\x11length\xc2" +
-	"\xb72\x00d\x00\t\x0fmemequal\x00\x06\x17:\ax·2\x00\x00\x17:\ay·3\x00\x00\x16\rsiz" +
-	"e·4\x00d\x01\x00\x00\t\x11memequal8\x00\x04\x17:\xe2\x02\x00\x00\x17:\xe4\x02\x00\x00\x01\x00\x00\t\x13m" +
-	"emequal16\x00\x04\x17:\xe2\x02\x00\x00\x17:\xe4\x02\x00\x00\x01\x00\x00\t\x13memequal32\x00\x04" +
-	"\x17:\xe2\x02\x00\x00\x17:\xe4\x02\x00\x00\x01\x00\x00\t\x13memequal64\x00\x04\x17:\xe2\x02\x00\x00\x17:\xe4\x02\x00" +
-	"\x00\x01\x00\x00\t\x15memequal128\x00\x04\x17:\xe2\x02\x00\x00\x17:\xe4\x02\x00\x00\x01\x00\x00\t\x0fint6" +
-	"4div\x00\x03\n\x00\n\x00\x01\n\x00\t\x11uint64div\x00\x03\x14\x00\x14\x00\x01\x14\x00\t\x0fint64" +
-	"mod\x00\x03\n\x00\n\x00\x01\n\x00\t\x11uint64mod\x00\x03\x14\x00\x14\x00\x01\x14\x00\t\x1bfloat6" +
-	"4toint64\x00\x01\x1a\x00\x01\n\x00\t\x1dfloat64touint64\x00\x01\x1a\x00\x01\x14\x00\t" +
-	"\x1bint64tofloat64\x00\x01\n\x00\x01\x1a\x00\t\x1duint64tofloat64\x00" +
-	"\x01\x14\x00\x01\x1a\x00\t\x19complex128div\x00\x04\x1e\vnum·2\x00\x00\x1e\vden·" +
-	"3\x00\x00\x02\x1e\vquo·1\x00\x00\t\x19racefuncenter\x00\x01\x16d\x00\t\x17race" +
-	"funcexit\x00\x00\x00\t\x0fraceread\x00\x01\x16d\x00\t\x11racewrite\x00\x01\x16" +
-	"d\x00\t\x19racereadrange\x00\x04\x16\raddr·1\x00d\x16\rsize·2\x00" +
-	"d\x00\t\x1bracewriterange\x00\x04\x16\x94\x03\x00d\x16\x96\x03\x00d\x00\t\x0fmsanrea" +
-	"d\x00\x04\x16\x94\x03\x00d\x16\x96\x03\x00d\x00\t\x11msanwrite\x00\x04\x16\x94\x03\x00d\x16\x96\x03\x00d\x00\v\xf4" +
-	"\x01\x02\v\x00\x01\x00\n$$\n"
+	"lice\x00\b\x17\"\b\x00\x00\n\vnel·3\x00\x00\n\vcap·4\x00\x00\x00\ruser·5" +
+	"\x00\x00\x02\x11:\vary·1\x00\x00\t\x11growslice\x00\x06\x17\"\b\x00\x00\x11:\vold·" +
+	"3\x00\x00\x02\xca\x02\x00\x00\x02\x11:\xce\x02\x00\x00\t\rmemmove\x00\x06\x17:\tto·1\x00\x00\x17:\vf" +
+	"rm·2\x00\x00\x16\x11length·3\x00d\x00\t\vmemclr\x00\x04\x17\"\vptr·1" +
+	"\x00\x00\x16\x11length·2\x00d\x00\t\x0fmemequal\x00\x06\x17:\ax·2\x00\x00\x17:\a" +
+	"y·3\x00\x00\x16\rsize·4\x00d\x01\x00\x00\t\x11memequal8\x00\x04\x17:\xe4\x02\x00\x00\x17" +
+	":\xe6\x02\x00\x00\x01\x00\x00\t\x13memequal16\x00\x04\x17:\xe4\x02\x00\x00\x17:\xe6\x02\x00\x00\x01\x00\x00\t\x13m" +
+	"emequal32\x00\x04\x17:\xe4\x02\x00\x00\x17:\xe6\x02\x00\x00\x01\x00\x00\t\x13memequal64\x00\x04" +
+	"\x17:\xe4\x02\x00\x00\x17:\xe6\x02\x00\x00\x01\x00\x00\t\x15memequal128\x00\x04\x17:\xe4\x02\x00\x00\x17:\xe6\x02" +
+	"\x00\x00\x01\x00\x00\t\x0fint64div\x00\x03\n\x00\n\x00\x01\n\x00\t\x11uint64div\x00\x03\x14\x00\x14" +
+	"\x00\x01\x14\x00\t\x0fint64mod\x00\x03\n\x00\n\x00\x01\n\x00\t\x11uint64mod\x00\x03\x14\x00\x14\x00" +
+	"\x01\x14\x00\t\x1bfloat64toint64\x00\x01\x1a\x00\x01\n\x00\t\x1dfloat64touin" +
+	"t64\x00\x01\x1a\x00\x01\x14\x00\t\x1bint64tofloat64\x00\x01\n\x00\x01\x1a\x00\t\x1duint6" +
+	"4tofloat64\x00\x01\x14\x00\x01\x1a\x00\t\x19complex128div\x00\x04\x1e\vnum\xc2" +
+	"\xb72\x00\x00\x1e\vden·3\x00\x00\x02\x1e\vquo·1\x00\x00\t\x19racefuncenter" +
+	"\x00\x01\x16d\x00\t\x17racefuncexit\x00\x00\x00\t\x0fraceread\x00\x01\x16d\x00\t\x11r" +
+	"acewrite\x00\x01\x16d\x00\t\x19racereadrange\x00\x04\x16\raddr·1\x00" +
+	"d\x16\rsize·2\x00d\x00\t\x1bracewriterange\x00\x04\x16\x96\x03\x00d\x16\x98\x03\x00" +
+	"d\x00\t\x0fmsanread\x00\x04\x16\x96\x03\x00d\x16\x98\x03\x00d\x00\t\x11msanwrite\x00\x04\x16\x96" +
+	"\x03\x00d\x16\x98\x03\x00d\x00\v\xf4\x01\x02\v\x00\x01\x00\n$$\n"
 
 const unsafeimport = "" +
 	"cn\x00\x03v0\x01\vunsafe\x00\x05\r\rPointer\x00\x16\x00\t\x0fOffsetof\x00\x01" +
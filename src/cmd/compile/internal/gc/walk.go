@@ -1425,11 +1425,13 @@ opswitch:
 			r = walkexpr(r, init)
 			n = r
 		} else {
-			// makeslice(et *Type, nel int64, max int64) (ary []any)
+			// makeslice(et *Type, nel int64, max int64, user bool) (ary []any)
+			// user is false for the runtime's own slices, which
+			// must not be affected by runtime.SetAllocZeroPolicy.
 			fn := syslook("makeslice")
 
 			fn = substArgTypes(fn, t.Elem()) // any-1
-			n = mkcall1(fn, n.Type, init, typename(t.Elem()), conv(l, Types[TINT64]), conv(r, Types[TINT64]))
+			n = mkcall1(fn, n.Type, init, typename(t.Elem()), conv(l, Types[TINT64]), conv(r, Types[TINT64]), Nodbool(!compiling_runtime))
 		}
 
 	case ORUNESTR:
@@ -124,8 +124,9 @@ func cgoCheckTypedBlock(typ *_type, src unsafe.Pointer, off, size uintptr) {
 	aoff := uintptr(src) - mheap_.arena_start
 	idx := aoff >> _PageShift
 	s := h_spans[idx]
-	if s.state == _MSpanStack {
-		// There are no heap bits for value stored on the stack.
+	if s.state == _MSpanStack || s.lazybits != 0 {
+		// There are no heap bits for value stored on the stack,
+		// or yet for a large object allocated with flagLazyBitmap.
 		// For a channel receive src might be on the stack of some
 		// other goroutine, so we can't unwind the stack even if
 		// we wanted to.
@@ -213,6 +213,10 @@ func BenchSetType(n int, x interface{}) {
 
 const PtrSize = sys.PtrSize
 
+const CacheLineSize = sys.CacheLineSize
+
+const MaxMem = _MaxMem
+
 var TestingAssertE2I2GC = &testingAssertE2I2GC
 var TestingAssertE2T2GC = &testingAssertE2T2GC
 
@@ -244,3 +248,113 @@ func CountPagesInUse() (pagesInUse, counted uintptr) {
 
 	return
 }
+
+var SweepDone = gosweepdone
+
+// ScavengeStep runs one step of a scavenge pass that releases all free
+// spans, releasing at most about max bytes, and reports whether the pass
+// has more to do.
+func ScavengeStep(max uintptr) (more bool) {
+	systemstack(func() {
+		more = mheap_.scavenge(-1, ^uint64(0), 0, max, 0)
+	})
+	return
+}
+
+// ScavengeAll runs a scavenge pass that releases all free spans, as the
+// background scavenger would, keeping MinHeap bytes.
+func ScavengeAll() {
+	systemstack(func() {
+		mheap_.scavenge(-1, ^uint64(0), 0, ^uintptr(0), MinHeap())
+	})
+}
+
+// ScavengeOlder runs a scavenge pass that releases the free spans that
+// have been unused for longer than limit nanoseconds, as the background
+// scavenger would.
+func ScavengeOlder(limit int64) {
+	systemstack(func() {
+		mheap_.scavenge(-1, uint64(nanotime()), uint64(limit), ^uintptr(0), 0)
+	})
+}
+
+// FreeSpanReleased reports whether the heap page at p is free and, if
+// so, how many bytes of the free span holding it have been released to
+// the operating system.
+func FreeSpanReleased(p uintptr) (free bool, released uintptr) {
+	systemstack(func() {
+		lock(&mheap_.lock)
+		for _, s := range h_allspans {
+			if s.state == _MSpanFree && s.base() <= p && p < s.base()+s.npages<<_PageShift {
+				free, released = true, s.npreleased<<_PageShift
+				break
+			}
+		}
+		unlock(&mheap_.lock)
+	})
+	return
+}
+
+// LazyBitsPending reports whether the heap bitmap of the large object
+// at p, allocated by AllocLazyBitmap, has yet to be written.
+func LazyBitsPending(p unsafe.Pointer) bool {
+	s := spanOf(uintptr(p))
+	return s != nil && atomic.Load(&s.lazybits) == lazyBitsPending
+}
+
+// InMallocWhileMallocing calls InMalloc as if from the middle of
+// a heap allocation.
+func InMallocWhileMallocing() bool {
+	mp := acquirem()
+	mp.mallocing = 1
+	r := InMalloc()
+	mp.mallocing = 0
+	releasem(mp)
+	return r
+}
+
+// SetObjTypes sets GODEBUG=objtypes and returns the previous value.
+func SetObjTypes(v int32) int32 {
+	old := debug.objtypes
+	debug.objtypes = v
+	return old
+}
+
+// SetNoScanCheck sets GODEBUG=noscancheck and returns the previous value.
+func SetNoScanCheck(v int32) int32 {
+	old := debug.noscancheck
+	debug.noscancheck = v
+	return old
+}
+
+// AllocNoZero allocates an object of the type typ, a pointer value such
+// as (*T)(nil), points to, without zeroing it.
+func AllocNoZero(typ interface{}) unsafe.Pointer {
+	t := (*ptrtype)(unsafe.Pointer(efaceOf(&typ)._type)).elem
+	return mallocgc(t.size, t, flagNoZero)
+}
+
+// SetMallocTSC sets GODEBUG=malloctsc and returns the previous value.
+func SetMallocTSC(v int32) int32 {
+	old := debug.malloctsc
+	debug.malloctsc = v
+	return old
+}
+
+// SizeClassSpans returns, for each size class from 1 up, the object
+// size and the number of bytes in a span of the class.
+func SizeClassSpans() (size, spanBytes []uintptr) {
+	for c := 1; c < _NumSizeClasses; c++ {
+		size = append(size, uintptr(class_to_size[c]))
+		spanBytes = append(spanBytes, uintptr(class_to_allocnpages[c])<<_PageShift)
+	}
+	return
+}
+
+var AllocSizeBucketOf = allocSizeBucket
+
+// GCDedicatedWorkersPeak returns the most dedicated mark workers that
+// have drained at once during the current or last background cycle.
+func GCDedicatedWorkersPeak() int {
+	return int(atomic.Load(&gcController.dedicatedMarkWorkersPeak))
+}
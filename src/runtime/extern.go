@@ -82,6 +82,10 @@ It is a comma-separated list of name=val pairs setting these named variables:
 	If the line ends with "(forced)", this GC was forced by a
 	runtime.GC() call and all phases are STW.
 
+	malloctsc: setting malloctsc=1 causes every 64th small allocation on each P to
+	time its fast path, taking an object from the P's cached span, with the CPU
+	timestamp counter, for runtime.MallocCycles to report.
+
 	memprofilerate: setting memprofilerate=X will update the value of runtime.MemProfileRate.
 	When set to 0 memory profiling is disabled.  Refer to the description of
 	MemProfileRate for the default value.
@@ -92,6 +96,17 @@ It is a comma-separated list of name=val pairs setting these named variables:
 	This should only be used as a temporary workaround to diagnose buggy code.
 	The real fix is to not store integers in pointer-typed locations.
 
+	noscancheck: setting noscancheck=1 causes every heap allocation to check that
+	the type's flag saying it contains no pointers agrees with the type's pointer
+	data, and that no memory for a type with pointers is allocated without being
+	zeroed, crashing the program otherwise. Either mistake, in the compiler, the
+	reflect package or the runtime, would hide pointers from the garbage collector
+	or show it garbage ones.
+
+	objtypes: setting objtypes=1 causes the runtime to record the type of every
+	heap object allocated with one, for runtime.ObjectType to report. The record
+	costs a few words of memory outside the heap per object and slows allocation.
+
 	sbrk: setting sbrk=1 replaces the memory allocator and garbage collector
 	with a trivial allocator that obtains memory from the operating system and
 	never reclaims any memory.
@@ -10,6 +10,7 @@ import (
 	"reflect"
 	"runtime"
 	"runtime/debug"
+	"strings"
 	"testing"
 	"time"
 	"unsafe"
@@ -130,6 +131,606 @@ func TestGcLastTime(t *testing.T) {
 	}
 }
 
+func TestPauseHistogram(t *testing.T) {
+	count := func() (n uint64) {
+		for _, b := range runtime.PauseHistogram() {
+			n += b.Count
+		}
+		return
+	}
+	before := count()
+	runtime.GC()
+	if got := count(); got <= before {
+		t.Errorf("pause count after GC = %d, want more than %d", got, before)
+	}
+	var prev uint64
+	for i, b := range runtime.PauseHistogram() {
+		if b.Count == 0 || b.MinNs > b.MaxNs || (i > 0 && b.MinNs <= prev) {
+			t.Errorf("bad bucket %d: %+v", i, b)
+		}
+		prev = b.MaxNs
+	}
+}
+
+func TestGCStats(t *testing.T) {
+	const N = 1000
+	runtime.GC()
+	for i := 0; i < N; i++ {
+		hugeSink = new([64]byte)
+	}
+	hugeSink = nil
+	r := runtime.GCStats()
+	if r.Live == 0 {
+		t.Fatalf("GCStats reported no live bytes: %+v", r)
+	}
+	// A background cycle may free some of the garbage first, but
+	// not all of it.
+	if r.FreedObjects == 0 || r.Reclaimed == 0 {
+		t.Fatalf("GCStats reported nothing freed after dropping %d objects: %+v", N, r)
+	}
+	if r.PauseNs > 10e9 {
+		t.Errorf("bad GC pause: got %v, want [0, 10e9]", r.PauseNs)
+	}
+}
+
+func TestHeapGoalHistory(t *testing.T) {
+	runtime.GC()
+	h := runtime.HeapGoalHistory()
+	if len(h) == 0 {
+		t.Fatalf("HeapGoalHistory() is empty after GC")
+	}
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	last := h[len(h)-1]
+	if last.NumGC > ms.NumGC || last.NumGC == 0 {
+		t.Errorf("last sample is for GC %d, but MemStats.NumGC = %d", last.NumGC, ms.NumGC)
+	}
+	for i, s := range h {
+		if i > 0 && s.NumGC != h[i-1].NumGC+1 {
+			t.Errorf("sample %d is for GC %d, want %d", i, s.NumGC, h[i-1].NumGC+1)
+		}
+		if s.Peak == 0 {
+			t.Errorf("sample %d has zero peak: %+v", i, s)
+		}
+	}
+}
+
+type markOnlyObj struct {
+	next *markOnlyObj
+	n    int
+}
+
+var markOnlySink *markOnlyObj
+
+func TestGCMarkOnly(t *testing.T) {
+	live := &markOnlyObj{n: 1}
+	markOnlySink = live
+	dead := uintptr(unsafe.Pointer(func() *markOnlyObj {
+		// A dead object pointing at another dead object.
+		return &markOnlyObj{next: &markOnlyObj{n: 3}, n: 2}
+	}()))
+	unreachable := runtime.GCMarkOnly()
+	var found *markOnlyObj
+	for _, p := range unreachable {
+		if uintptr(p) == uintptr(unsafe.Pointer(live)) {
+			t.Fatalf("reachable object reported unreachable")
+		}
+		if uintptr(p) == dead {
+			found = (*markOnlyObj)(p)
+		}
+	}
+	if found == nil {
+		t.Fatalf("unreachable object not reported among %d objects", len(unreachable))
+	}
+	// The object and the objects it points to were not freed.
+	runtime.GC()
+	if found.n != 2 || found.next == nil || found.next.n != 3 {
+		t.Errorf("unreachable object was freed: %+v", found)
+	}
+	runtime.KeepAlive(unreachable)
+	markOnlySink = nil
+}
+
+func TestAllocationBarrier(t *testing.T) {
+	defer debug.SetGCPercent(debug.SetGCPercent(10))
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	numGC := ms.NumGC
+
+	b := runtime.AllocationBarrier()
+	for i := 0; i < 1000; i++ {
+		hugeSink = make([]byte, 64<<10)
+	}
+	hugeSink = nil
+	runtime.ReadMemStats(&ms)
+	if ms.NumGC != numGC {
+		t.Fatalf("%d collections ran during allocation barrier", ms.NumGC-numGC)
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&ms)
+	if ms.NumGC != numGC+1 {
+		t.Fatalf("GC ran %d collections during allocation barrier, want 1", ms.NumGC-numGC)
+	}
+	b.Release()
+	b.Release()
+
+	numGC = ms.NumGC
+	for i := 0; i < 1000 && ms.NumGC == numGC; i++ {
+		hugeSink = make([]byte, 64<<10)
+		runtime.ReadMemStats(&ms)
+	}
+	hugeSink = nil
+	if ms.NumGC == numGC {
+		t.Fatalf("no collections ran after releasing allocation barrier")
+	}
+}
+
+func TestForceSweepComplete(t *testing.T) {
+	defer debug.SetGCPercent(debug.SetGCPercent(10))
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	numGC := ms.NumGC
+	for ms.NumGC == numGC {
+		for i := 0; i < 100; i++ {
+			hugeSink = make([]byte, 16<<10)
+		}
+		runtime.ReadMemStats(&ms)
+	}
+	hugeSink = nil
+	debug.SetGCPercent(-1)
+
+	// A cycle in progress may still finish and start a new sweep.
+	for i := 0; i < 3; i++ {
+		runtime.ReadMemStats(&ms)
+		numGC = ms.NumGC
+		runtime.ForceSweepComplete()
+		done := runtime.SweepDone()
+		runtime.ReadMemStats(&ms)
+		if ms.NumGC == numGC {
+			if !done {
+				t.Fatalf("heap not swept after ForceSweepComplete")
+			}
+			return
+		}
+	}
+	t.Fatalf("GC cycles kept running with GOGC=off")
+}
+
+type heapCheckNode struct {
+	next *heapCheckNode
+	buf  *[1 << 20]byte
+}
+
+var heapCheckSink *heapCheckNode
+
+func TestHeapCheck(t *testing.T) {
+	// No collection may see the bad pointer below.
+	defer debug.SetGCPercent(debug.SetGCPercent(-1))
+	var list *heapCheckNode
+	for i := 0; i < 1000; i++ {
+		list = &heapCheckNode{next: list}
+	}
+	heapCheckSink = list
+	if err := runtime.HeapCheck(); err != nil {
+		t.Fatalf("HeapCheck() = %v on a healthy heap", err)
+	}
+
+	// Free a buffer while keeping its address where the garbage
+	// collector does not look, then store it in a live object, as
+	// unsafe code might.
+	hidden := (*unsafe.Pointer)(runtime.AllocPermanent(unsafe.Sizeof(uintptr(0)), nil))
+	*hidden = unsafe.Pointer(new([1 << 20]byte))
+	runtime.GC()
+	runtime.GC()
+	list.buf = (*[1 << 20]byte)(*hidden)
+	err := runtime.HeapCheck()
+	list.buf, *hidden = nil, nil
+	heapCheckSink = nil
+	if err == nil || !strings.Contains(err.Error(), "pointer to memory not in use") {
+		t.Errorf("HeapCheck() = %v with a pointer to a freed object", err)
+	}
+}
+
+func TestGCQuick(t *testing.T) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	numGC := ms.NumGC
+	done := make(chan bool, 1)
+	x := new([16]uintptr)
+	runtime.SetFinalizer(x, func(*[16]uintptr) { done <- true })
+	x = nil
+	runtime.GCQuick()
+	runtime.ReadMemStats(&ms)
+	if ms.NumGC == numGC {
+		t.Fatalf("GCQuick did not run a collection")
+	}
+	select {
+	case <-done:
+	case <-time.After(4 * time.Second):
+		t.Fatalf("finalizer for unreachable object did not run after GCQuick")
+	}
+}
+
+func TestWithGCSuppressed(t *testing.T) {
+	defer debug.SetGCPercent(debug.SetGCPercent(100))
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	numGC := ms.NumGC
+	runtime.WithGCSuppressed(time.Now().Add(500 * time.Millisecond).UnixNano())
+
+	// Grow the heap past the trigger but not to twice it.
+	target := ms.NextGC + ms.NextGC/2
+	for ms.HeapAlloc < target {
+		for i := 0; i < 64; i++ {
+			hugeSink = make([]byte, 16<<10)
+		}
+		runtime.ReadMemStats(&ms)
+	}
+	if ms.NumGC != numGC {
+		t.Fatalf("%d collections ran while suppressed", ms.NumGC-numGC)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	for i := 0; i < 100 && ms.NumGC == numGC; i++ {
+		hugeSink = make([]byte, 16<<10)
+		runtime.ReadMemStats(&ms)
+	}
+	hugeSink = nil
+	if ms.NumGC == numGC {
+		t.Fatalf("no collection ran after suppression ended")
+	}
+}
+
+func TestSetGCTriggerFunc(t *testing.T) {
+	// Without the trigger function, no collection would start.
+	defer debug.SetGCPercent(debug.SetGCPercent(1 << 20))
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	numGC := ms.NumGC
+	called := false
+	runtime.SetGCTriggerFunc(func(heapAlloc, nextGC uintptr) bool {
+		called = true
+		return heapAlloc > 0
+	})
+	defer runtime.SetGCTriggerFunc(nil)
+	for i := 0; i < 1000 && ms.NumGC == numGC; i++ {
+		hugeSink = make([]byte, 16<<10)
+		runtime.ReadMemStats(&ms)
+	}
+	hugeSink = nil
+	if !called {
+		t.Fatalf("trigger function not called")
+	}
+	if ms.NumGC == numGC {
+		t.Fatalf("no collection ran with a trigger function returning true")
+	}
+}
+
+func TestStressGC(t *testing.T) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	numGC := ms.NumGC
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 1000; i++ {
+			hugeSink = make([]byte, 1<<10)
+		}
+		done <- true
+	}()
+	runtime.StressGC(10)
+	<-done
+	hugeSink = nil
+	runtime.ReadMemStats(&ms)
+	if ms.NumGC < numGC+10 {
+		t.Errorf("StressGC(10) ran %d collections", ms.NumGC-numGC)
+	}
+}
+
+func TestLastGCTime(t *testing.T) {
+	before := time.Now().UnixNano()
+	runtime.GC()
+	after := time.Now().UnixNano()
+	if last := runtime.LastGCTime(); last < before || last > after {
+		t.Errorf("LastGCTime() = %d, want between %d and %d", last, before, after)
+	}
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	if last := runtime.LastGCTime(); uint64(last) < ms.LastGC {
+		t.Errorf("LastGCTime() = %d, before MemStats.LastGC = %d", last, ms.LastGC)
+	}
+
+	since := runtime.TimeSinceLastGC()
+	if since < 0 || since > after-before+int64(time.Second) {
+		t.Errorf("TimeSinceLastGC() = %d just after a collection", since)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if later := runtime.TimeSinceLastGC(); later < since+int64(10*time.Millisecond) {
+		t.Errorf("TimeSinceLastGC() = %d 10ms after %d", later, since)
+	}
+}
+
+var scanBytesSink []*int
+
+func TestLastGCScanBytes(t *testing.T) {
+	// A live slice of 1<<20 pointers must be scanned in full.
+	const n = 1 << 20
+	scanBytesSink = make([]*int, n)
+	for i := range scanBytesSink {
+		scanBytesSink[i] = new(int)
+	}
+	runtime.GC()
+	size := uintptr(n) * unsafe.Sizeof(scanBytesSink[0])
+	if got := runtime.LastGCScanBytes(); got < size {
+		t.Errorf("LastGCScanBytes() = %d with %d bytes of live pointers", got, size)
+	}
+	scanBytesSink = nil
+	runtime.GC()
+	if got := runtime.LastGCScanBytes(); got >= size {
+		t.Errorf("LastGCScanBytes() = %d after dropping %d bytes of live pointers", got, size)
+	}
+}
+
+func TestSetGCWorkerCount(t *testing.T) {
+	if old := runtime.SetGCWorkerCount(1); old != 0 {
+		t.Errorf("initial GC worker limit %d, want 0", old)
+	}
+	defer runtime.SetGCWorkerCount(0)
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(8))
+	if n := runtime.SetGCWorkerCount(-1); n != 1 {
+		t.Errorf("SetGCWorkerCount(-1) = %d, want 1", n)
+	}
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	numGC := ms.NumGC
+	// Let some background cycles run under the limit, watching how
+	// many dedicated mark workers run at once in each.
+	peak := 0
+	for i := 0; i < 1000; i++ {
+		hugeSink = make([]byte, 64<<10)
+		if n := runtime.GCDedicatedWorkersPeak(); n > peak {
+			peak = n
+		}
+	}
+	hugeSink = nil
+	runtime.ReadMemStats(&ms)
+	if ms.NumGC <= numGC {
+		t.Errorf("no collections ran with one GC worker")
+	}
+	if peak > 1 {
+		t.Errorf("%d dedicated mark workers ran at once with a limit of 1", peak)
+	}
+}
+
+func TestGCTriggerStats(t *testing.T) {
+	defer debug.SetGCPercent(debug.SetGCPercent(10))
+	runtime.GC()
+	checks0, fired0 := runtime.GCTriggerStats()
+	for i := 0; i < 1000; i++ {
+		hugeSink = make([]byte, 64<<10)
+	}
+	hugeSink = nil
+	checks, fired := runtime.GCTriggerStats()
+	if checks-checks0 < 1000 {
+		t.Errorf("1000 large allocations made %d trigger checks", checks-checks0)
+	}
+	if fired == fired0 || fired-fired0 > checks-checks0 {
+		t.Errorf("trigger fired %d times in %d checks", fired-fired0, checks-checks0)
+	}
+}
+
+func TestSweepStats(t *testing.T) {
+	defer debug.SetGCPercent(debug.SetGCPercent(-1))
+	runtime.GC()
+	_, total0 := runtime.SweepStats()
+	for i := 0; i < 1000; i++ {
+		hugeSink = make([]byte, 1<<10)
+	}
+	hugeSink = make([]byte, 1<<20)
+	hugeSink = nil
+	// The first collection frees the garbage; the second ends
+	// its sweep cycle.
+	runtime.GC()
+	runtime.GC()
+	last, total := runtime.SweepStats()
+	if last.Spans == 0 {
+		t.Fatalf("last sweep cycle swept no spans")
+	}
+	if last.Objects < 1000 {
+		t.Fatalf("last sweep cycle freed %d objects, want at least 1000", last.Objects)
+	}
+	if want := uint64(1000<<10 + 1<<20); last.Bytes < want {
+		t.Fatalf("last sweep cycle freed %d bytes, want at least %d", last.Bytes, want)
+	}
+	if total.Spans < total0.Spans+last.Spans ||
+		total.Objects < total0.Objects+last.Objects ||
+		total.Bytes < total0.Bytes+last.Bytes {
+		t.Fatalf("totals %+v do not include earlier totals %+v and last cycle %+v", total, total0, last)
+	}
+}
+
+func TestSetHeapWatermarks(t *testing.T) {
+	defer debug.SetGCPercent(debug.SetGCPercent(-1))
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	below := uintptr(ms.HeapAlloc / 2)
+	level := uintptr(ms.HeapAlloc + 8<<20)
+	crossed := make(chan uintptr, 2)
+	runtime.SetHeapWatermarks([]uintptr{level, below, level}, func(l uintptr) { crossed <- l })
+	defer runtime.SetHeapWatermarks(nil, nil)
+
+	var live [][]byte
+	for i := 0; i < 16; i++ {
+		live = append(live, make([]byte, 1<<20))
+	}
+	select {
+	case l := <-crossed:
+		if l != level {
+			t.Fatalf("callback for level %d, want %d", l, level)
+		}
+	case <-time.After(4 * time.Second):
+		t.Fatalf("callback not called after heap grew past watermark")
+	}
+	select {
+	case l := <-crossed:
+		t.Fatalf("unexpected second callback for level %d", l)
+	case <-time.After(100 * time.Millisecond):
+	}
+	runtime.KeepAlive(live)
+}
+
+func TestSetConcurrentSweep(t *testing.T) {
+	if !runtime.SetConcurrentSweep(false) {
+		t.Fatalf("concurrent sweep disabled by default")
+	}
+	defer runtime.SetConcurrentSweep(true)
+	if runtime.ConcurrentSweep() {
+		t.Fatalf("ConcurrentSweep() = true after SetConcurrentSweep(false)")
+	}
+	defer debug.SetGCPercent(debug.SetGCPercent(10))
+	runtime.GC()
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	numGC := ms.NumGC
+	for i := 0; i < 100 && ms.NumGC < numGC+3; i++ {
+		for j := 0; j < 100; j++ {
+			hugeSink = make([]byte, 16<<10)
+		}
+		// Background collections finish sweeping before
+		// they restart the world.
+		if !runtime.SweepDone() {
+			t.Fatalf("heap not swept after a collection with concurrent sweep disabled")
+		}
+		runtime.ReadMemStats(&ms)
+	}
+	if ms.NumGC < numGC+3 {
+		t.Fatalf("only %d collections ran", ms.NumGC-numGC)
+	}
+	hugeSink = nil
+}
+
+func TestGCBackpressure(t *testing.T) {
+	if runtime.SetGCBackpressure(true) {
+		t.Fatalf("GC backpressure enabled by default")
+	}
+	defer runtime.SetGCBackpressure(false)
+	if !runtime.GCBackpressure() {
+		t.Fatalf("GCBackpressure() = false after SetGCBackpressure(true)")
+	}
+	defer debug.SetGCPercent(debug.SetGCPercent(10))
+	runtime.GC()
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	numGC := ms.NumGC
+	const P = 4
+	done := make(chan bool)
+	for p := 0; p < P; p++ {
+		go func() {
+			var keep [8]*[64 << 10]byte
+			for i := 0; i < 1000; i++ {
+				keep[i%len(keep)] = new([64 << 10]byte)
+			}
+			runtime.KeepAlive(keep)
+			done <- true
+		}()
+	}
+	for p := 0; p < P; p++ {
+		<-done
+	}
+	runtime.ReadMemStats(&ms)
+	if ms.NumGC == numGC {
+		t.Fatalf("no GC ran while allocating with backpressure")
+	}
+}
+
+func TestAllocDeferGC(t *testing.T) {
+	defer debug.SetGCPercent(debug.SetGCPercent(1))
+	runtime.GC()
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	numGC := ms.NumGC
+	keep := make([]unsafe.Pointer, 64)
+	for i := range keep {
+		keep[i] = runtime.AllocDeferGC(64<<10, (*[8]*int)(nil))
+	}
+	runtime.ReadMemStats(&ms)
+	if ms.NumGC != numGC {
+		t.Fatalf("AllocDeferGC started %d GC cycles", ms.NumGC-numGC)
+	}
+
+	// The next ordinary large allocation starts the deferred cycle.
+	hugeSink = new([64 << 10]byte)
+	hugeSink = nil
+	for i := 0; i < 100 && ms.NumGC == numGC; i++ {
+		time.Sleep(time.Millisecond)
+		runtime.ReadMemStats(&ms)
+	}
+	if ms.NumGC == numGC {
+		t.Fatal("no GC cycle after AllocDeferGC allocations")
+	}
+	runtime.KeepAlive(keep)
+}
+
+type externalObj struct {
+	next *externalObj
+}
+
+// allocExternal allocates an object with AllocExternal and stores the
+// only pointer to it where the garbage collector does not look.
+//go:noinline
+func allocExternal(finalized chan bool) *unsafe.Pointer {
+	p := runtime.AllocExternal(unsafe.Sizeof(externalObj{}), (*externalObj)(nil))
+	runtime.SetFinalizer((*externalObj)(p), func(*externalObj) { finalized <- true })
+	hidden := (*unsafe.Pointer)(runtime.AllocPermanent(unsafe.Sizeof(p), nil))
+	*hidden = p
+	return hidden
+}
+
+func TestAllocExternal(t *testing.T) {
+	finalized := make(chan bool, 1)
+	hidden := allocExternal(finalized)
+	for i := 0; i < 3; i++ {
+		runtime.GC()
+	}
+	select {
+	case <-finalized:
+		t.Fatal("object allocated by AllocExternal freed before ReleaseExternal")
+	case <-time.After(10 * time.Millisecond):
+	}
+	runtime.ReleaseExternal(*hidden)
+	*hidden = nil
+	// Small pointer-free objects must not get 8-byte blocks, which
+	// GODEBUG=gccheckmark=1 scans.
+	for _, size := range []uintptr{1, 8, 15} {
+		p := runtime.AllocExternal(size, nil)
+		if n := runtime.ObjectSize(p); n != 16 {
+			t.Errorf("AllocExternal(%d, nil) allocated a %d-byte block, want 16", size, n)
+		}
+		runtime.ReleaseExternal(p)
+	}
+	for i := 0; i < 3; i++ {
+		runtime.GC()
+	}
+	select {
+	case <-finalized:
+	case <-time.After(time.Second):
+		t.Fatal("object not finalized after ReleaseExternal")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("ReleaseExternal of an ordinary object did not panic")
+		}
+	}()
+	runtime.ReleaseExternal(unsafe.Pointer(new(externalObj)))
+}
+
 var hugeSink interface{}
 
 func TestHugeGCInfo(t *testing.T) {
@@ -490,3 +1091,65 @@ func TestPageAccounting(t *testing.T) {
 		t.Fatalf("mheap_.pagesInUse is %d, but direct count is %d", pagesInUse, counted)
 	}
 }
+
+func TestScavengePace(t *testing.T) {
+	defer runtime.SetScavengePace(runtime.SetScavengePace(1 << 20))
+	if pace := runtime.ScavengePace(); pace != 1<<20 {
+		t.Fatalf("ScavengePace() = %d, want %d", pace, 1<<20)
+	}
+
+	// Free a few large spans and release them one step at a time.
+	for i := 0; i < 4; i++ {
+		hugeSink = new([1 << 20]byte)
+		runtime.GC()
+	}
+	hugeSink = nil
+	runtime.GC()
+	steps := 0
+	for runtime.ScavengeStep(1) {
+		steps++
+		if steps > 1e6 {
+			t.Fatal("scavenge pass did not finish")
+		}
+	}
+	if steps == 0 {
+		t.Fatal("scavenge pass released everything in one step of 1 byte")
+	}
+	if runtime.ScavengeStep(^uintptr(0)) {
+		t.Fatal("unlimited scavenge step did not finish the pass")
+	}
+}
+
+func TestSetMinHeap(t *testing.T) {
+	defer runtime.SetMinHeap(runtime.SetMinHeap(^uintptr(0)))
+	if min := runtime.MinHeap(); min != ^uintptr(0) {
+		t.Fatalf("MinHeap() = %#x, want %#x", min, ^uintptr(0))
+	}
+
+	var st runtime.MemStats
+	free := func() {
+		for i := 0; i < 4; i++ {
+			hugeSink = new([1 << 20]byte)
+			runtime.GC()
+		}
+		hugeSink = nil
+		runtime.GC()
+		runtime.ReadMemStats(&st)
+	}
+	free()
+	released := st.HeapReleased
+	runtime.ScavengeAll()
+	runtime.ReadMemStats(&st)
+	if st.HeapReleased != released {
+		t.Errorf("scavenger released %d bytes below the floor", st.HeapReleased-released)
+	}
+
+	runtime.SetMinHeap(0)
+	free()
+	released = st.HeapReleased
+	runtime.ScavengeAll()
+	runtime.ReadMemStats(&st)
+	if st.HeapReleased <= released {
+		t.Errorf("scavenger released nothing with no floor")
+	}
+}
@@ -0,0 +1,29 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build grayalloc
+
+// Experimental gray allocation, enabled by the grayalloc build tag.
+
+package runtime
+
+import "unsafe"
+
+const grayAllocEnabled = true
+
+// AllocGray allocates a zeroed block of size bytes, like AllocDeferGC,
+// but if a garbage collection is marking the heap, the block starts
+// gray rather than black: the collector queues it to be scanned, as if
+// it had found a pointer to it, instead of treating it as already
+// scanned. It exists for experiments with the collector's allocation
+// policy and is only available when the runtime is built with the
+// grayalloc tag, since it changes the work the collector does for
+// every such object.
+//
+// The arguments are as for AllocDeferGC: typ must be nil or a pointer
+// value such as (*T)(nil) describing the layout of the block.
+func AllocGray(size uintptr, typ interface{}) unsafe.Pointer {
+	t := allocElemType("AllocGray", size, typ)
+	return mallocgc(size, t, flagGrayOnAlloc)
+}
@@ -0,0 +1,11 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !grayalloc
+
+package runtime
+
+// grayAllocEnabled reports whether flagGrayOnAlloc takes effect.
+// Build with the grayalloc tag to enable it.
+const grayAllocEnabled = false
@@ -0,0 +1,44 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build grayalloc
+
+package runtime_test
+
+import (
+	"runtime"
+	"runtime/debug"
+	"testing"
+	"unsafe"
+)
+
+type grayNode struct {
+	next *grayNode
+	val  int
+}
+
+func TestAllocGray(t *testing.T) {
+	// Build lists of gray-allocated nodes while background
+	// collections run, and check that none of the nodes is freed
+	// while reachable.
+	defer debug.SetGCPercent(debug.SetGCPercent(5))
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	numGC := ms.NumGC
+	for ms.NumGC < numGC+10 {
+		var head *grayNode
+		for i := 0; i < 1000; i++ {
+			n := (*grayNode)(runtime.AllocGray(unsafe.Sizeof(grayNode{}), (*grayNode)(nil)))
+			n.next, n.val = head, i
+			head = n
+		}
+		for i := 999; i >= 0; i-- {
+			if head.val != i {
+				t.Fatalf("node %d has value %d", i, head.val)
+			}
+			head = head.next
+		}
+		runtime.ReadMemStats(&ms)
+	}
+}
@@ -447,7 +447,7 @@ func dumproots() {
 					continue
 				}
 				spf := (*specialfinalizer)(unsafe.Pointer(sp))
-				p := unsafe.Pointer(s.base() + uintptr(spf.special.offset))
+				p := unsafe.Pointer(s.base() + uintptr(spf.special.offset) + spf.argoff)
 				dumpfinalizer(p, spf.fn, spf.fint, spf.ot)
 			}
 		}
@@ -694,6 +694,7 @@ func dumpbvtypes(bv *bitvector, base unsafe.Pointer) {
 }
 
 func makeheapobjbv(p uintptr, size uintptr) bitvector {
+	spanOfUnchecked(p).ensureHeapBits()
 	// Extend the temp buffer if necessary.
 	nptr := size / sys.PtrSize
 	if uintptr(len(tmpbuf)) < nptr/8+1 {
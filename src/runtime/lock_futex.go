@@ -102,6 +102,21 @@ func lock(l *mutex) {
 	}
 }
 
+// trylock acquires l if it is not held and reports whether it did.
+// It never spins or sleeps.
+func trylock(l *mutex) bool {
+	gp := getg()
+	if gp.m.locks < 0 {
+		throw("runtime·lock: lock count")
+	}
+	gp.m.locks++
+	if atomic.Cas(key32(&l.key), mutex_unlocked, mutex_locked) {
+		return true
+	}
+	gp.m.locks--
+	return false
+}
+
 func unlock(l *mutex) {
 	v := atomic.Xchg(key32(&l.key), mutex_unlocked)
 	if v == mutex_unlocked {
@@ -89,6 +89,21 @@ Loop:
 	}
 }
 
+// trylock acquires l if it is not held and reports whether it did.
+// It never spins or sleeps.
+func trylock(l *mutex) bool {
+	gp := getg()
+	if gp.m.locks < 0 {
+		throw("runtime·lock: lock count")
+	}
+	gp.m.locks++
+	if atomic.Casuintptr(&l.key, 0, locked) {
+		return true
+	}
+	gp.m.locks--
+	return false
+}
+
 //go:nowritebarrier
 // We might not be holding a p in this code.
 func unlock(l *mutex) {
@@ -80,16 +80,45 @@
 package runtime
 
 import (
+	"runtime/internal/atomic"
 	"runtime/internal/sys"
 	"unsafe"
 )
 
+// Flags for mallocgc.
+const (
+	flagNoZero      = 1 << iota // don't zero memory
+	flagNoGCTrigger             // don't start a GC cycle even if the heap has reached the trigger
+	flagFill                    // set pointer-free memory to allocFill, if nonzero, instead of zeroing it
+	flagCold                    // allocate from the cold spans; see AllocCold
+	flagNoProfile               // don't sample the allocation for the memory profile
+	flagLazyBitmap              // leave the heap bitmap of a large object for the GC to write
+	flagHotMutable              // give each object cache lines of its own; see AllocHotMutable
+	flagGrayOnAlloc             // allocate gray, not black, during GC; needs the grayalloc build tag
+	flagDMA                     // release the pages of a large object when it is freed; see AllocDMA
+	flagLarge                   // allocate a large object, whatever the size; see AllocPageAligned
+	flagExternalRef             // keep the object alive until ReleaseExternal; see AllocExternal
+	flagStreamZero              // zero a large object with non-temporal stores; see AllocStreamingZero
+	flagEphemeral               // scavenge the pages of a large object soon after it is freed; see AllocWithLifetime
+	flagPersistent              // keep the pages of a large object after it is freed; see AllocWithLifetime
+	flagTypedPrefix             // only the first typ.size bytes hold a typ, the rest is scalar; see AllocSSO
+	flagZeroPolicy              // the SetAllocZeroPolicy range applies; set for make outside the runtime
+)
+
+// flagClassShift is the position of the size class in the top byte of
+// the mallocgc flags. A nonzero class there is the already validated
+// size class of a small object, which mallocgc then need not compute;
+// see AllocInClass.
+const flagClassShift = 24
+
 const (
 	debugMalloc = false
 
-	maxTinySize   = _TinySize
-	tinySizeClass = _TinySizeClass
-	maxSmallSize  = _MaxSmallSize
+	maxTinySize          = _TinySize
+	tinySizeClass        = _TinySizeClass
+	lowFragTinySize      = _LowFragTinySize
+	lowFragTinySizeClass = _LowFragTinySizeClass
+	maxSmallSize         = _MaxSmallSize
 
 	pageShift = _PageShift
 	pageSize  = _PageSize
@@ -99,8 +128,6 @@ const (
 	maxObjsPerSpan = pageSize / 8
 
 	mSpanInUse = _MSpanInUse
-
-	concurrentSweep = _ConcurrentSweep
 )
 
 const (
@@ -127,6 +154,10 @@ const (
 	_TinySize      = 16
 	_TinySizeClass = 2
 
+	// Tiny allocator block size in low-fragmentation mode (see SetLowFragMode).
+	_LowFragTinySize      = 8
+	_LowFragTinySizeClass = 1
+
 	_FixAllocChunk  = 16 << 10               // Chunk size for FixAlloc
 	_MaxMHeapList   = 1 << (20 - _PageShift) // Maximum page length for fixed-size list in MHeap.
 	_HeapAllocChunk = 1 << 20                // Chunk size for heap growth
@@ -216,6 +247,9 @@ func mallocinit() {
 	if class_to_size[_TinySizeClass] != _TinySize {
 		throw("bad TinySizeClass")
 	}
+	if class_to_size[_LowFragTinySizeClass] != _LowFragTinySize {
+		throw("bad LowFragTinySizeClass")
+	}
 
 	var p, bitmapSize, spansSize, pSize, limit uintptr
 	var reserved bool
@@ -485,14 +519,40 @@ func nextFreeFast(s *mspan) gclinkptr {
 	return 0
 }
 
+// mallocTSCPeriod is how often GODEBUG=malloctsc times the fast path:
+// once every mallocTSCPeriod calls on each P.
+const mallocTSCPeriod = 64
+
+// nextFreeFastTimed is nextFreeFast for GODEBUG=malloctsc=1. Every
+// mallocTSCPeriod'th call on c, it times nextFreeFast with the CPU
+// timestamp counter and records the time if an object was found.
+func (c *mcache) nextFreeFastTimed(s *mspan) gclinkptr {
+	c.tscsample++
+	if c.tscsample < mallocTSCPeriod {
+		return nextFreeFast(s)
+	}
+	c.tscsample = 0
+	t0 := cputicks()
+	v := nextFreeFast(s)
+	t1 := cputicks()
+	if v != 0 {
+		recordMallocCycles(t1 - t0)
+	}
+	return v
+}
+
 // nextFree returns the next free object from the cached span if one is available.
 // Otherwise it refills the cache with a span with an available object and
 // returns that object along with a flag indicating that this was a heavy
 // weight allocation. If it is a heavy weight allocation the caller must
 // determine whether a new GC cycle needs to be started or if the GC is active
 // whether this goroutine needs to assist the GC.
-func (c *mcache) nextFree(sizeclass int8) (v gclinkptr, s *mspan, shouldhelpgc bool) {
-	s = c.alloc[sizeclass]
+func (c *mcache) nextFree(sizeclass int8, cold bool) (v gclinkptr, s *mspan, shouldhelpgc bool) {
+	alloc := &c.alloc
+	if cold {
+		alloc = &c.coldalloc
+	}
+	s = alloc[sizeclass]
 	shouldhelpgc = false
 	freeIndex := s.nextFreeIndex()
 	if freeIndex == s.nelems {
@@ -502,10 +562,11 @@ func (c *mcache) nextFree(sizeclass int8) (v gclinkptr, s *mspan, shouldhelpgc b
 			throw("s.allocCount != s.nelems && freeIndex == s.nelems")
 		}
 		systemstack(func() {
-			c.refill(int32(sizeclass))
+			c.refill(int32(sizeclass), cold)
 		})
+		c.local_nrefill++
 		shouldhelpgc = true
-		s = c.alloc[sizeclass]
+		s = alloc[sizeclass]
 
 		freeIndex = s.nextFreeIndex()
 	}
@@ -526,13 +587,25 @@ func (c *mcache) nextFree(sizeclass int8) (v gclinkptr, s *mspan, shouldhelpgc b
 // Allocate an object of size bytes.
 // Small objects are allocated from the per-P cache's free lists.
 // Large objects (> 32 kB) are allocated straight from the heap.
-func mallocgc(size uintptr, typ *_type, needzero bool) unsafe.Pointer {
+// The flags modify the allocation; see flagNoZero and the following.
+func mallocgc(size uintptr, typ *_type, flags uint32) unsafe.Pointer {
 	if gcphase == _GCmarktermination {
 		throw("mallocgc called with gcphase == _GCmarktermination")
 	}
 
 	if size == 0 {
-		return unsafe.Pointer(&zerobase)
+		if atomic.Load(&zeroSizedPolicy) == uint32(ZeroSizedShared) {
+			return unsafe.Pointer(&zerobase)
+		}
+		size, typ = 1, nil
+	}
+
+	if gp := getg(); gp.allocbudgeton {
+		// Only charge the allocation: it may be made partway
+		// through an operation, such as a map assignment, that
+		// must not be interrupted by a panic. WithAllocBudget
+		// checks the budget when its function returns.
+		gp.allocbudget -= int64(size)
 	}
 
 	if debug.sbrk != 0 {
@@ -572,15 +645,48 @@ func mallocgc(size uintptr, typ *_type, needzero bool) unsafe.Pointer {
 	if mp.gsignal == getg() {
 		throw("malloc during signal")
 	}
+	if getg().noalloc {
+		throw("heap allocation in finalizer set by SetFinalizerNoAlloc")
+	}
 	mp.mallocing = 1
 
 	shouldhelpgc := false
+	needzero := flags&flagNoZero == 0
+	var lazy *mspan // large object whose bitmap is left to the GC
 	dataSize := size
+	if flags&flagHotMutable != 0 && size <= maxSmallSize {
+		size = hotMutableSize(size)
+	}
 	c := gomcache()
+	if c.avgsample++; c.avgsample == avgAllocSamplePeriod {
+		c.avgsample = 0
+		if dataSize <= maxAvgAllocSize {
+			c.avgsize += dataSize - c.avgsize>>avgAllocSizeShift
+		} else {
+			c.avgsize += maxAvgAllocSize - c.avgsize>>avgAllocSizeShift
+		}
+	}
+	c.local_sizehist[allocSizeBucket(dataSize)]++
+	if dataSize > c.local_maxalloc {
+		c.local_maxalloc = dataSize
+	}
 	var x unsafe.Pointer
+	var slowStart int64 // when the slow path began, if timed for SetAllocLatencyBudget
 	noscan := typ == nil || typ.kind&kindNoPointers != 0
-	if size <= maxSmallSize {
-		if noscan && size < maxTinySize {
+	if debug.noscancheck != 0 {
+		noscanCheck(typ, noscan, flags)
+	}
+	if noZeroMax != 0 && flags&flagZeroPolicy != 0 && noscan && noZeroMin <= dataSize && dataSize <= noZeroMax {
+		needzero = false
+	}
+	// fill is set if the memory is to be set to allocFill, not zeroed.
+	// Such objects bypass the tiny allocator, which shares blocks.
+	fill := flags&flagFill != 0 && needzero && noscan && allocFill != 0
+	// stream is set if a large object is to be zeroed here with
+	// non-temporal stores, not by the heap.
+	stream := flags&flagStreamZero != 0 && needzero && !fill && nonTemporalClear
+	if size <= maxSmallSize && flags&flagLarge == 0 {
+		if noscan && size < maxTinySize && tinyAllocOff == 0 && (!lowFragMode || size < lowFragTinySize) && !fill && flags&(flagCold|flagExternalRef|flagTypedPrefix) == 0 {
 			// Tiny allocator.
 			//
 			// Tiny allocator combines several tiny allocation requests
@@ -610,6 +716,13 @@ func mallocgc(size uintptr, typ *_type, needzero bool) unsafe.Pointer {
 			// standalone escaping variables. On a json benchmark
 			// the allocator reduces number of allocations by ~12% and
 			// reduces heap size by ~20%.
+			//
+			// In low-fragmentation mode the block size is 8 bytes,
+			// so that there is no worst case wastage.
+			tinySize, tinyClass := uintptr(maxTinySize), int8(tinySizeClass)
+			if lowFragMode {
+				tinySize, tinyClass = lowFragTinySize, lowFragTinySizeClass
+			}
 			off := c.tinyoffset
 			// Align tiny pointer for required (conservative) alignment.
 			if size&7 == 0 {
@@ -619,7 +732,7 @@ func mallocgc(size uintptr, typ *_type, needzero bool) unsafe.Pointer {
 			} else if size&1 == 0 {
 				off = round(off, 2)
 			}
-			if off+size <= maxTinySize && c.tiny != 0 {
+			if off+size <= tinySize && c.tiny != 0 {
 				// The object fits into existing tiny block.
 				x = unsafe.Pointer(c.tiny + off)
 				c.tinyoffset = off + size
@@ -628,50 +741,124 @@ func mallocgc(size uintptr, typ *_type, needzero bool) unsafe.Pointer {
 				releasem(mp)
 				return x
 			}
-			// Allocate a new maxTinySize block.
-			span := c.alloc[tinySizeClass]
-			v := nextFreeFast(span)
+			// Allocate a new tinySize block.
+			span := c.alloc[tinyClass]
+			var v gclinkptr
+			if debug.malloctsc != 0 {
+				v = c.nextFreeFastTimed(span)
+			} else {
+				v = nextFreeFast(span)
+			}
 			if v == 0 {
-				v, _, shouldhelpgc = c.nextFree(tinySizeClass)
+				if allocLatency != nil {
+					slowStart = nanotime()
+				}
+				v, _, shouldhelpgc = c.nextFree(tinyClass, false)
 			}
 			x = unsafe.Pointer(v)
 			(*[2]uint64)(x)[0] = 0
-			(*[2]uint64)(x)[1] = 0
+			if tinySize == maxTinySize {
+				(*[2]uint64)(x)[1] = 0
+			}
 			// See if we need to replace the existing tiny block with the new one
 			// based on amount of remaining free space.
 			if size < c.tinyoffset || c.tiny == 0 {
 				c.tiny = uintptr(x)
 				c.tinyoffset = size
 			}
-			size = maxTinySize
+			size = tinySize
+			c.local_cachealloc += size
+			c.local_nalloc++
+			c.local_nclassalloc[tinyClass]++
 		} else {
-			var sizeclass int8
-			if size <= 1024-8 {
-				sizeclass = size_to_class8[(size+7)>>3]
-			} else {
-				sizeclass = size_to_class128[(size-1024+127)>>7]
+			sizeclass := int8(flags >> flagClassShift)
+			if sizeclass == 0 {
+				if size < maxTinySize && noscan && tinyAllocOff == 0 && !lowFragMode {
+					// A small pointer-free object kept out of
+					// the tiny allocator, say by SetAllocFill.
+					// Give it a block of the tiny allocator's
+					// size: GODEBUG=gccheckmark=1 takes every
+					// 8-byte block for a pointer.
+					sizeclass = tinySizeClass
+				} else if size <= 1024-8 {
+					sizeclass = size_to_class8[(size+7)>>3]
+				} else {
+					sizeclass = size_to_class128[(size-1024+127)>>7]
+				}
 			}
 			size = uintptr(class_to_size[sizeclass])
+			cold := flags&flagCold != 0
 			span := c.alloc[sizeclass]
-			v := nextFreeFast(span)
+			if cold {
+				span = c.coldalloc[sizeclass]
+			}
+			var v gclinkptr
+			if debug.malloctsc != 0 {
+				v = c.nextFreeFastTimed(span)
+			} else {
+				v = nextFreeFast(span)
+			}
 			if v == 0 {
-				v, span, shouldhelpgc = c.nextFree(sizeclass)
+				if allocLatency != nil {
+					slowStart = nanotime()
+				}
+				v, span, shouldhelpgc = c.nextFree(sizeclass, cold)
 			}
 			x = unsafe.Pointer(v)
-			if needzero && span.needzero != 0 {
+			if fill {
+				memfill(x, size, allocFill)
+			} else if needzero && span.needzero != 0 {
 				memclr(unsafe.Pointer(v), size)
 			}
+			c.local_cachealloc += size
+			c.local_nalloc++
+			c.local_nclassalloc[sizeclass]++
 		}
 	} else {
 		var s *mspan
 		shouldhelpgc = true
+		if allocLatency != nil {
+			slowStart = nanotime()
+		}
 		systemstack(func() {
-			s = largeAlloc(size, needzero)
+			s = largeAlloc(size, needzero && !fill && !stream)
 		})
+		if s == nil {
+			mp.mallocing = 0
+			releasem(mp)
+			if assistG != nil {
+				assistG.gcAssistBytes += int64(dataSize)
+			}
+			allocFailed("out of memory")
+		}
+		c.local_nlarge++
+		c.local_nclassalloc[0]++
+		if flags&flagDMA != 0 {
+			s.dma = true
+		}
+		switch {
+		case flags&flagEphemeral != 0:
+			s.lifetime = uint8(LifetimeEphemeral)
+		case flags&flagPersistent != 0:
+			s.lifetime = uint8(LifetimePersistent)
+		}
 		s.freeindex = 1
 		s.allocCount = 1
 		x = unsafe.Pointer(s.base())
 		size = s.elemsize
+		if fill {
+			memfill(x, size, allocFill)
+		}
+		if stream && s.needzero != 0 {
+			memclrNoTemporal(x, size)
+			s.needzero = 0
+		}
+		// The phase cannot change until releasem, so an object
+		// allocated during GC, which is allocated black, always
+		// gets its bitmap now.
+		if flags&flagLazyBitmap != 0 && gcphase == _GCoff {
+			lazy = s
+		}
 	}
 
 	var scanSize uintptr
@@ -687,7 +874,18 @@ func mallocgc(size uintptr, typ *_type, needzero bool) unsafe.Pointer {
 		if typ == deferType {
 			dataSize = unsafe.Sizeof(_defer{})
 		}
-		heapBitsSetType(uintptr(x), size, dataSize, typ)
+		// Likewise, the inline buffer after a typed prefix is
+		// recorded as holding no pointers.
+		if flags&flagTypedPrefix != 0 {
+			dataSize = typ.size
+		}
+		if lazy != nil {
+			lazy.lazytype = typ
+			lazy.lazysize = dataSize
+			atomic.Store(&lazy.lazybits, lazyBitsPending)
+		} else {
+			heapBitsSetType(uintptr(x), size, dataSize, typ)
+		}
 		if dataSize > typ.size {
 			// Array allocation. If there are any
 			// pointers, GC has to scan to the last
@@ -714,7 +912,11 @@ func mallocgc(size uintptr, typ *_type, needzero bool) unsafe.Pointer {
 	// This may be racing with GC so do it atomically if there can be
 	// a race marking the bit.
 	if gcphase != _GCoff {
-		gcmarknewobject(uintptr(x), size, scanSize)
+		if grayAllocEnabled && flags&flagGrayOnAlloc != 0 {
+			gcgraynewobject(uintptr(x), size, noscan)
+		} else {
+			gcmarknewobject(uintptr(x), size, scanSize)
+		}
 	}
 
 	if raceenabled {
@@ -725,6 +927,9 @@ func mallocgc(size uintptr, typ *_type, needzero bool) unsafe.Pointer {
 		msanmalloc(x, size)
 	}
 
+	if shouldhelpgc && flags&flagNoGCTrigger == 0 {
+		c.local_ntrigcheck++
+	}
 	mp.mallocing = 0
 	releasem(mp)
 
@@ -732,7 +937,29 @@ func mallocgc(size uintptr, typ *_type, needzero bool) unsafe.Pointer {
 		tracealloc(x, size, typ)
 	}
 
-	if rate := MemProfileRate; rate > 0 {
+	if allocTraceEnabled {
+		allocTraceRecord(x, size, typ)
+	}
+
+	if lgp := getg().m.curg; lgp != nil && lgp.alloclabel != 0 {
+		allocLabelRecord(lgp.alloclabel, size)
+	}
+
+	if m := atomic.Load(&typeAllocLimits.mask); m != 0 && typ != nil && m&typeAllocLimitBit(typ) != 0 {
+		typeAllocThrottle(typ)
+	}
+
+	if debug.objtypes != 0 && typ != nil {
+		systemstack(func() {
+			settypespecial(x, typ)
+		})
+	}
+
+	if flags&flagExternalRef != 0 {
+		addexternal(x)
+	}
+
+	if rate := MemProfileRate; rate > 0 && flags&flagNoProfile == 0 && !profileExcluded() {
 		if size < uintptr(rate) && int32(size) < c.next_sample {
 			c.next_sample -= int32(size)
 		} else {
@@ -742,24 +969,48 @@ func mallocgc(size uintptr, typ *_type, needzero bool) unsafe.Pointer {
 		}
 	}
 
+	if allocEvents != nil {
+		allocEvent(x, size, typ)
+	}
+
 	if assistG != nil {
 		// Account for internal fragmentation in the assist
 		// debt now that we know it.
 		assistG.gcAssistBytes -= int64(size - dataSize)
 	}
 
-	if shouldhelpgc && gcShouldStart(false) {
-		gcStart(gcBackgroundMode, false)
+	if shouldhelpgc && atomic.Load64(&memstats.heap_live) >= atomic.Load64(&heapWatermarkNext) {
+		heapWatermarkCheck()
+	}
+
+	if shouldhelpgc && flags&flagNoGCTrigger == 0 {
+		if gcShouldStart(false) {
+			mp := acquirem()
+			gomcache().local_ntrigfire++
+			releasem(mp)
+			gcStart(gcBackgroundMode, false)
+			if gcBackpressure != 0 {
+				gcBackpressureWait(true)
+			}
+		} else if gcBackpressure != 0 {
+			gcBackpressureWait(false)
+		}
+	}
+
+	if slowStart != 0 {
+		allocLatencyCheck(dataSize, slowStart)
 	}
 
 	return x
 }
 
+// largeAlloc allocates a span for a large object of size bytes.
+// It returns nil if the heap cannot provide one.
 func largeAlloc(size uintptr, needzero bool) *mspan {
 	// print("largeAlloc size=", size, "\n")
 
 	if size+_PageSize < size {
-		throw("out of memory")
+		return nil
 	}
 	npages := size >> _PageShift
 	if size&_PageMask != 0 {
@@ -773,16 +1024,133 @@ func largeAlloc(size uintptr, needzero bool) *mspan {
 
 	s := mheap_.alloc(npages, 0, true, needzero)
 	if s == nil {
-		throw("out of memory")
+		return nil
 	}
 	s.limit = s.base() + size
 	heapBitsForSpan(s.base()).initSpan(s)
 	return s
 }
 
+// An AllocErrorMode says what happens when an allocation fails.
+// See SetAllocErrorMode.
+type AllocErrorMode int
+
+const (
+	AllocErrorFatal AllocErrorMode = iota // crash the program
+	AllocErrorPanic                       // panic in the allocating goroutine
+)
+
+// allocErrorMode is the AllocErrorMode set by SetAllocErrorMode.
+var allocErrorMode uint32
+
+// SetAllocErrorMode sets what happens when a heap allocation fails and
+// returns the previous mode. By default (AllocErrorFatal) the runtime
+// crashes the program with a fatal error, as it does for any internal
+// failure. With AllocErrorPanic, failures that leave the runtime in a
+// consistent state instead panic in the goroutine that tried to
+// allocate, with a runtime.Error whose message begins with
+// "runtime: out of memory", so that a server can recover, release
+// memory, and shut down cleanly or carry on.
+//
+// The recoverable failures are those of allocating a large object
+// (one of more than 32 kB): a size too big to represent in the address
+// space, and a heap that cannot grow to hold the object because its
+// address space is used up or the operating system refuses to reserve
+// more. Failing this way leaves nothing allocated. Everything else
+// stays fatal in either mode: running out of memory for small objects,
+// for goroutine stacks, or for the runtime's own data structures;
+// the operating system refusing to commit memory already reserved;
+// and internal errors such as an allocation from a signal handler or
+// one started while another allocation on the same thread is in
+// progress ("malloc deadlock"). Allocations made while the runtime
+// holds a lock, or with the world stopped, also crash rather than
+// panic, since unwinding them would leave the lock held.
+func SetAllocErrorMode(mode AllocErrorMode) AllocErrorMode {
+	return AllocErrorMode(atomic.Xchg(&allocErrorMode, uint32(mode)))
+}
+
+// noscanCheck implements GODEBUG=noscancheck=1 for an allocation of
+// type typ with mallocgc flags flags, which the allocator has decided
+// does (!noscan) or does not (noscan) contain pointers.
+func noscanCheck(typ *_type, noscan bool, flags uint32) {
+	if typ != nil && noscan != (typ.ptrdata == 0) {
+		print("runtime: type ", typ.string(), " has ", typ.ptrdata, " bytes of pointer data but kindNoPointers=", noscan, "\n")
+		throw("mallocgc: pointer-free flag disagrees with type")
+	}
+	if !noscan && flags&flagNoZero != 0 {
+		print("runtime: type ", typ.string(), "\n")
+		throw("mallocgc: unzeroed allocation of type with pointers")
+	}
+}
+
+// allocFailed reports a failed heap allocation. It panics if the
+// AllocErrorMode and the state of the current goroutine allow it,
+// and otherwise throws. The caller must not hold any locks or be in
+// the middle of an allocation.
+func allocFailed(msg string) {
+	gp := getg()
+	if AllocErrorMode(atomic.Load(&allocErrorMode)) == AllocErrorPanic &&
+		gp == gp.m.curg && gp.m.locks == 0 && gp.m.mallocing == 0 && gp.m.preemptoff == "" {
+		panic(plainError("runtime: " + msg))
+	}
+	throw(msg)
+}
+
+// WithAllocBudget calls fn, allowing the calling goroutine to allocate
+// at most bytes bytes of heap memory while it runs. If fn goes over the
+// budget, WithAllocBudget panics once fn returns, reporting by how much,
+// so a test can check that a piece of code keeps to the allocations it
+// is meant to make:
+//
+//	runtime.WithAllocBudget(64, func() { encode(buf, v) })
+//
+// The bytes counted are those requested, before rounding up to a size
+// class, including memory allocated implicitly, such as by append, map
+// insertions, closures and conversions to interfaces. Only allocations
+// by the calling goroutine count, not those of goroutines started by fn.
+// Budgets nest: what fn allocates under an inner budget is charged to
+// the enclosing one too when the inner call returns. The budget ends
+// when fn returns or panics.
+func WithAllocBudget(bytes uint64, fn func()) {
+	gp := getg()
+	budget := int64(bytes)
+	if budget < 0 {
+		budget = 1<<63 - 1
+	}
+	on, left := gp.allocbudgeton, gp.allocbudget
+	defer func() {
+		used := budget - gp.allocbudget
+		gp.allocbudgeton, gp.allocbudget = on, left-used
+	}()
+	gp.allocbudgeton, gp.allocbudget = true, budget
+	fn()
+	if gp.allocbudget < 0 {
+		over := uint64(-gp.allocbudget)
+		// Don't charge the message.
+		gp.allocbudgeton = false
+		var buf [20]byte
+		msg := "runtime: allocation budget of " + string(itoaDiv(buf[:], uint64(budget), 0)) + " bytes"
+		panic(plainError(msg + " exceeded by " + string(itoaDiv(buf[:], over, 0)) + " bytes"))
+	}
+}
+
+// InMalloc reports whether the calling thread is in the middle of a
+// heap allocation. Ordinary Go code never observes this, since the
+// allocator runs no user code, but a hook that can interrupt arbitrary
+// code on the same thread, such as a signal-driven profiler, must not
+// allocate while InMalloc is true: the nested allocation would crash
+// the program with "malloc deadlock". Such hooks should defer their
+// work until later instead.
+func InMalloc() bool {
+	mp := acquirem()
+	r := mp.mallocing != 0
+	releasem(mp)
+	return r
+}
+
 // implementation of new builtin
 func newobject(typ *_type) unsafe.Pointer {
-	return mallocgc(typ.size, typ, true)
+	return mallocgc(typ.size, typ, 0)
 }
 
 //go:linkname reflect_unsafe_New reflect.unsafe_New
@@ -795,7 +1163,7 @@ func newarray(typ *_type, n int) unsafe.Pointer {
 	if n < 0 || uintptr(n) > maxSliceCap(typ.size) {
 		panic(plainError("runtime: allocation size out of range"))
 	}
-	return mallocgc(typ.size*uintptr(n), typ, true)
+	return mallocgc(typ.size*uintptr(n), typ, 0)
 }
 
 //go:linkname reflect_unsafe_NewArray reflect.unsafe_NewArray
@@ -803,6 +1171,1252 @@ func reflect_unsafe_NewArray(typ *_type, n int) unsafe.Pointer {
 	return newarray(typ, n)
 }
 
+// A ZeroSizedPolicy says how the heap allocates zero-sized objects.
+// See SetZeroSizedPolicy.
+type ZeroSizedPolicy int
+
+const (
+	ZeroSizedShared   ZeroSizedPolicy = iota // all share one address
+	ZeroSizedDistinct                        // each gets an address of its own
+)
+
+// zeroSizedPolicy is the ZeroSizedPolicy set by SetZeroSizedPolicy.
+var zeroSizedPolicy uint32
+
+// SetZeroSizedPolicy sets how zero-sized objects, such as the values of
+// new(struct{}) or make([]int, 0), are allocated on the heap and returns
+// the previous policy. With ZeroSizedShared, the default, they all get
+// the same address and take no memory, so two pointers to distinct
+// zero-sized objects may compare equal. With ZeroSizedDistinct, each
+// gets a one-byte block of its own, as from NewDistinctZero, so pointers
+// to zero-sized objects can serve as identities, for example as keys of
+// a set, for as long as the objects are reachable.
+//
+// ZeroSizedDistinct costs memory for every zero-sized allocation in the
+// program, including those made by libraries and the standard library,
+// such as the backing arrays of empty slices: one byte each, packed into
+// small shared blocks that are freed only when all the objects in them
+// are unreachable, plus the CPU time of an allocation. Zero-sized values
+// that the compiler places on the stack or in static data are not
+// affected, and may still share addresses.
+func SetZeroSizedPolicy(policy ZeroSizedPolicy) ZeroSizedPolicy {
+	return ZeroSizedPolicy(atomic.Xchg(&zeroSizedPolicy, uint32(policy)))
+}
+
+// NewDistinctZero returns a pointer that can stand in for a pointer to
+// a zero-sized object, such as a *struct{}, but that is distinct from
+// every other pointer returned by NewDistinctZero while it is reachable.
+//
+// Allocations of zero-sized objects normally all share a single address,
+// which costs no memory but makes such pointers useless as identities.
+// NewDistinctZero instead allocates one byte from the heap (combined with
+// other tiny allocations where possible), so each result occupies real
+// memory until it becomes unreachable. The byte must not be written.
+func NewDistinctZero() unsafe.Pointer {
+	return mallocgc(1, nil, 0)
+}
+
+// Alloc16 allocates a zeroed block of at least size bytes whose address
+// is a multiple of 16, as needed for double-width compare-and-swap.
+// Every size is supported. Small blocks come from the size class for
+// size rounded up to 16 bytes; every class from 16 bytes up is a
+// multiple of 16, so its objects are 16-aligned. Large blocks are
+// always page-aligned. A zero size allocates 16 bytes rather than
+// returning the shared zero-sized address.
+//
+// Alloc16 looks up the size class itself and passes it on, so the
+// allocation takes the same path as new(T), without the tiny allocator,
+// which packs small pointer-free objects at lesser alignments.
+//
+// The argument typ describes the memory layout of the block, so the
+// garbage collector knows where it holds pointers, which lets lock-free
+// nodes keep pointers in a block updated by double-width CAS. It must
+// be nil, for a block containing no pointers, or a pointer value such
+// as (*T)(nil), in which case size must be a multiple of the size of T
+// and the block holds an array of T.
+func Alloc16(size uintptr, typ interface{}) unsafe.Pointer {
+	t := allocElemType("Alloc16", size, typ)
+	if size == 0 || size < 16 && t == nil {
+		size, t = 16, nil
+	}
+	if size > maxSmallSize {
+		return mallocgc(size, t, 0)
+	}
+	class := sizeToClass(int32(round(size, 16)))
+	return mallocgc(size, t, uint32(class)<<flagClassShift)
+}
+
+// AllocDeferGC allocates a zeroed block of size bytes, like new or make,
+// but never starts a garbage collection cycle itself, even if the heap
+// has grown enough to call for one. The collection is instead started
+// by a later allocation. This lets a latency-sensitive loop that runs
+// GC explicitly at a safe point keep collections from starting in the
+// middle of the loop.
+//
+// The argument typ describes the memory layout of the block, so the
+// garbage collector knows where it holds pointers. It must be nil, for
+// a block containing no pointers, or a pointer value such as (*T)(nil),
+// in which case size must be a multiple of the size of T and the block
+// holds an array of T.
+func AllocDeferGC(size uintptr, typ interface{}) unsafe.Pointer {
+	t := allocElemType("AllocDeferGC", size, typ)
+	return mallocgc(size, t, flagNoGCTrigger)
+}
+
+// AllocCold allocates a zeroed block of size bytes, like AllocDeferGC,
+// for data that is rarely accessed, such as a large lookup table that
+// is consulted only occasionally. Small cold objects are allocated from
+// a separate set of spans, which hold only cold objects, so that they
+// do not take up room in the pages, cache lines, and TLB entries used
+// by frequently accessed objects of the same size. Large objects get
+// spans of their own anyway and are allocated as usual.
+//
+// The arguments are as for AllocDeferGC: typ must be nil or a pointer
+// value such as (*T)(nil) describing the layout of the block. Cold
+// allocation is otherwise like new or make; in particular, cold objects
+// are freed when unreachable. Because each P caches cold spans of its
+// own, using AllocCold for a few objects of many sizes can increase
+// memory use. Cold objects smaller than 16 bytes without pointers are
+// not packed into shared blocks and take a 16-byte block each.
+func AllocCold(size uintptr, typ interface{}) unsafe.Pointer {
+	t := allocElemType("AllocCold", size, typ)
+	return mallocgc(size, t, flagCold)
+}
+
+// AllocUnprofiled allocates a zeroed block of size bytes, like
+// AllocDeferGC, but never records it in the memory profile, whatever
+// the MemProfileRate. It is meant for memory that supports profiling
+// itself, such as buffers for writing out profiles, which would
+// otherwise show up in and skew the profiles they produce. The block
+// still counts toward MemStats like any other allocation.
+//
+// The arguments are as for AllocDeferGC: typ must be nil or a pointer
+// value such as (*T)(nil) describing the layout of the block.
+func AllocUnprofiled(size uintptr, typ interface{}) unsafe.Pointer {
+	t := allocElemType("AllocUnprofiled", size, typ)
+	return mallocgc(size, t, flagNoProfile)
+}
+
+// AllocLazyBitmap allocates a zeroed block of size bytes, like
+// AllocDeferGC, but if the block is a large one, of more than 32 kB,
+// it skips recording where the block holds pointers in the garbage
+// collector's heap bitmap. The collector records them instead when it
+// first finds the block reachable, or when pointers are copied into the
+// block while a collection is running, so a large block of pointers that
+// becomes unreachable before the next collection never pays for the
+// bitmap. This helps programs that allocate many short-lived large
+// blocks of pointers and rarely collect. Blocks allocated while a
+// collection is running get their bitmap as usual.
+//
+// The arguments are as for AllocDeferGC: typ must be nil or a pointer
+// value such as (*T)(nil) describing the layout of the block.
+func AllocLazyBitmap(size uintptr, typ interface{}) unsafe.Pointer {
+	t := allocElemType("AllocLazyBitmap", size, typ)
+	return mallocgc(size, t, flagLazyBitmap)
+}
+
+// AllocHotMutable allocates a zeroed block of size bytes, like
+// AllocDeferGC, for a small object that is written often, such as a
+// counter updated by many goroutines. The block starts on a cache line
+// and does not share a cache line with any other object, so writes to
+// it do not slow down access to its neighbors (false sharing).
+//
+// To guarantee this, size is rounded up to a multiple of the cache line
+// size, typically 64 bytes, and then further to the smallest size class
+// whose objects are a whole number of cache lines. An 8-byte counter,
+// for instance, occupies a 64-byte block. Objects are never allocated
+// by the tiny allocator. Large objects are page-aligned anyway and are
+// allocated as usual.
+//
+// The arguments are as for AllocDeferGC: typ must be nil or a pointer
+// value such as (*T)(nil) describing the layout of the block.
+func AllocHotMutable(size uintptr, typ interface{}) unsafe.Pointer {
+	t := allocElemType("AllocHotMutable", size, typ)
+	return mallocgc(size, t, flagHotMutable)
+}
+
+// AllocDMA allocates a zeroed buffer of size bytes that starts on a page
+// boundary and whose memory is returned to the operating system as soon
+// as the garbage collector frees it, suiting large, short-lived buffers
+// such as those handed to devices. The buffer is always allocated as a
+// large object, even if size is small, so it occupies at least a page.
+//
+// The memory of ordinary large objects is kept by the heap once they
+// are freed, for reuse, and returned to the operating system by the
+// scavenger only after a few minutes unused. Buffers that are allocated
+// rarely gain little from that and, if large, make the heap hold on to
+// much more memory than it needs.
+func AllocDMA(size uintptr) []byte {
+	if int(size) < 0 {
+		panic(plainError("runtime.AllocDMA: size out of range"))
+	}
+	var b []byte
+	p := mallocgc(size, nil, flagLarge|flagDMA)
+	*(*slice)(unsafe.Pointer(&b)) = slice{p, int(size), int(size)}
+	return b
+}
+
+// A Lifetime is a hint, passed to AllocWithLifetime, about how long the
+// memory of a large object is worth keeping once the object is freed.
+type Lifetime int
+
+const (
+	LifetimeDefault    Lifetime = iota // keep it for a few minutes, as for other objects
+	LifetimeEphemeral                  // return it to the operating system soon
+	LifetimePersistent                 // keep it for reuse
+)
+
+// AllocWithLifetime allocates a zeroed block of size bytes, like
+// AllocDeferGC, and records with it how long the heap should hold on to
+// its memory after the garbage collector frees it.
+//
+// The pages of a freed object are normally kept by the heap for reuse
+// and returned to the operating system by the scavenger only after a
+// few minutes unused. With LifetimeEphemeral, suiting buffers used
+// for a single request, they are returned at the next pass of the
+// scavenger, however recently they were freed. With
+// LifetimePersistent, suiting buffers that are regularly freed and
+// allocated again, such as those of a pool, the scavenger keeps them
+// however long they go unused, though debug.FreeOSMemory still
+// returns them. Free persistent pages are not merged with other free
+// pages, so that the hint covers exactly the pages of the object.
+//
+// The hint applies to large objects, of more than 32 kB, which have
+// pages of their own. Smaller objects share their pages with others
+// and are allocated as usual. The other arguments are as for
+// AllocDeferGC, and AllocWithLifetime panics if lifetime is not one of
+// the Lifetime constants.
+func AllocWithLifetime(size uintptr, typ interface{}, lifetime Lifetime) unsafe.Pointer {
+	var flags uint32
+	switch lifetime {
+	case LifetimeDefault:
+	case LifetimeEphemeral:
+		flags = flagEphemeral
+	case LifetimePersistent:
+		flags = flagPersistent
+	default:
+		panic(plainError("runtime.AllocWithLifetime: invalid lifetime"))
+	}
+	t := allocElemType("AllocWithLifetime", size, typ)
+	return mallocgc(size, t, flags)
+}
+
+// AllocStreamingZero allocates a zeroed buffer of size bytes, like
+// make([]byte, size), for a program that is about to write the whole
+// buffer in a single pass. Large buffers are zeroed with non-temporal
+// stores, which bypass the processor caches, so that zeroing does not
+// evict data in use only to fill the caches with zeros the program
+// overwrites. Buffers of up to 32 KB, which are allocated from
+// per-P caches of small objects, are zeroed as usual, as are all
+// buffers on platforms without non-temporal stores, currently all but
+// amd64.
+//
+// Reading the buffer before writing it is slower than for an
+// ordinary buffer, as the zeros must be fetched from memory.
+func AllocStreamingZero(size uintptr) []byte {
+	if int(size) < 0 {
+		panic(plainError("runtime.AllocStreamingZero: size out of range"))
+	}
+	var b []byte
+	p := mallocgc(size, nil, flagStreamZero)
+	*(*slice)(unsafe.Pointer(&b)) = slice{p, int(size), int(size)}
+	return b
+}
+
+// AllocPageAligned allocates a zeroed block of size bytes that starts
+// on a page boundary and shares none of its pages with other objects,
+// so that it can be passed to system calls such as mprotect and madvise
+// that act on whole pages. The block is always allocated as a large
+// object and occupies at least one page: a smaller size is rounded up to
+// the runtime page size, 8 KB, and the whole page may be used. Like the
+// buffers of AllocDMA, the block is not scanned for pointers by the
+// garbage collector, so it must not hold the only reference to a heap
+// object.
+//
+// The block is freed by the garbage collector as usual once it is
+// unreachable. Any protection set on its pages must be removed before
+// then, since the pages are reused for other objects.
+func AllocPageAligned(size uintptr) unsafe.Pointer {
+	if int(size) < 0 {
+		panic(plainError("runtime.AllocPageAligned: size out of range"))
+	}
+	if size < _PageSize {
+		size = _PageSize
+	}
+	return mallocgc(size, nil, flagLarge)
+}
+
+// AllocExternal allocates a zeroed object of size bytes for memory
+// outside Go's control, such as a C library, to hold a pointer to. The
+// garbage collector treats the object as referenced from outside the
+// heap until it is passed to ReleaseExternal, so it, and everything it
+// points to, stays allocated however long the Go program goes without
+// referring to it. The object is otherwise an ordinary heap object,
+// freed by the garbage collector once it has been released and is
+// unreachable.
+//
+// The arguments are as for AllocDeferGC.
+//
+// Objects allocated by AllocExternal are recorded with their span, as
+// finalizers are, and marked at the start of every collection, so
+// each costs a little extra to mark. They are never packed into shared
+// blocks, so one smaller than 16 bytes without pointers takes a 16-byte
+// block.
+func AllocExternal(size uintptr, typ interface{}) unsafe.Pointer {
+	if int(size) < 0 {
+		panic(plainError("runtime.AllocExternal: size out of range"))
+	}
+	t := allocElemType("AllocExternal", size, typ)
+	return mallocgc(size, t, flagExternalRef)
+}
+
+// ReleaseExternal ends the external reference to p, which must have been
+// returned by AllocExternal and not yet released. From then on, the
+// object is kept alive only by pointers the garbage collector can see.
+func ReleaseExternal(p unsafe.Pointer) {
+	if p == unsafe.Pointer(&zerobase) {
+		// A zero-size allocation, which is never freed.
+		return
+	}
+	if !removeexternal(p) {
+		panic(plainError("runtime.ReleaseExternal: pointer not allocated by AllocExternal or already released"))
+	}
+}
+
+// AllocPermanent allocates a zeroed block of size bytes that is never
+// freed, outside the garbage-collected heap. The garbage collector
+// ignores the block entirely: it never scans it, so it costs nothing to
+// mark however many pointers it holds, and pointers to it from the heap
+// are not followed. This suits large tables built once and kept for the
+// life of the program, whose object graphs would otherwise be marked
+// again by every collection.
+//
+// Because the block is not scanned, it must not hold the only reference
+// to an object in the heap, which could be freed while still referred
+// to: the block may point only to other permanent blocks, to global
+// variables, or to heap objects that are kept alive by other means for
+// as long as the block refers to them. Permanent memory is reported in
+// MemStats.OtherSys and is not counted toward the heap size that
+// triggers collections.
+//
+// The arguments are as for AllocDeferGC: typ must be nil or a pointer
+// value such as (*T)(nil) describing the layout of the block, although
+// the layout is not recorded, since nothing scans the block.
+func AllocPermanent(size uintptr, typ interface{}) unsafe.Pointer {
+	if int(size) < 0 {
+		panic(plainError("runtime.AllocPermanent: size out of range"))
+	}
+	allocElemType("AllocPermanent", size, typ)
+	if size == 0 {
+		return unsafe.Pointer(&zerobase)
+	}
+	return persistentalloc(size, 0, &memstats.other_sys)
+}
+
+// hotMutableSize returns the size of the block allocated for an object
+// of size bytes by AllocHotMutable: the smallest size of at least size
+// bytes whose size class holds objects that are a whole number of cache
+// lines. Spans start on a page boundary, so every object in such a size
+// class starts on a cache line. The result may exceed maxSmallSize.
+func hotMutableSize(size uintptr) uintptr {
+	size = round(size, sys.CacheLineSize)
+	for size <= maxSmallSize {
+		n := roundupsize(size)
+		if n%sys.CacheLineSize == 0 {
+			return n
+		}
+		size = round(n+1, sys.CacheLineSize)
+	}
+	return size
+}
+
+// RegisterTypedRegion sets the memory at p to the zero value of type T,
+// where typ is a pointer value such as (*T)(nil), and records in the
+// garbage collector's heap bitmap that the memory holds a T. This lets
+// a slab allocator that carves objects out of one big pointer-free
+// block, such as a []byte, store pointers in those objects: after
+// registration the collector scans the value at p and keeps the objects
+// it points to alive. Registering a type without pointers makes the
+// region pointer-free again.
+//
+// p must be aligned to a pointer boundary and point into a heap block,
+// and the T at p must lie entirely within that block. On 64-bit systems
+// the block must be larger than one word. The registration lasts until
+// the region is registered again or the block is freed. Pointers must
+// be written to the region only through a *T, so the usual write
+// barriers run, and the region must not be accessed while it is being
+// registered. The first registration in a small block, one of at most
+// 32 kB, stops the world briefly; later ones do not.
+func RegisterTypedRegion(p unsafe.Pointer, typ interface{}) {
+	t := efaceOf(&typ)._type
+	if t == nil || t.kind&kindMask != kindPtr {
+		panic(plainError("runtime.RegisterTypedRegion: type argument is not a pointer"))
+	}
+	elem := (*ptrtype)(unsafe.Pointer(t)).elem
+	if uintptr(p)&(sys.PtrSize-1) != 0 {
+		panic(plainError("runtime.RegisterTypedRegion: pointer is not aligned"))
+	}
+	s, base, n := findObject(p)
+	if s == nil {
+		panic(plainError("runtime.RegisterTypedRegion: pointer not in heap"))
+	}
+	// A one-word block has no room for the bitmap of a multiword
+	// object; its single bit pair doubles as the checkmark.
+	if sys.PtrSize == 8 && n == sys.PtrSize {
+		panic(plainError("runtime.RegisterTypedRegion: heap block is only one word"))
+	}
+	s.ensureHeapBits()
+	off := uintptr(p) - uintptr(base)
+	if elem.size > n-off {
+		panic(plainError("runtime.RegisterTypedRegion: " + elem.string() + " does not fit in heap block"))
+	}
+	if elem.size == 0 {
+		return
+	}
+	memclr(p, elem.size)
+
+	var mask *byte
+	if elem.kind&kindNoPointers == 0 {
+		mask = elem.gcdata
+		if elem.kind&kindGCProg != 0 {
+			buf := make([]byte, (elem.ptrdata/sys.PtrSize+7)/8)
+			runGCProg(addb(elem.gcdata, 4), nil, &buf[0], 1)
+			mask = &buf[0]
+		}
+	}
+
+	// Small objects share heap bitmap bytes with their neighbors,
+	// which malloc writes without atomics until smallTypedRegions
+	// is set. Set it with the world stopped, so that no allocation
+	// is in the middle of such a write.
+	small := s.sizeclass != 0
+	if small && atomic.Load(&smallTypedRegions) == 0 {
+		stopTheWorld("register typed region")
+		smallTypedRegions = 1
+		startTheWorld()
+	}
+
+	// Words of the region before the end of elem's pointers get the
+	// pointer bits from elem's mask. If elem has pointers, every word
+	// from the start of the block up to the last one must also be
+	// marked as not dead, or scanobject stops before reaching them.
+	// Other regions of the block may be registered concurrently, so
+	// update shared bitmap bytes atomically.
+	h := heapBitsForAddr(uintptr(base))
+	if small {
+		lock(&typedRegionLock)
+	}
+	if small && mask != nil && !h.morePointers() {
+		// The block has no registered pointers yet. Unlike a large
+		// span, a reused small slot keeps the bitmap of the object
+		// that last occupied it in every word but the first, so
+		// clear those stale bits before marking words as not dead.
+		// The second word's high bit is the checkmark; leave it.
+		hw := h
+		for i := uintptr(0); i < n/sys.PtrSize; i++ {
+			bits := uint8(bitPointer)
+			if i != 1 {
+				bits |= bitMarked
+			}
+			atomic.And8(hw.bitp, ^(bits << hw.shift))
+			hw = hw.next()
+		}
+	}
+	nw := (off + elem.size + sys.PtrSize - 1) / sys.PtrSize
+	for i := uintptr(0); i < nw; i++ {
+		if i*sys.PtrSize >= off {
+			j := i - off/sys.PtrSize
+			if j < elem.ptrdata/sys.PtrSize && *addb(mask, j/8)>>(j%8)&1 != 0 {
+				atomic.Or8(h.bitp, bitPointer<<h.shift)
+			} else {
+				atomic.And8(h.bitp, ^uint8(bitPointer<<h.shift))
+			}
+		}
+		if mask != nil {
+			atomic.Or8(h.bitp, bitMarked<<h.shift)
+		}
+		h = h.next()
+	}
+	if small {
+		unlock(&typedRegionLock)
+	}
+}
+
+// smallTypedRegions is set, and never cleared, once RegisterTypedRegion
+// has been called on a small object. From then on, malloc updates heap
+// bitmap bytes shared between objects atomically, even while the GC is
+// off. typedRegionLock serializes registrations in small objects, which
+// may have to clear stale bitmap bits first.
+var (
+	smallTypedRegions uint32
+	typedRegionLock   mutex
+)
+
+// The moving average of allocation sizes is updated with every
+// avgAllocSamplePeriod'th allocation on a P, to keep the cost off most
+// allocations. avgAllocSizeShift sets the weight of each sample: 1/32,
+// so the average reflects roughly the last 32 samples, or 256
+// allocations, on each P. The average is kept scaled by
+// 1<<avgAllocSizeShift, so that updating it does not truncate, and
+// sizes above maxAvgAllocSize count as that size, so that the scaled
+// average cannot overflow.
+const (
+	avgAllocSamplePeriod = 8
+	avgAllocSizeShift    = 5
+	maxAvgAllocSize      = ^uintptr(0) >> avgAllocSizeShift
+)
+
+// AvgAllocSize returns the recent average size of heap allocations,
+// in bytes, as requested rather than rounded up to a size class.
+// Each P keeps an exponentially weighted moving average of the sizes
+// of one in every 8 of its allocations, giving each new sample a
+// weight of 1/32, and AvgAllocSize returns the mean of these averages
+// over the Ps that have allocated. It is cheap enough to call often,
+// for example to let a buffer pool adapt its chunk size to current
+// allocations.
+func AvgAllocSize() uintptr {
+	// Keep the world from stopping, and so Ps and their
+	// caches from being destroyed, while reading them.
+	mp := acquirem()
+	var sum, n uint64
+	for i := int32(0); i < gomaxprocs; i++ {
+		if c := allp[i].mcache; c != nil && c.avgsize != 0 {
+			sum += uint64(c.avgsize >> avgAllocSizeShift)
+			n++
+		}
+	}
+	releasem(mp)
+	if n == 0 {
+		return 0
+	}
+	return uintptr(sum / n)
+}
+
+// maxAllocSeen is the largest size passed to mallocgc since the
+// program started or ResetMaxAllocSeen was last called, not counting
+// the sizes in mcache.local_maxalloc not yet flushed to it by
+// purgecachedstats. Accessed atomically.
+var maxAllocSeen uintptr
+
+// noteMaxAlloc raises maxAllocSeen to size if it is smaller.
+func noteMaxAlloc(size uintptr) {
+	for {
+		old := atomic.Loaduintptr(&maxAllocSeen)
+		if size <= old || atomic.Casuintptr(&maxAllocSeen, old, size) {
+			return
+		}
+	}
+}
+
+// MaxAllocSeen returns the size in bytes of the largest heap allocation
+// made since the program started or ResetMaxAllocSeen was last called,
+// as requested rather than rounded up to a size class. An unexpectedly
+// large value can point to a corrupted length or a pathological input.
+func MaxAllocSeen() uintptr {
+	// Keep the world from stopping, and so Ps and their
+	// caches from being destroyed, while reading them.
+	mp := acquirem()
+	max := atomic.Loaduintptr(&maxAllocSeen)
+	for i := int32(0); i < gomaxprocs; i++ {
+		if c := allp[i].mcache; c != nil && c.local_maxalloc > max {
+			max = c.local_maxalloc
+		}
+	}
+	releasem(mp)
+	return max
+}
+
+// ResetMaxAllocSeen sets the size reported by MaxAllocSeen back to 0
+// and returns the previous value, so that the peak can be tracked for
+// each phase of a program separately. It stops the world to reset the
+// per-P maximums.
+func ResetMaxAllocSeen() uintptr {
+	var max uintptr
+	stopTheWorld("reset max alloc seen")
+	systemstack(func() {
+		cachestats()
+		max = atomic.Xchguintptr(&maxAllocSeen, 0)
+	})
+	startTheWorld()
+	return max
+}
+
+// allocElemType returns the type of the elements of a block of size
+// bytes allocated by the exported function fn with type argument typ,
+// as described for AllocDeferGC, or nil if the block holds no pointers.
+func allocElemType(fn string, size uintptr, typ interface{}) *_type {
+	t := efaceOf(&typ)._type
+	if t == nil {
+		return nil
+	}
+	if t.kind&kindMask != kindPtr {
+		panic(plainError("runtime." + fn + ": type argument is " + t.string() + ", not pointer"))
+	}
+	elem := (*ptrtype)(unsafe.Pointer(t)).elem
+	if elem.size == 0 {
+		return nil
+	}
+	if size%elem.size != 0 {
+		panic(plainError("runtime." + fn + ": size is not a multiple of the size of " + elem.string()))
+	}
+	if elem.kind&kindNoPointers != 0 {
+		return nil
+	}
+	return elem
+}
+
+// ObjectSize returns the size of the heap block containing p, which is
+// the size requested when the object was allocated rounded up to its
+// size class, or to a whole number of pages for large objects.
+// It returns 0 if p does not point into an allocated heap block.
+//
+// Objects smaller than 16 bytes that contain no pointers may share a
+// block with other such objects (see SetLowFragMode), so for them the
+// result is the size of the shared block, not space p may write to.
+func ObjectSize(p unsafe.Pointer) uintptr {
+	_, _, n := findObject(p)
+	return n
+}
+
+// ZeroTail zeros the heap object starting at p from offset writtenPrefix
+// to the end of its heap block, as reported by ObjectSize. It is meant
+// for reusing a buffer of which only the first writtenPrefix bytes were
+// written since it was last clean: the rest need not be cleared again,
+// but this way no stale data, or stale pointers the garbage collector
+// would follow, survive past the prefix. In an object that contains
+// pointers, writtenPrefix is rounded up to a whole pointer, so that
+// pointers are cleared whole.
+//
+// ZeroTail panics if p is not the start of an allocated heap object, if
+// writtenPrefix is larger than the block, or if p is a pointer-free
+// object in a block of 16 bytes or less, which may be shared with other
+// objects (see SetLowFragMode).
+func ZeroTail(p unsafe.Pointer, writtenPrefix uintptr) {
+	s, base, n := findObject(p)
+	if base == nil || base != p {
+		panic(plainError("runtime.ZeroTail: pointer not to the start of a heap object"))
+	}
+	if writtenPrefix > n {
+		panic(plainError("runtime.ZeroTail: prefix larger than the object"))
+	}
+	if objectHasPointers(s, uintptr(p)) {
+		writtenPrefix = round(writtenPrefix, sys.PtrSize)
+	} else if n <= maxTinySize {
+		panic(plainError("runtime.ZeroTail: object may share its block"))
+	}
+	if writtenPrefix < n {
+		memclr(add(p, writtenPrefix), n-writtenPrefix)
+	}
+}
+
+// ClassForType returns the size class of the heap blocks in which
+// objects of type T are allocated, where typ is a pointer value such as
+// (*T)(nil), for use with AllocInClass. Size classes are numbered as for
+// SpansPerClass. The result is 0 for types of more than 32 kB, which are
+// allocated as large objects, and for types of size 0, which take no
+// heap memory. Pointer-free types smaller than 16 bytes are normally
+// packed into shared blocks instead (see SetTinyAlloc), but the class
+// for their size is returned all the same; AllocInClass rejects them.
+func ClassForType(typ interface{}) int {
+	elem := classElemType("ClassForType", typ)
+	if elem.size == 0 || elem.size > maxSmallSize {
+		return 0
+	}
+	return int(sizeToClass(int32(elem.size)))
+}
+
+// AllocInClass allocates a zeroed T, like new(T), where typ is a pointer
+// value such as (*T)(nil) and class is the result of ClassForType for T,
+// so that code that allocates many objects of a type can look up the
+// class once and skip the size lookup on each allocation. It panics if
+// T is not allocated in class, and if T is pointer-free and smaller
+// than 16 bytes, as such objects are packed into shared blocks by the
+// tiny allocator rather than allocated in a class.
+func AllocInClass(class int, typ interface{}) unsafe.Pointer {
+	elem := classElemType("AllocInClass", typ)
+	size := elem.size
+	if elem.kind&kindNoPointers != 0 && 0 < size && size < maxTinySize {
+		panic(plainError("runtime.AllocInClass: " + elem.string() + " is allocated by the tiny allocator"))
+	}
+	var ok bool
+	if class == 0 {
+		ok = size == 0 || size > maxSmallSize
+	} else {
+		ok = 0 < class && class < _NumSizeClasses &&
+			uintptr(class_to_size[class-1]) < size && size <= uintptr(class_to_size[class])
+	}
+	if !ok {
+		panic(plainError("runtime.AllocInClass: " + elem.string() + " is not allocated in the given size class"))
+	}
+	return mallocgc(size, elem, uint32(class)<<flagClassShift)
+}
+
+// AllocNear allocates a zeroed T, like new(T), where typ is a pointer
+// value such as (*T)(nil), preferably in the same span as the heap
+// object hint points into, so that objects used together, such as the
+// linked nodes of a graph, share pages and cache lines. The span must
+// hold objects of the size class of T and have free room. If the
+// current P is not already allocating from it, AllocNear switches the
+// P's cache to it, so later allocations of the same size on the P are
+// placed there too.
+//
+// The placement is only a hint, and AllocNear falls back to allocating
+// the object as usual if the span is full, holds objects of another
+// size, or is in use by the cache of another P, or if the goroutine
+// moves to another P in the meantime. Objects of more than 32 kB, which
+// get spans of their own, and pointer-free objects smaller than 16
+// bytes, which are packed into shared blocks, are always allocated as
+// usual.
+func AllocNear(hint unsafe.Pointer, typ interface{}) unsafe.Pointer {
+	elem := classElemType("AllocNear", typ)
+	size := elem.size
+	if size == 0 || size > maxSmallSize || elem.kind&kindNoPointers != 0 && size < maxTinySize {
+		return mallocgc(size, elem, 0)
+	}
+	if s, _, _ := findObject(hint); s != nil && !s.cold && int32(s.sizeclass) == sizeToClass(int32(size)) {
+		mp := acquirem()
+		c := gomcache()
+		if c.alloc[s.sizeclass] != s {
+			systemstack(func() {
+				c.cacheSpanNear(s)
+			})
+		}
+		releasem(mp)
+	}
+	return mallocgc(size, elem, 0)
+}
+
+// classElemType returns T for the type argument (*T)(nil) of the
+// exported function fn.
+func classElemType(fn string, typ interface{}) *_type {
+	t := efaceOf(&typ)._type
+	if t == nil || t.kind&kindMask != kindPtr {
+		panic(plainError("runtime." + fn + ": type argument is not a pointer"))
+	}
+	return (*ptrtype)(unsafe.Pointer(t)).elem
+}
+
+// AllocSSO allocates a zeroed T, like new(T), where typ is a pointer
+// value such as (*T)(nil), followed in the same heap block by an inline
+// buffer of at least inlineCap bytes, which SSOInline returns. It suits
+// data structures with a small-buffer optimization, such as a string
+// builder that keeps short contents inside the object and needs no
+// further allocation for them.
+//
+// The garbage collector scans only the T for pointers: the buffer is
+// scalar data and must not hold the only reference to a heap object.
+// The object always has a block of its own, even if pointer-free and
+// smaller than 16 bytes.
+func AllocSSO(inlineCap uintptr, typ interface{}) unsafe.Pointer {
+	elem := classElemType("AllocSSO", typ)
+	size := elem.size + inlineCap
+	if int(inlineCap) < 0 || int(size) < 0 {
+		panic(plainError("runtime.AllocSSO: inline capacity out of range"))
+	}
+	return mallocgc(size, elem, flagTypedPrefix)
+}
+
+// SSOInline returns the inline buffer of p, which must have been
+// allocated by AllocSSO with the same typ. The buffer runs from the end
+// of the T to the end of the heap block, so it is at least as long as
+// the inline capacity asked for and may be longer, as the block size is
+// rounded up to a size class.
+func SSOInline(p unsafe.Pointer, typ interface{}) []byte {
+	elem := classElemType("SSOInline", typ)
+	_, base, n := findObject(p)
+	if base == nil || base != p || n < elem.size {
+		panic(plainError("runtime.SSOInline: pointer not to an object allocated by AllocSSO"))
+	}
+	if n == elem.size {
+		// Don't point past the block.
+		return nil
+	}
+	var b []byte
+	*(*slice)(unsafe.Pointer(&b)) = slice{add(p, elem.size), int(n - elem.size), int(n - elem.size)}
+	return b
+}
+
+// ObjectType returns the zero value of the type with which the heap
+// object containing p was allocated, or nil if p does not point into an
+// allocated heap object or its type is not known. reflect.TypeOf of the
+// result gives the type itself.
+//
+// The heap does not normally record the types of objects, so ObjectType
+// always returns nil unless the program runs with GODEBUG=objtypes=1,
+// which makes every allocation record its type at a cost of a few words
+// of memory per object. Even then, objects allocated without a type,
+// such as the backing arrays of strings, have none, and neither do most
+// pointer-free objects smaller than 16 bytes, which are packed into
+// shared blocks without going through the part of the allocator that
+// records types. For a slice or an array allocated by make or new, the
+// type is that of its elements.
+func ObjectType(p unsafe.Pointer) interface{} {
+	if debug.objtypes == 0 {
+		return nil
+	}
+	s, base, _ := findObject(p)
+	if s == nil {
+		return nil
+	}
+	var typ *_type
+	systemstack(func() {
+		typ = gettypespecial(s, uintptr(base), uintptr(p))
+	})
+	if typ == nil {
+		return nil
+	}
+	var v interface{}
+	e := efaceOf(&v)
+	e._type = typ
+	if !isDirectIface(typ) {
+		e.data = newobject(typ)
+	}
+	return v
+}
+
+// lowFragMode is set by SetLowFragMode. Changes happen with the world
+// stopped so that no P is in the middle of an allocation.
+var lowFragMode bool
+
+// oneWordNoScan is set, and never cleared, once one-word objects
+// without pointers may have been allocated, which only happens in
+// low-fragmentation mode or with the tiny allocator disabled. Such
+// objects clear the pointer bits that initSpan sets for one-word
+// objects, so heapBitsSetType has to set them again.
+var oneWordNoScan uint32
+
+// SetLowFragMode enables or disables the allocator's low-fragmentation
+// mode and returns the previous setting.
+//
+// Objects smaller than 16 bytes that contain no pointers are normally
+// packed together into 16-byte blocks, which are freed only once every
+// object in them is unreachable, so a single live object can keep up to
+// twice its rounded size in use. In low-fragmentation mode such objects
+// are packed into 8-byte blocks instead, which never use more memory than
+// allocating each object on its own, at the cost of more allocations.
+// Size-class selection is not changed: other sizes are already rounded
+// up to the smallest size class that fits, and no larger class packs
+// its spans tightly enough to take less memory per object, so that
+// class is already the best fit.
+//
+// Low-fragmentation mode makes small allocations slower. It is intended
+// for memory-constrained deployments where a smaller heap matters more
+// than allocation speed. It cannot be enabled when GODEBUG=gccheckmark=1,
+// which assumes that every 8-byte object is a pointer.
+func SetLowFragMode(enable bool) bool {
+	if debug.gccheckmark > 0 {
+		return false
+	}
+	stopTheWorld("low fragmentation mode")
+	old := lowFragMode
+	if enable != old {
+		lowFragMode = enable
+		if enable {
+			oneWordNoScan = 1
+		}
+		// Drop the current tiny blocks, which have the old size.
+		for _, p := range &allp {
+			if p == nil {
+				break
+			}
+			if c := p.mcache; c != nil {
+				c.tiny = 0
+				c.tinyoffset = 0
+			}
+		}
+	}
+	startTheWorld()
+	return old
+}
+
+// noZeroMin and noZeroMax are the range of sizes of pointer-free
+// allocations that are not zeroed, set by SetAllocZeroPolicy. noZeroMax
+// is 0 if there is none. Changes happen with the world stopped so that
+// mallocgc sees a consistent range.
+var noZeroMin, noZeroMax uintptr
+
+// SetAllocZeroPolicy sets whether the backing arrays of slices made by
+// make whose elements contain no pointers and whose size is between
+// minSize and maxSize bytes, inclusive, are zeroed. Normally all memory
+// is zeroed. A call with zero false stops zeroing slices in the range,
+// replacing any range set before, since there is only one. A call with
+// zero true restores zeroing for all sizes if the range overlaps the
+// one that is not zeroed.
+//
+// Zeroing is wasted work for buffers that are filled completely as
+// soon as they are allocated, which can make up a noticeable part of
+// the time spent allocating large buffers. But skipping it is unsafe
+// for any code that does not fill what it allocates: the memory holds
+// whatever earlier, freed objects left there, which the code can then
+// read, and which can leak data, such as secrets, from one part of the
+// program to another. The policy applies to every slice in the range
+// made by make, including those made by other packages, which the
+// language otherwise guarantees are zeroed, so it should cover only
+// sizes that the program knows are used just by such buffers. Other
+// allocations, such as those of new, of composite literals, of append,
+// and those the runtime makes for itself, such as for maps and channels,
+// are always zeroed, as are slices of elements that
+// contain pointers, since the garbage collector would otherwise find
+// garbage pointers in them. Pointer-free slices smaller than 16 bytes
+// may share a block with other objects (see SetLowFragMode) and are
+// always zeroed too.
+func SetAllocZeroPolicy(minSize, maxSize uintptr, zero bool) {
+	stopTheWorld("alloc zero policy")
+	if !zero {
+		noZeroMin, noZeroMax = minSize, maxSize
+	} else if noZeroMax != 0 && minSize <= noZeroMax && noZeroMin <= maxSize {
+		noZeroMin, noZeroMax = 0, 0
+	}
+	startTheWorld()
+}
+
+// An allocLatencyHook is a budget and callback set by
+// SetAllocLatencyBudget.
+type allocLatencyHook struct {
+	budget int64
+	fn     func(size uintptr, elapsed int64)
+}
+
+// allocLatency points to the hook set by SetAllocLatencyBudget, or is
+// nil if there is none. mallocgc reads it without atomics only to decide
+// whether to time its slow path. Written atomically.
+var allocLatency *allocLatencyHook
+
+// SetAllocLatencyBudget arranges for fn to be called whenever a heap
+// allocation spends more than nanos nanoseconds in the allocator's slow
+// path, with the size requested and the time spent in nanoseconds. The
+// slow path is taken when the goroutine's cache of free memory for the
+// size runs out and must be refilled, and for every allocation larger
+// than 32 kB, and it is where the allocator may have to sweep, grow the
+// heap or start a collection. Allocations served from the cache are
+// not timed, since they take a short and predictable time. A nanos of
+// 0 or less or a nil fn removes the budget.
+//
+// fn is called by the goroutine that allocated, after the allocation,
+// possibly by several goroutines at once. It must not allocate memory
+// from the heap, which could take the slow path again, and should be
+// quick, such as by incrementing a counter or sending on a buffered
+// channel without blocking.
+func SetAllocLatencyBudget(nanos int64, fn func(size uintptr, elapsed int64)) {
+	var h *allocLatencyHook
+	if nanos > 0 && fn != nil {
+		h = new(allocLatencyHook)
+		h.budget, h.fn = nanos, fn
+	}
+	atomicstorep(unsafe.Pointer(&allocLatency), unsafe.Pointer(h))
+}
+
+// allocEventBuffer is the capacity of the channels made by
+// AllocEventChannel.
+const allocEventBuffer = 256
+
+// An allocEventSink is a channel and sampling period set by
+// AllocEventChannel.
+type allocEventSink struct {
+	c     chan AllocEvent
+	every uint32
+}
+
+// allocEvents points to the sink set by AllocEventChannel, or is nil if
+// there is none. mallocgc reads it without atomics only to decide
+// whether to count the allocation. Written atomically.
+var allocEvents *allocEventSink
+
+// allocEventsDropped is the number of events that AllocEventChannel
+// channels had no room for. Accessed atomically.
+var allocEventsDropped uint64
+
+// AllocEventChannel starts sending an AllocEvent for every sampleEvery'th
+// heap allocation on each P to a new channel, which it returns, and stops
+// sending events to the channel returned by any previous call, without
+// closing it. AllocEventChannel(0) stops events altogether and returns
+// nil.
+//
+// Events are sent without blocking: an event for which the channel,
+// which buffers 256 events, has no room is dropped and counted by
+// AllocEventsDropped, so a slow consumer loses events instead of
+// slowing down allocation. The type of an allocation is known for
+// objects allocated by new, make and composite literals, and for the
+// elements of slices, but not, for instance, for the bytes of strings.
+// Small allocations that are combined into an existing tiny block are
+// not counted.
+func AllocEventChannel(sampleEvery int) <-chan AllocEvent {
+	var s *allocEventSink
+	if sampleEvery > 0 {
+		s = new(allocEventSink)
+		s.c = make(chan AllocEvent, allocEventBuffer)
+		s.every = uint32(sampleEvery)
+	}
+	atomicstorep(unsafe.Pointer(&allocEvents), unsafe.Pointer(s))
+	if s == nil {
+		return nil
+	}
+	return s.c
+}
+
+// AllocEventsDropped returns the number of allocation events that could
+// not be sent because the channel returned by AllocEventChannel was
+// full, since the program started.
+func AllocEventsDropped() uint64 {
+	return atomic.Load64(&allocEventsDropped)
+}
+
+// allocEvent counts the allocation of the size-byte object x of type
+// typ for AllocEventChannel, and sends an event for it if it is to be
+// sampled. Like profilealloc, it is called after mallocing is cleared.
+func allocEvent(x unsafe.Pointer, size uintptr, typ *_type) {
+	s := (*allocEventSink)(atomic.Loadp(unsafe.Pointer(&allocEvents)))
+	if s == nil {
+		return
+	}
+	mp := acquirem()
+	c := gomcache()
+	c.evsample++
+	if c.evsample < s.every || mp.locks > 1 || getg() != mp.curg {
+		// Not sampled, or not on an ordinary goroutine free
+		// to take the channel lock.
+		releasem(mp)
+		return
+	}
+	c.evsample = 0
+	var ev AllocEvent
+	ev.Addr = uintptr(x)
+	ev.Size = size
+	if typ != nil {
+		ev.Type = typ.string()
+	}
+	ev.Time = nanotime() - runtimeInitTime
+	ev.P = int(mp.p.ptr().id)
+	releasem(mp)
+	select {
+	case s.c <- ev:
+	default:
+		atomic.Xadd64(&allocEventsDropped, 1)
+	}
+}
+
+// allocLatencyCheck calls the SetAllocLatencyBudget callback if the slow
+// path of an allocation of size bytes, begun at start, went over budget.
+func allocLatencyCheck(size uintptr, start int64) {
+	h := (*allocLatencyHook)(atomic.Loadp(unsafe.Pointer(&allocLatency)))
+	if h == nil {
+		return
+	}
+	if elapsed := nanotime() - start; elapsed > h.budget {
+		h.fn(size, elapsed)
+	}
+}
+
+// tinyAllocOff is 1 if the tiny allocator is disabled by SetTinyAlloc.
+// It is written atomically; mallocgc reads it without synchronization,
+// as a stale value only affects how an object is allocated.
+var tinyAllocOff uint32
+
+// SetTinyAlloc enables or disables the tiny allocator and returns the
+// previous setting. It is enabled by default.
+//
+// The tiny allocator packs objects smaller than 16 bytes that contain
+// no pointers together into shared 16-byte blocks (8-byte blocks in
+// low-fragmentation mode; see SetLowFragMode). A block is freed only
+// once all the objects in it are unreachable, so if such objects have
+// very different lifetimes, a few long-lived ones can keep many blocks,
+// and up to twice the memory they need, in use. With the tiny
+// allocator disabled, each of these objects is allocated on its own in
+// the smallest size class that fits, which makes such allocations
+// slower and usually uses more memory, but frees each object as soon as
+// it is unreachable. The tiny allocator cannot be disabled when
+// GODEBUG=gccheckmark=1, which assumes that every 8-byte object is a
+// pointer.
+func SetTinyAlloc(enable bool) bool {
+	var off uint32
+	if !enable {
+		if debug.gccheckmark > 0 {
+			return TinyAllocEnabled()
+		}
+		off = 1
+		atomic.Store(&oneWordNoScan, 1)
+	}
+	return atomic.Xchg(&tinyAllocOff, off) == 0
+}
+
+// TinyAllocEnabled reports whether the tiny allocator is enabled.
+// See SetTinyAlloc.
+func TinyAllocEnabled() bool {
+	return atomic.Load(&tinyAllocOff) == 0
+}
+
+// allocFill is the byte that memory for new pointer-free slices is
+// set to instead of zero, or 0 to zero it. See SetAllocFill.
+var allocFill byte
+
+// SetAllocFill makes make set the backing array of a new slice whose
+// elements contain no pointers to the byte b instead of zeroing it,
+// and returns the previous fill byte. SetAllocFill(0) restores normal
+// zeroing. The fill replaces the zeroing step, so it costs no more than
+// zeroing does.
+//
+// SetAllocFill is a debugging aid for finding code that reads buffer
+// space it never wrote: with a distinctive fill such as 0xAA, such
+// reads stand out. It breaks the language guarantee that the elements
+// of a new slice are zero, so it must not be used in production.
+// Other allocations, including the slices the runtime makes for itself,
+// are always zeroed. The runtime relies on zeroed memory for its own
+// data structures, such as maps and channels, and
+// the garbage collector must never see garbage in a pointer slot.
+func SetAllocFill(b byte) byte {
+	stopTheWorld("set alloc fill")
+	old := allocFill
+	allocFill = b
+	startTheWorld()
+	return old
+}
+
+// memfill sets the n bytes at p to b.
+// p must be 8-byte aligned if n >= 8.
+func memfill(p unsafe.Pointer, n uintptr, b byte) {
+	w := uint64(b) * 0x0101010101010101
+	i := uintptr(0)
+	for ; i+8 <= n; i += 8 {
+		*(*uint64)(add(p, i)) = w
+	}
+	for ; i < n; i++ {
+		*(*byte)(add(p, i)) = b
+	}
+}
+
+// typeAllocLimits holds the limits set by SetTypeAllocLimit.
+// lock protects limits and the counts in its elements.
+//
+// mask has the typeAllocLimitBit of every limited type set, so that
+// mallocgc can rule out most types with a single load, without the
+// lock.
+var typeAllocLimits struct {
+	lock   mutex
+	mask   uint32
+	limits []*typeAllocLimit
+}
+
+// typeAllocLimitBit returns the bit of typeAllocLimits.mask for typ.
+func typeAllocLimitBit(typ *_type) uint32 {
+	return 1 << (typ.hash % 32)
+}
+
+// typeAllocLimitSema serializes SetTypeAllocLimit callers, which
+// are the only writers of typeAllocLimits.limits.
+var typeAllocLimitSema uint32 = 1
+
+type typeAllocLimit struct {
+	typ       *_type
+	maxPerSec uint64
+	window    int64  // nanotime() at the start of the current second
+	count     uint64 // allocations in the current second
+}
+
+// SetTypeAllocLimit limits the rate of heap allocations of type T,
+// where typ is a pointer value such as (*T)(nil), to maxPerSec
+// allocations per second. Once a second's allowance is used up, each
+// goroutine allocating a T sleeps until the next second starts.
+// Allocating a slice of T with make counts as a single allocation.
+// A maxPerSec of 0 removes the limit.
+//
+// SetTypeAllocLimit is a circuit breaker for code paths known to
+// allocate a type out of control: it keeps such a path from exhausting
+// the heap, at the cost of stalling it. Allocations that cannot be
+// blocked, such as those made while the runtime holds a lock, are
+// counted but not delayed. While any limit is set, every typed
+// allocation tests a bit in a shared mask, and allocations of T, and
+// of the few other types that share its bit, also take a lock to be
+// counted.
+func SetTypeAllocLimit(typ interface{}, maxPerSec uint64) {
+	t := efaceOf(&typ)._type
+	if t == nil || t.kind&kindMask != kindPtr {
+		panic(plainError("runtime.SetTypeAllocLimit: type argument is not a pointer"))
+	}
+	elem := (*ptrtype)(unsafe.Pointer(t)).elem
+
+	l := &typeAllocLimits
+	semacquire(&typeAllocLimitSema, false)
+	limits := make([]*typeAllocLimit, 0, len(l.limits)+1)
+	for _, tl := range l.limits {
+		if tl.typ != elem {
+			limits = append(limits, tl)
+		}
+	}
+	if maxPerSec != 0 {
+		limits = append(limits, &typeAllocLimit{typ: elem, maxPerSec: maxPerSec})
+	}
+	var mask uint32
+	for _, tl := range limits {
+		mask |= typeAllocLimitBit(tl.typ)
+	}
+	lock(&l.lock)
+	l.limits = limits
+	atomic.Store(&l.mask, mask)
+	unlock(&l.lock)
+	semrelease(&typeAllocLimitSema)
+}
+
+// typeAllocThrottle counts an allocation of type typ against its
+// SetTypeAllocLimit limit, if any, and sleeps if the limit is exceeded.
+func typeAllocThrottle(typ *_type) {
+	l := &typeAllocLimits
+	var wait int64
+	lock(&l.lock)
+	for _, tl := range l.limits {
+		if tl.typ != typ {
+			continue
+		}
+		now := nanotime()
+		if now-tl.window >= 1e9 {
+			tl.window = now
+			tl.count = 0
+		}
+		tl.count++
+		if tl.count > tl.maxPerSec {
+			wait = tl.window + 1e9 - now
+		}
+		break
+	}
+	unlock(&l.lock)
+	if wait <= 0 {
+		return
+	}
+	mp := acquirem()
+	if gp := getg(); gp == mp.g0 || mp.locks > 1 || mp.preemptoff != "" {
+		releasem(mp)
+		return
+	}
+	releasem(mp)
+	timeSleep(wait)
+}
+
+// AllocBench allocates iterations pointer-free blocks of size bytes in
+// a tight loop and returns the average time each allocation took, in
+// nanoseconds, or 0 if iterations is not positive. It measures the
+// allocator itself, without the overhead of a benchmark framework or
+// of the code that would use the memory, for comparing allocator
+// throughput across releases and machines.
+//
+// Every block stays reachable until the run is over, so that none of
+// the allocations can be optimized away or reuse memory freed during
+// the run: a run retains iterations*size bytes, plus a pointer per
+// block, and the collections this triggers are part of the measured
+// time. Run a GC beforehand for more reproducible results.
+func AllocBench(size uintptr, iterations int) int64 {
+	if int(size) < 0 {
+		panic(plainError("runtime.AllocBench: size out of range"))
+	}
+	if iterations <= 0 {
+		return 0
+	}
+	keep := make([]unsafe.Pointer, iterations)
+	start := nanotime()
+	for i := range keep {
+		keep[i] = mallocgc(size, nil, 0)
+	}
+	return (nanotime() - start) / int64(iterations)
+}
+
 func profilealloc(mp *m, x unsafe.Pointer, size uintptr) {
 	mp.mcache.next_sample = nextSample()
 	mProf_Malloc(x, size)
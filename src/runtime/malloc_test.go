@@ -6,7 +6,14 @@ package runtime_test
 
 import (
 	"flag"
+	"internal/testenv"
+	"os"
+	"os/exec"
+	"reflect"
 	. "runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 	"unsafe"
@@ -74,37 +81,1990 @@ func TestMemStats(t *testing.T) {
 	}
 }
 
-func TestStringConcatenationAllocs(t *testing.T) {
-	n := testing.AllocsPerRun(1e3, func() {
-		b := make([]byte, 10)
-		for i := 0; i < 10; i++ {
-			b[i] = byte(i) + '0'
+func TestHeapInUseIdle(t *testing.T) {
+	GC()
+	var st MemStats
+	ReadMemStats(&st)
+	inuse, idle := HeapInUse(), HeapIdle()
+	// Other goroutines may allocate between the calls, so allow some slack.
+	const slack = 1 << 20
+	if d := int64(inuse) - int64(st.HeapInuse); inuse == 0 || d < -slack || d > slack {
+		t.Errorf("HeapInUse() = %d, MemStats.HeapInuse = %d", inuse, st.HeapInuse)
+	}
+	if d := int64(idle) - int64(st.HeapIdle); d < -slack || d > slack {
+		t.Errorf("HeapIdle() = %d, MemStats.HeapIdle = %d", idle, st.HeapIdle)
+	}
+}
+
+func TestPageStats(t *testing.T) {
+	GC()
+	var st MemStats
+	ReadMemStats(&st)
+	reserved, committed, released := PageStats()
+	const slack = 1 << 20
+	if d := int64(committed+released) - int64(st.HeapSys); d < -slack || d > slack {
+		t.Errorf("committed+released = %d, MemStats.HeapSys = %d", committed+released, st.HeapSys)
+	}
+	if d := int64(released) - int64(st.HeapReleased); d < -slack || d > slack {
+		t.Errorf("released = %d, MemStats.HeapReleased = %d", released, st.HeapReleased)
+	}
+	if reserved < committed+released {
+		t.Errorf("reserved = %d, less than the %d bytes mapped", reserved, committed+released)
+	}
+}
+
+func TestGCMetadataBytes(t *testing.T) {
+	var st MemStats
+	ReadMemStats(&st)
+	// GC metadata is only ever added, so it cannot have shrunk
+	// since ReadMemStats.
+	if got := GCMetadataBytes(); uint64(got) < st.GCSys {
+		t.Errorf("GCMetadataBytes() = %d, want at least MemStats.GCSys = %d", got, st.GCSys)
+	}
+	// The bitmap covers at least the mapped heap, at 2 bits per word.
+	if min := st.HeapSys / uint64(unsafe.Sizeof(uintptr(0))) / 4; st.GCSys < min {
+		t.Errorf("GCSys = %d, want at least %d for a %d-byte heap", st.GCSys, min, st.HeapSys)
+	}
+}
+
+var spansPerClassSink *[1 << 20]byte
+
+func TestSpansPerClass(t *testing.T) {
+	spansPerClassSink = new([1 << 20]byte)
+	counts := SpansPerClass()
+	if counts[0] == 0 {
+		t.Errorf("no large object spans while holding a 1MB object")
+	}
+	var st MemStats
+	ReadMemStats(&st)
+	for i, b := range st.BySize {
+		// The runtime itself holds some objects of most classes,
+		// but none can have objects without spans.
+		if b.Mallocs > b.Frees && i < len(counts) && counts[i] == 0 {
+			t.Errorf("size class %d (%d bytes) has %d live objects but no spans", i, b.Size, b.Mallocs-b.Frees)
 		}
-		s := "foo" + string(b)
-		if want := "foo0123456789"; s != want {
-			t.Fatalf("want %v, got %v", want, s)
+	}
+	spansPerClassSink = nil
+}
+
+func TestAllocClassHistogram(t *testing.T) {
+	// Keep collections from freeing objects during the test.
+	GC()
+	b := AllocationBarrier()
+	defer b.Release()
+	before := AllocClassHistogram()
+	var scan []*[12]*int
+	var noscan []*[96]byte
+	for i := 0; i < 1000; i++ {
+		scan = append(scan, new([12]*int))
+		noscan = append(noscan, new([96]byte))
+	}
+	after := AllocClassHistogram()
+	KeepAlive(scan)
+	KeepAlive(noscan)
+	for i, st := range after {
+		if st.Size != 96 {
+			continue
+		}
+		if n := int64(st.ScanObjects) - int64(before[i].ScanObjects); n < 1000 {
+			t.Errorf("%d more scannable 96-byte objects after allocating 1000", n)
+		}
+		if n := int64(st.NoScanObjects) - int64(before[i].NoScanObjects); n < 1000 {
+			t.Errorf("%d more pointer-free 96-byte objects after allocating 1000", n)
 		}
+		if st.ScanBytes != st.ScanObjects*96 || st.NoScanBytes != st.NoScanObjects*96 {
+			t.Errorf("byte counts %+v do not match object counts", st)
+		}
+		return
+	}
+	t.Fatalf("no 96-byte size class")
+}
+
+func TestHeapSnapshotDiff(t *testing.T) {
+	GC()
+	b := AllocationBarrier()
+	defer b.Release()
+	before := HeapSnapshot()
+	var leak []*[12]*int
+	for i := 0; i < 1000; i++ {
+		leak = append(leak, new([12]*int))
+	}
+	big := make([]byte, 200<<10)
+	after := HeapSnapshot()
+	KeepAlive(leak)
+	KeepAlive(big)
+
+	deltas := HeapSnapshotDiff(before, after)
+	var small, large bool
+	for i, d := range deltas {
+		if i > 0 && d.Bytes > deltas[i-1].Bytes {
+			t.Errorf("deltas not sorted by bytes: %+v after %+v", d, deltas[i-1])
+		}
+		switch {
+		case d.Size == 96 && d.Pointers:
+			small = true
+			if d.Objects < 1000 || d.Bytes != d.Objects*96 {
+				t.Errorf("96-byte objects with pointers changed by %+v, want at least 1000 objects", d)
+			}
+		case d.Size == 200<<10 && !d.Pointers:
+			large = true
+			if d.Objects != 1 || d.Bytes != 200<<10 {
+				t.Errorf("200 kB pointer-free objects changed by %+v, want 1 object", d)
+			}
+		}
+	}
+	if !small || !large {
+		t.Fatalf("HeapSnapshotDiff = %+v, missing allocated objects", deltas)
+	}
+	if d := HeapSnapshotDiff(after, after); len(d) != 0 {
+		t.Fatalf("HeapSnapshotDiff of a census with itself = %+v", d)
+	}
+}
+
+type liveBytesNode struct {
+	next  *liveBytesNode
+	data  [5]uintptr
+	owner *int
+}
+
+func TestLiveBytesForType(t *testing.T) {
+	GC()
+	b := AllocationBarrier()
+	defer b.Release()
+	_, before := LiveBytesForType((*liveBytesNode)(nil))
+	var nodes []*liveBytesNode
+	for i := 0; i < 500; i++ {
+		nodes = append(nodes, new(liveBytesNode))
+	}
+	// Same size, different layout.
+	var others []*[7]*int
+	for i := 0; i < 500; i++ {
+		others = append(others, new([7]*int))
+	}
+	bytes, count := LiveBytesForType((*liveBytesNode)(nil))
+	KeepAlive(nodes)
+	KeepAlive(others)
+	if count-before != 500 {
+		t.Errorf("LiveBytesForType counted %d new objects, want 500", count-before)
+	}
+	if bytes != count*64 {
+		t.Errorf("LiveBytesForType = %d bytes for %d 64-byte objects", bytes, count)
+	}
+}
+
+func TestReachableFrom(t *testing.T) {
+	var head *liveBytesNode
+	for i := 0; i < 10; i++ {
+		head = &liveBytesNode{next: head, owner: new(int)}
+	}
+	other := new(liveBytesNode)
+	want := map[unsafe.Pointer]bool{}
+	for n := head; n != nil; n = n.next {
+		// The ints share blocks in the tiny allocator, so
+		// only the nodes have known addresses.
+		want[unsafe.Pointer(n)] = true
+	}
+	var got []unsafe.Pointer
+	ReachableFrom(head, func(p unsafe.Pointer, size uintptr) {
+		got = append(got, p)
 	})
-	// Only string concatenation allocates.
-	if n != 1 {
-		t.Fatalf("want 1 allocation, got %v", n)
+	KeepAlive(other)
+	if len(got) == 0 || got[0] != unsafe.Pointer(head) {
+		t.Fatalf("ReachableFrom did not start at the root")
+	}
+	for _, p := range got {
+		if p == unsafe.Pointer(other) {
+			t.Errorf("unrelated object reported as reachable")
+		}
+		delete(want, p)
+	}
+	if len(want) != 0 {
+		t.Errorf("%d reachable objects not reported", len(want))
 	}
 }
 
-func TestTinyAlloc(t *testing.T) {
-	const N = 16
-	var v [N]unsafe.Pointer
-	for i := range v {
-		v[i] = unsafe.Pointer(new(byte))
+func TestForEachLiveObjectParallel(t *testing.T) {
+	objs := make([]*[64]byte, 1000)
+	want := make(map[unsafe.Pointer]bool, len(objs))
+	for i := range objs {
+		objs[i] = new([64]byte)
+		want[unsafe.Pointer(objs[i])] = true
+	}
+	var mu sync.Mutex
+	ForEachLiveObjectParallel(4, func(p unsafe.Pointer, size uintptr) {
+		mu.Lock()
+		if want[p] {
+			if size != 64 {
+				t.Errorf("object %p reported with size %d, want 64", p, size)
+			}
+			delete(want, p)
+		}
+		mu.Unlock()
+	})
+	KeepAlive(objs)
+	if len(want) != 0 {
+		t.Errorf("%d of %d live objects not reported", len(want), len(objs))
 	}
+}
 
-	chunks := make(map[uintptr]bool, N)
-	for _, p := range v {
-		chunks[uintptr(p)&^7] = true
+func TestSpanUtilization(t *testing.T) {
+	spansPerClassSink = new([1 << 20]byte)
+	util := SpanUtilization()
+	spans := SpansPerClass()
+	spansPerClassSink = nil
+	if util[0].Histogram[len(util[0].Histogram)-1] == 0 {
+		t.Errorf("no full large object spans while holding a 1MB object")
+	}
+	for i, u := range util {
+		var n uint64
+		for _, c := range u.Histogram {
+			n += c
+		}
+		if n != u.Spans {
+			t.Errorf("class %d: histogram counts %d spans, want %d", i, n, u.Spans)
+		}
+		if u.Spans == 0 && u.Objects != 0 || u.Objects*uint64(u.Size) > u.Bytes {
+			t.Errorf("class %d: %d objects of %d bytes in %d spans of %d bytes", i, u.Objects, u.Size, u.Spans, u.Bytes)
+		}
+		// Other goroutines may allocate between the two calls.
+		if d := int(u.Spans) - spans[i]; d < -10 || d > 10 {
+			t.Errorf("class %d: %d spans, SpansPerClass reports %d", i, u.Spans, spans[i])
+		}
 	}
+}
 
-	if len(chunks) == N {
-		t.Fatal("no bytes allocated within the same 8-byte chunk")
+func TestCompactableBytes(t *testing.T) {
+	// Fill 512 spans of 1 KB objects and keep one object in eight,
+	// leaving spans an eighth full.
+	objs := make([]*[1024]byte, 4096)
+	for i := range objs {
+		objs[i] = new([1024]byte)
+	}
+	for i := range objs {
+		if i%8 != 0 {
+			objs[i] = nil
+		}
+	}
+	GC()
+	n := CompactableBytes()
+	KeepAlive(objs)
+	if n < 1<<20 {
+		t.Errorf("CompactableBytes = %d with 512 spans an eighth full, want at least 1 MB", n)
+	}
+}
+
+func TestSpanLeakReport(t *testing.T) {
+	// Collections started by the allocations below, as when GOGC
+	// is low, let spans be reported early; checks that assume none
+	// ran are skipped if some did.
+	var ms MemStats
+	SpanLeakReport()
+	ReadMemStats(&ms)
+	numGC := ms.NumGC
+	// Fill 512 spans of 1 KB objects and keep one object in eight,
+	// pinning each span with a single object.
+	objs := make([]*[1024]byte, 4096)
+	for i := range objs {
+		objs[i] = new([1024]byte)
+	}
+	for i := range objs {
+		if i%8 != 0 {
+			objs[i] = nil
+		}
+	}
+	GC()
+	r := SpanLeakReport()
+	ReadMemStats(&ms)
+	if len(r) > 100 && ms.NumGC == numGC+1 {
+		t.Errorf("%d spans reported at the first call after allocating", len(r))
+	}
+	numGC = ms.NumGC
+	r = SpanLeakReport()
+	ReadMemStats(&ms)
+	if r != nil && ms.NumGC == numGC {
+		t.Errorf("%d spans reported with no collection since the last call", len(r))
+	}
+	GC()
+	found := 0
+	for _, p := range SpanLeakReport() {
+		if p.Size != 1024 || p.SpanBytes != 8192 {
+			continue
+		}
+		for i := 0; i < len(objs); i += 8 {
+			if p.Object == uintptr(unsafe.Pointer(objs[i])) {
+				found++
+			}
+		}
+	}
+	KeepAlive(objs)
+	if found < 256 {
+		t.Errorf("%d of 512 pinned spans reported", found)
+	}
+}
+
+func TestSpanStateCounts(t *testing.T) {
+	spansPerClassSink = new([1 << 20]byte)
+	total := 0
+	for _, n := range SpansPerClass() {
+		total += n
+	}
+	inUse, _, stack, _ := SpanStateCounts()
+	spansPerClassSink = nil
+	if stack == 0 {
+		t.Errorf("no stack spans")
+	}
+	// Other goroutines may allocate between the two calls.
+	if inUse < total-100 || inUse > total+100 {
+		t.Errorf("SpanStateCounts reports %d in-use spans, SpansPerClass %d", inUse, total)
+	}
+}
+
+var allocRateSink []*[40]byte
+
+func TestAllocRatePerClass(t *testing.T) {
+	const N = 1000
+	AllocRatePerClass()
+	for i := 0; i < N; i++ {
+		allocRateSink = append(allocRateSink[:0], new([40]byte))
+	}
+	allocRateSink = nil
+	counts := AllocRatePerClass()
+	var st MemStats
+	ReadMemStats(&st)
+	for i, b := range st.BySize {
+		if b.Size == 48 && counts[i] < N {
+			t.Errorf("%d allocations in the 48-byte class, want at least %d", counts[i], N)
+		}
+	}
+	// Each call starts counting afresh.
+	if counts := AllocRatePerClass(); counts[0] > N/10 {
+		t.Errorf("%d large allocations since the last call", counts[0])
+	}
+}
+
+var dmaSink []byte
+
+func TestAllocDMA(t *testing.T) {
+	for _, size := range []uintptr{1, 100 << 10, 4 << 20} {
+		b := AllocDMA(size)
+		if uintptr(len(b)) != size || cap(b) != len(b) {
+			t.Fatalf("AllocDMA(%d) returned len %d cap %d", size, len(b), cap(b))
+		}
+		// The heap page size is 8 KB.
+		if p := uintptr(unsafe.Pointer(&b[0])); p&(8192-1) != 0 {
+			t.Errorf("AllocDMA(%d) = %#x, not page-aligned", size, p)
+		}
+		for _, c := range b {
+			if c != 0 {
+				t.Fatalf("AllocDMA(%d) returned memory that is not zeroed", size)
+			}
+		}
+	}
+
+	var st MemStats
+	GC()
+	dmaSink = AllocDMA(8 << 20)
+	ReadMemStats(&st)
+	released := st.HeapReleased
+	dmaSink = nil
+	GC()
+	ReadMemStats(&st)
+	if st.HeapReleased < released+8<<20 {
+		t.Errorf("freeing an 8 MB AllocDMA buffer released %d bytes", int64(st.HeapReleased-released))
+	}
+}
+
+var lifetimeSink unsafe.Pointer
+
+func TestAllocWithLifetime(t *testing.T) {
+	const size = 8 << 20
+	for _, tt := range []struct {
+		lifetime Lifetime
+		limit    int64 // for ScavengeOlder
+		released bool
+	}{
+		{LifetimeEphemeral, 3600e9, true},
+		{LifetimePersistent, 1, false},
+		{LifetimePersistent, 0, true},
+	} {
+		GC()
+		lifetimeSink = AllocWithLifetime(size, nil, tt.lifetime)
+		p := uintptr(lifetimeSink)
+		lifetimeSink = nil
+		GC()
+		ScavengeOlder(tt.limit)
+		free, released := FreeSpanReleased(p)
+		if !free {
+			t.Fatalf("lifetime %d: object not freed", tt.lifetime)
+		}
+		// Pages may be released only in larger physical pages.
+		if got := released >= size/2; got != tt.released {
+			t.Errorf("lifetime %d, scavenger limit %d: released %d bytes of %d", tt.lifetime, tt.limit, released, size)
+		}
+	}
+}
+
+func TestAllocBench(t *testing.T) {
+	if ns := AllocBench(64, 0); ns != 0 {
+		t.Errorf("AllocBench(64, 0) = %d, want 0", ns)
+	}
+	const N = 10000
+	var before, after MemStats
+	ReadMemStats(&before)
+	ns := AllocBench(64, N)
+	ReadMemStats(&after)
+	if ns <= 0 {
+		t.Errorf("AllocBench(64, %d) = %d", N, ns)
+	}
+	if n := after.Mallocs - before.Mallocs; n < N {
+		t.Errorf("AllocBench(64, %d) made %d allocations", N, n)
+	}
+}
+
+var streamSink []byte
+
+func TestAllocStreamingZero(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		// Dirty some large spans, then free them for reuse.
+		for j := 0; j < 4; j++ {
+			streamSink = make([]byte, 1<<20)
+			for k := range streamSink {
+				streamSink[k] = 0xff
+			}
+		}
+		streamSink = nil
+		GC()
+		for _, size := range []uintptr{0, 100, 40 << 10, 1 << 20} {
+			b := AllocStreamingZero(size)
+			if uintptr(len(b)) != size || cap(b) != len(b) {
+				t.Fatalf("AllocStreamingZero(%d) returned len %d cap %d", size, len(b), cap(b))
+			}
+			for _, c := range b {
+				if c != 0 {
+					t.Fatalf("AllocStreamingZero(%d) returned memory that is not zeroed", size)
+				}
+			}
+		}
+	}
+}
+
+func TestAllocPageAligned(t *testing.T) {
+	for _, size := range []uintptr{1, 100, 8192, 100 << 10} {
+		p := AllocPageAligned(size)
+		// The heap page size is 8 KB.
+		if uintptr(p)&(8192-1) != 0 {
+			t.Errorf("AllocPageAligned(%d) = %p, not page-aligned", size, p)
+		}
+		n := size
+		if n < 8192 {
+			n = 8192
+		}
+		b := (*[100 << 10]byte)(p)[:n:n]
+		for _, c := range b {
+			if c != 0 {
+				t.Fatalf("AllocPageAligned(%d) returned memory that is not zeroed", size)
+			}
+		}
+		for i := range b {
+			b[i] = 1
+		}
+	}
+}
+
+var isoHeapRef *[64]byte
+
+// allocIsolated stores a block of h in isoHeapRef, so that no stack frame
+// of the test holds a pointer to it.
+//go:noinline
+func allocIsolated(h *IsolatedHeap) {
+	p := (*[64]byte)(h.Alloc(64))
+	for i, c := range p {
+		if c != 0 {
+			panic("IsolatedHeap.Alloc returned memory that is not zeroed")
+		}
+		p[i] = byte(i)
+	}
+	isoHeapRef = p
+}
+
+func TestIsolatedHeap(t *testing.T) {
+	h := NewIsolatedHeap(1 << 20)
+	allocIsolated(h)
+	if h.Alloc(2<<20) != nil {
+		t.Errorf("Alloc beyond the reservation did not return nil")
+	}
+	if h.Destroy() {
+		t.Fatalf("Destroy succeeded while a global referred to the heap")
+	}
+	if isoHeapRef[10] != 10 {
+		t.Fatalf("failed Destroy changed the heap")
+	}
+	isoHeapRef = nil
+	if !h.Destroy() {
+		t.Fatalf("Destroy failed with no references to the heap")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Alloc after Destroy did not panic")
+		}
+	}()
+	h.Alloc(8)
+}
+
+var latencySink []byte
+
+func TestSetAllocLatencyBudget(t *testing.T) {
+	var calls, size uintptr
+	SetAllocLatencyBudget(1, func(sz uintptr, elapsed int64) {
+		calls++
+		size = sz
+	})
+	latencySink = make([]byte, 1<<20)
+	SetAllocLatencyBudget(0, nil)
+	if calls == 0 || size != 1<<20 {
+		t.Errorf("1 MB allocation over a 1ns budget reported %d times, size %d", calls, size)
+	}
+	calls = 0
+	latencySink = make([]byte, 1<<20)
+	latencySink = nil
+	if calls != 0 {
+		t.Errorf("callback called after the budget was removed")
+	}
+}
+
+func TestAllocInClass(t *testing.T) {
+	type T struct {
+		p *int
+		b [40]byte
+	}
+	class := ClassForType((*T)(nil))
+	if class == 0 || ClassForType((*[64 << 10]byte)(nil)) != 0 {
+		t.Fatalf("ClassForType returned %d for a 48-byte type", class)
+	}
+	x := (*T)(AllocInClass(class, (*T)(nil)))
+	if x.p != nil || ObjectSize(unsafe.Pointer(x)) != 48 {
+		t.Errorf("AllocInClass returned a bad object")
+	}
+	panics := func(class int, typ interface{}) (ok bool) {
+		defer func() {
+			ok = recover() != nil
+		}()
+		AllocInClass(class, typ)
+		return
+	}
+	if !panics(class+1, (*T)(nil)) {
+		t.Errorf("AllocInClass with the wrong class did not panic")
+	}
+	if !panics(ClassForType((*[8]byte)(nil)), (*[8]byte)(nil)) {
+		t.Errorf("AllocInClass of a tiny pointer-free type did not panic")
+	}
+}
+
+type eventNode struct {
+	next *eventNode
+	b    [100]byte
+}
+
+var eventSink *eventNode
+
+func TestAllocEventChannel(t *testing.T) {
+	// Keep the collector from allocating while events are sent.
+	defer debug.SetGCPercent(debug.SetGCPercent(-1))
+	const n = 1000
+	dropped := AllocEventsDropped()
+	c := AllocEventChannel(1)
+	for i := 0; i < n; i++ {
+		eventSink = new(eventNode)
+	}
+	if AllocEventChannel(0) != nil {
+		t.Errorf("AllocEventChannel(0) returned a channel")
+	}
+	dropped = AllocEventsDropped() - dropped
+	eventSink = nil
+	found := false
+	received := 0
+	for len(c) > 0 {
+		ev := <-c
+		received++
+		if ev.Type == "runtime_test.eventNode" && ev.Size == 112 && ev.Addr != 0 && ev.Time > 0 {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("no event for eventNode allocations")
+	}
+	if received+int(dropped) != n {
+		t.Errorf("received %d events and dropped %d for %d allocations", received, dropped, n)
+	}
+}
+
+var sizeHistSink []byte
+
+func TestAllocSizeBucket(t *testing.T) {
+	want := func(size uintptr) int {
+		i := 0
+		for ; size > 0; size >>= 1 {
+			i++
+		}
+		if i > 47 {
+			i = 47
+		}
+		return i
+	}
+	for shift := uint(0); shift < 8*uint(unsafe.Sizeof(uintptr(0))); shift++ {
+		for _, size := range []uintptr{1<<shift - 1, 1 << shift, 1<<shift + 1} {
+			if size == 0 {
+				continue
+			}
+			if got := AllocSizeBucketOf(size); got != want(size) {
+				t.Errorf("allocSizeBucket(%d) = %d, want %d", size, got, want(size))
+			}
+		}
+	}
+	if got := AllocSizeBucketOf(^uintptr(0)); got != want(^uintptr(0)) {
+		t.Errorf("allocSizeBucket(%d) = %d, want %d", ^uintptr(0), got, want(^uintptr(0)))
+	}
+}
+
+func TestAllocSizeHistogram(t *testing.T) {
+	count := func() uint64 {
+		for _, b := range AllocSizeHistogram() {
+			if b.MinSize <= 3000 && 3000 <= b.MaxSize {
+				if b.MinSize != 2048 || b.MaxSize != 4095 {
+					t.Fatalf("3000 bytes counted in bucket [%d, %d]", b.MinSize, b.MaxSize)
+				}
+				return b.Count
+			}
+		}
+		return 0
+	}
+	ResetAllocSizeHistogram()
+	for i := 0; i < 100; i++ {
+		sizeHistSink = make([]byte, 3000)
+	}
+	sizeHistSink = nil
+	if n := count(); n < 100 {
+		t.Errorf("100 allocations of 3000 bytes counted %d times", n)
+	}
+	ResetAllocSizeHistogram()
+	if n := count(); n >= 100 {
+		t.Errorf("%d allocations counted after reset", n)
+	}
+}
+
+type permanentNode struct {
+	next *permanentNode
+	val  int
+}
+
+func TestAllocPermanent(t *testing.T) {
+	var head *permanentNode
+	for i := 0; i < 100; i++ {
+		n := (*permanentNode)(AllocPermanent(unsafe.Sizeof(permanentNode{}), (*permanentNode)(nil)))
+		if n.next != nil || n.val != 0 {
+			t.Fatalf("AllocPermanent returned memory that is not zeroed")
+		}
+		if ObjectSize(unsafe.Pointer(n)) != 0 {
+			t.Fatalf("AllocPermanent returned a heap object")
+		}
+		n.next, n.val = head, i
+		head = n
+	}
+	GC()
+	GC()
+	i := 99
+	for n := head; n != nil; n = n.next {
+		if n.val != i {
+			t.Fatalf("permanent node %d holds %d", i, n.val)
+		}
+		i--
+	}
+	if i != -1 {
+		t.Errorf("permanent list lost %d nodes", i+1)
+	}
+}
+
+type nearNode struct {
+	next *nearNode
+	pad  [40]byte
+}
+
+var nearSink []*nearNode
+
+func TestAllocNear(t *testing.T) {
+	// Leave a few live objects in otherwise free spans.
+	var hints []*nearNode
+	for i := 0; i < 1000; i++ {
+		n := new(nearNode)
+		if i%100 == 0 {
+			hints = append(hints, n)
+		}
+	}
+	GC()
+	for _, hint := range hints {
+		p := AllocNear(unsafe.Pointer(hint), (*nearNode)(nil))
+		nearSink = append(nearSink, (*nearNode)(p))
+		// Spans of 48-byte objects are one 8 kB page.
+		if uintptr(p)&^(8192-1) == uintptr(unsafe.Pointer(hint))&^(8192-1) {
+			nearSink = nil
+			KeepAlive(hints)
+			return
+		}
+	}
+	nearSink = nil
+	t.Errorf("no object allocated in the span of its hint")
+}
+
+var allocBudgetSink []byte
+
+type ssoT struct {
+	p *[2]int
+}
+
+func TestAllocSSO(t *testing.T) {
+	for _, inlineCap := range []uintptr{0, 1, 8, 100, 40 << 10} {
+		p := (*ssoT)(AllocSSO(inlineCap, (*ssoT)(nil)))
+		b := SSOInline(unsafe.Pointer(p), (*ssoT)(nil))
+		if uintptr(len(b)) < inlineCap {
+			t.Fatalf("AllocSSO(%d) gave an inline buffer of %d bytes", inlineCap, len(b))
+		}
+		if n := ObjectSize(unsafe.Pointer(p)); uintptr(len(b)) != n-unsafe.Sizeof(*p) {
+			t.Fatalf("AllocSSO(%d) gave an inline buffer of %d bytes in a block of %d", inlineCap, len(b), n)
+		}
+		for _, c := range b {
+			if c != 0 {
+				t.Fatalf("AllocSSO(%d) returned memory that is not zeroed", inlineCap)
+			}
+		}
+		if len(b) < int(unsafe.Sizeof(uintptr(0))) {
+			continue
+		}
+
+		// The typed prefix keeps its target alive, and a pointer
+		// stored in the inline buffer does not.
+		kept, freed := make(chan bool, 1), make(chan bool, 1)
+		x, y := new([2]int), new([2]int) // too big for the tiny allocator
+		SetFinalizer(x, func(*[2]int) { kept <- true })
+		SetFinalizer(y, func(*[2]int) { freed <- true })
+		p.p = x
+		*(**[2]int)(unsafe.Pointer(&b[0])) = y
+		x, y = nil, nil
+		GC()
+		select {
+		case <-freed:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("AllocSSO(%d): object referred to only from the inline buffer was not freed", inlineCap)
+		}
+		select {
+		case <-kept:
+			t.Fatalf("AllocSSO(%d): object referred to from the typed prefix was freed", inlineCap)
+		default:
+		}
+		*(**[2]int)(unsafe.Pointer(&b[0])) = nil
+		KeepAlive(p)
+	}
+}
+
+func TestWithAllocBudget(t *testing.T) {
+	WithAllocBudget(1<<10, func() {
+		allocBudgetSink = make([]byte, 512)
+		allocBudgetSink = make([]byte, 500)
+	})
+
+	exceeded := func(f func()) (panicked bool) {
+		defer func() {
+			panicked = recover() != nil
+		}()
+		f()
+		return false
+	}
+	if !exceeded(func() {
+		WithAllocBudget(1<<10, func() {
+			allocBudgetSink = make([]byte, 512)
+			allocBudgetSink = make([]byte, 600)
+		})
+	}) {
+		t.Errorf("allocating over the budget did not panic")
+	}
+	if exceeded(func() { allocBudgetSink = make([]byte, 1<<20) }) {
+		t.Errorf("budget still in effect after WithAllocBudget returned")
+	}
+
+	// An inner budget's allocations count against the outer one.
+	if !exceeded(func() {
+		WithAllocBudget(1<<10, func() {
+			WithAllocBudget(1<<10, func() {
+				allocBudgetSink = make([]byte, 800)
+			})
+			allocBudgetSink = make([]byte, 800)
+		})
+	}) {
+		t.Errorf("nested budgets did not add up")
+	}
+	allocBudgetSink = nil
+
+	// Going over the budget in the middle of a map assignment
+	// leaves the map usable.
+	m := make(map[int][64]byte)
+	if !exceeded(func() {
+		WithAllocBudget(1, func() { m[1] = [64]byte{1} })
+	}) {
+		t.Errorf("map assignment over the budget did not panic")
+	}
+	m[2] = [64]byte{2}
+	if len(m) != 2 || m[1][0] != 1 || m[2][0] != 2 {
+		t.Errorf("map corrupted by an assignment over the budget")
+	}
+}
+
+var noscanCheckSink interface{}
+
+func TestNoScanCheck(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") == "1" {
+		SetNoScanCheck(1)
+		noscanCheckSink = AllocNoZero((**int)(nil))
+		return
+	}
+
+	defer SetNoScanCheck(SetNoScanCheck(1))
+	// Allocations of all sorts pass the check.
+	for i := 0; i < 100; i++ {
+		noscanCheckSink = make([]*int, i)
+		noscanCheckSink = make([]byte, i)
+		noscanCheckSink = map[int]*int{i: new(int)}
+		noscanCheckSink = reflect.New(reflect.ArrayOf(i, reflect.TypeOf(&i))).Interface()
+		noscanCheckSink = reflect.New(reflect.StructOf([]reflect.StructField{
+			{Name: "X", Type: reflect.TypeOf(i)},
+			{Name: "Y", Type: reflect.TypeOf("")},
+		})).Interface()
+		noscanCheckSink = AllocNoZero((*[4]int)(nil))
+	}
+	noscanCheckSink = nil
+
+	testenv.MustHaveExec(t)
+	cmd := exec.Command(os.Args[0], "-test.run=^TestNoScanCheck$")
+	cmd.Env = append(os.Environ(), "GO_WANT_HELPER_PROCESS=1")
+	out, _ := cmd.CombinedOutput()
+	want := "fatal error: mallocgc: unzeroed allocation of type with pointers"
+	if !strings.Contains(string(out), want) {
+		t.Fatalf("output does not contain %q:\n%s", want, out)
+	}
+}
+
+var (
+	allocZeroSink    []byte
+	allocZeroIntSink []int
+)
+
+func TestSetAllocZeroPolicy(t *testing.T) {
+	const size = 48 << 10
+	SetAllocZeroPolicy(size, size+100, false)
+	defer SetAllocZeroPolicy(0, ^uintptr(0), true)
+
+	// Leave garbage in memory that later allocations may reuse.
+	for i := 0; i < 10; i++ {
+		b := make([]byte, size)
+		for j := range b {
+			b[j] = 0xff
+		}
+		allocZeroSink = b
+	}
+	allocZeroSink = nil
+	GC()
+
+	// Objects with pointers are always zeroed.
+	for i := 0; i < 10; i++ {
+		for j, p := range make([]*byte, size/PtrSize) {
+			if p != nil {
+				t.Fatalf("element %d of new pointer slice is %p", j, p)
+			}
+		}
+	}
+
+	// Sizes outside the range are zeroed.
+	for i := 0; i < 10; i++ {
+		b := make([]byte, size+200)
+		for j, c := range b {
+			if c != 0 {
+				t.Fatalf("byte %d of new %d-byte slice is %#x", j, len(b), c)
+			}
+			b[j] = 0xff
+		}
+		allocZeroSink = b
+	}
+
+	SetAllocZeroPolicy(size, size, true)
+	allocZeroSink = nil
+	GC()
+	for i := 0; i < 10; i++ {
+		b := make([]byte, size)
+		for j, c := range b {
+			if c != 0 {
+				t.Fatalf("byte %d of new slice is %#x after zeroing was restored", j, c)
+			}
+		}
+	}
+
+	// Maps and channels are zeroed whatever the range.
+	SetAllocZeroPolicy(16, 4096, false)
+	for i := 0; i < 100; i++ {
+		b := make([]byte, 16+i*40)
+		for j := range b {
+			b[j] = 0xff
+		}
+		allocZeroSink = b
+	}
+	allocZeroSink = nil
+	GC()
+	for i := 0; i < 100; i++ {
+		c := make(chan int, 100)
+		c <- i
+		if len(c) != 1 || <-c != i {
+			t.Fatalf("channel made with zeroing skipped is corrupted")
+		}
+		m := make(map[int]int)
+		for j := 0; j < 20; j++ {
+			m[j] = j
+		}
+		if len(m) != 20 || m[7] != 7 {
+			t.Fatalf("map made with zeroing skipped is corrupted")
+		}
+	}
+
+	// So are slices the runtime makes for itself, such as the
+	// counts of SpansPerClass.
+	n := len(SpansPerClass())
+	for i := 0; i < 100; i++ {
+		b := make([]int, n)
+		for j := range b {
+			b[j] = -1
+		}
+		allocZeroIntSink = b
+	}
+	allocZeroIntSink = nil
+	GC()
+	for i := 0; i < 100; i++ {
+		for class, c := range SpansPerClass() {
+			if c < 0 {
+				t.Fatalf("SpansPerClass()[%d] = %d with zeroing skipped", class, c)
+			}
+		}
+	}
+}
+
+func TestStringConcatenationAllocs(t *testing.T) {
+	n := testing.AllocsPerRun(1e3, func() {
+		b := make([]byte, 10)
+		for i := 0; i < 10; i++ {
+			b[i] = byte(i) + '0'
+		}
+		s := "foo" + string(b)
+		if want := "foo0123456789"; s != want {
+			t.Fatalf("want %v, got %v", want, s)
+		}
+	})
+	// Only string concatenation allocates.
+	if n != 1 {
+		t.Fatalf("want 1 allocation, got %v", n)
+	}
+}
+
+func TestTinyAlloc(t *testing.T) {
+	const N = 16
+	var v [N]unsafe.Pointer
+	for i := range v {
+		v[i] = unsafe.Pointer(new(byte))
+	}
+
+	chunks := make(map[uintptr]bool, N)
+	for _, p := range v {
+		chunks[uintptr(p)&^7] = true
+	}
+
+	if len(chunks) == N {
+		t.Fatal("no bytes allocated within the same 8-byte chunk")
+	}
+}
+
+func TestLowFragMode(t *testing.T) {
+	defer SetLowFragMode(SetLowFragMode(true))
+
+	const N = 1000
+	b := make([]*byte, N)
+	w := make([]*int64, N)
+	for i := range b {
+		b[i] = new(byte)
+		*b[i] = byte(i)
+	}
+	for i := range w {
+		w[i] = new(int64)
+		*w[i] = int64(i)
+	}
+
+	// Objects smaller than 8 bytes are still combined.
+	chunks := make(map[uintptr]bool, N)
+	for _, p := range b {
+		chunks[uintptr(unsafe.Pointer(p))&^7] = true
+	}
+	if len(chunks) == N {
+		t.Fatal("no bytes allocated within the same 8-byte chunk")
+	}
+
+	GC()
+	for i := range b {
+		if *b[i] != byte(i) || *w[i] != int64(i) {
+			t.Fatalf("object %d corrupted: got %d and %d", i, *b[i], *w[i])
+		}
+	}
+}
+
+// TestSizeClassBestFit checks what SetLowFragMode relies on to leave
+// size-class selection alone: counting the waste at the end of spans,
+// no size class takes more memory per object than a larger one.
+func TestSizeClassBestFit(t *testing.T) {
+	size, span := SizeClassSpans()
+	for c := range size {
+		for d := c + 1; d < len(size); d++ {
+			// Compare span[c]/n(c) with span[d]/n(d).
+			nc, nd := span[c]/size[c], span[d]/size[d]
+			if span[c]*nd > span[d]*nc {
+				t.Errorf("%d-byte objects take %d bytes each, more than the %d bytes of %d-byte objects",
+					size[c], span[c]/nc, span[d]/nd, size[d])
+			}
+		}
+	}
+}
+
+var (
+	lowFragSink      *[8]byte
+	lowFragReuseSink *[2]int64
+)
+
+func TestLowFragModeSlotReuse(t *testing.T) {
+	// Interleave 8-byte pointer-free blocks with pointers, so that
+	// the slots of the blocks, once freed, are reused for pointers
+	// in spans that stay in use.
+	const N = 10000
+	keep := make([]**[2]int64, N)
+	func() {
+		defer SetLowFragMode(SetLowFragMode(true))
+		for i := range keep {
+			lowFragSink = new([8]byte)
+			keep[i] = new(*[2]int64)
+		}
+		lowFragSink = nil
+	}()
+	GC()
+	ps := make([]**[2]int64, N)
+	for i := range ps {
+		p := new(*[2]int64)
+		*p = &[2]int64{int64(i), int64(i)}
+		ps[i] = p
+	}
+	GC()
+	// Reuse anything freed by mistake.
+	for i := 0; i < 10*N; i++ {
+		lowFragReuseSink = &[2]int64{-1, -1}
+	}
+	lowFragReuseSink = nil
+	for i, p := range ps {
+		if (*p)[0] != int64(i) {
+			t.Fatalf("object %d freed while reachable: got %d", i, (*p)[0])
+		}
+	}
+	KeepAlive(keep)
+}
+
+func TestSetTinyAlloc(t *testing.T) {
+	if !TinyAllocEnabled() {
+		t.Fatal("tiny allocator disabled by default")
+	}
+	if !SetTinyAlloc(false) {
+		t.Fatal("SetTinyAlloc(false) reported it was already disabled")
+	}
+	defer SetTinyAlloc(true)
+	if TinyAllocEnabled() {
+		t.Fatal("TinyAllocEnabled() = true after SetTinyAlloc(false)")
+	}
+
+	// Each object gets its own 8-byte block.
+	const N = 1000
+	b := make([]*byte, N)
+	for i := range b {
+		b[i] = new(byte)
+		*b[i] = byte(i)
+	}
+	chunks := make(map[uintptr]bool, N)
+	for _, p := range b {
+		chunks[uintptr(unsafe.Pointer(p))&^7] = true
+	}
+	if len(chunks) != N {
+		t.Fatalf("%d bytes allocated in %d 8-byte chunks", N, len(chunks))
+	}
+	GC()
+	for i := range b {
+		if *b[i] != byte(i) {
+			t.Fatalf("object %d corrupted: got %d", i, *b[i])
+		}
+	}
+}
+
+func TestNewDistinctZero(t *testing.T) {
+	const N = 64
+	seen := make(map[unsafe.Pointer]bool, N)
+	shared := unsafe.Pointer(new(struct{}))
+	for i := 0; i < N; i++ {
+		p := NewDistinctZero()
+		if p == nil || p == shared || seen[p] {
+			t.Fatalf("NewDistinctZero returned shared address %p", p)
+		}
+		seen[p] = true
+	}
+}
+
+var zeroSizedSink []*struct{}
+
+func TestSetZeroSizedPolicy(t *testing.T) {
+	if old := SetZeroSizedPolicy(ZeroSizedDistinct); old != ZeroSizedShared {
+		t.Fatalf("default ZeroSizedPolicy is %d, want ZeroSizedShared", old)
+	}
+	for i := 0; i < 64; i++ {
+		zeroSizedSink = append(zeroSizedSink, new(struct{}))
+	}
+	SetZeroSizedPolicy(ZeroSizedShared)
+	seen := make(map[*struct{}]bool)
+	for _, p := range zeroSizedSink {
+		if seen[p] {
+			t.Fatalf("zero-sized objects share address %p with ZeroSizedDistinct", p)
+		}
+		seen[p] = true
+	}
+	zeroSizedSink = append(zeroSizedSink[:0], new(struct{}), new(struct{}))
+	if zeroSizedSink[0] != zeroSizedSink[1] {
+		t.Fatalf("zero-sized objects have distinct addresses with ZeroSizedShared")
+	}
+	zeroSizedSink = nil
+}
+
+func TestAlloc16(t *testing.T) {
+	for _, size := range []uintptr{0, 1, 8, 15, 16, 17, 24, 40, 100, 1000, 4000, 32 << 10, 40 << 10} {
+		for i := 0; i < 16; i++ {
+			p := Alloc16(size, nil)
+			if uintptr(p)%16 != 0 {
+				t.Fatalf("Alloc16(%d) = %p, not 16-byte aligned", size, p)
+			}
+		}
+	}
+	// A typed block keeps the objects it points to alive.
+	type node struct {
+		next *[16]byte
+		seq  uintptr
+	}
+	nodes := (*[3]node)(Alloc16(3*unsafe.Sizeof(node{}), (*node)(nil)))
+	if uintptr(unsafe.Pointer(nodes))%16 != 0 {
+		t.Fatalf("Alloc16 of nodes = %p, not 16-byte aligned", nodes)
+	}
+	finalized := make(chan bool, len(nodes))
+	for i := range nodes {
+		x := new([16]byte)
+		SetFinalizer(x, func(*[16]byte) { finalized <- true })
+		nodes[i].next = x
+	}
+	GC()
+	GC()
+	select {
+	case <-finalized:
+		t.Fatalf("object referenced from an Alloc16 block was freed")
+	case <-time.After(10 * time.Millisecond):
+	}
+	KeepAlive(nodes)
+}
+
+var allocFillSink []byte
+
+func TestSetAllocFill(t *testing.T) {
+	defer SetAllocFill(SetAllocFill(0xAA))
+	for _, n := range []int{1, 7, 16, 100, 5000, 40 << 10} {
+		allocFillSink = make([]byte, n)
+		for i, b := range allocFillSink {
+			if b != 0xAA {
+				t.Fatalf("make([]byte, %d)[%d] = %#x, want 0xaa", n, i, b)
+			}
+		}
+		// Filled slices skip the tiny allocator, but must not get
+		// 8-byte blocks, which GODEBUG=gccheckmark=1 scans.
+		if size := ObjectSize(unsafe.Pointer(&allocFillSink[0])); size < 16 {
+			t.Fatalf("make([]byte, %d) allocated a %d-byte block", n, size)
+		}
+	}
+	// Slices of pointers are still zeroed.
+	ptrs := make([]*int, 100)
+	for i, p := range ptrs {
+		if p != nil {
+			t.Fatalf("make([]*int, 100)[%d] = %p, want nil", i, p)
+		}
+	}
+	// So are slices the runtime makes for itself.
+	for class, c := range SpansPerClass() {
+		if c < 0 {
+			t.Fatalf("SpansPerClass()[%d] = %d with SetAllocFill(0xAA)", class, c)
+		}
+	}
+
+	SetAllocFill(0)
+	allocFillSink = make([]byte, 100)
+	for i, b := range allocFillSink {
+		if b != 0 {
+			t.Fatalf("make([]byte, 100)[%d] = %#x after SetAllocFill(0), want 0", i, b)
+		}
+	}
+}
+
+func TestAllocCold(t *testing.T) {
+	const N = 200
+	var hot, cold [N]unsafe.Pointer
+	for i := 0; i < N; i++ {
+		hot[i] = unsafe.Pointer(new([8]*int))
+		cold[i] = AllocCold(64, (*[8]*int)(nil))
+	}
+	// Spans for 64-byte objects are a single 8KB page, so
+	// cold objects must not share a page with other objects.
+	hotPages := make(map[uintptr]bool)
+	for _, p := range hot {
+		hotPages[uintptr(p)>>13] = true
+	}
+	for i, p := range cold {
+		if hotPages[uintptr(p)>>13] {
+			t.Fatalf("cold object %d at %p shares a page with a hot object", i, p)
+		}
+		if w := (*[8]*int)(p); *w != [8]*int{} {
+			t.Fatalf("cold object %d not zeroed", i)
+		}
+	}
+	KeepAlive(hot)
+	KeepAlive(cold)
+
+	// Small pointer-free cold objects must not get 8-byte blocks,
+	// which GODEBUG=gccheckmark=1 scans.
+	for _, size := range []uintptr{1, 8, 15} {
+		if n := ObjectSize(AllocCold(size, nil)); n != 16 {
+			t.Errorf("AllocCold(%d, nil) allocated a %d-byte block, want 16", size, n)
+		}
+	}
+}
+
+func TestAllocHotMutable(t *testing.T) {
+	for _, size := range []uintptr{1, 8, 16, 65, 200, 1000, 5000, 30000} {
+		var ps [10]unsafe.Pointer
+		for i := range ps {
+			ps[i] = AllocHotMutable(size, nil)
+		}
+		for _, p := range ps {
+			if uintptr(p)%CacheLineSize != 0 {
+				t.Fatalf("AllocHotMutable(%d) = %p, not aligned to a cache line", size, p)
+			}
+			n := ObjectSize(p)
+			if n < size || n%CacheLineSize != 0 {
+				t.Fatalf("AllocHotMutable(%d) allocated a %d-byte block", size, n)
+			}
+		}
+		KeepAlive(ps)
+	}
+	p := (*[8]*int)(AllocHotMutable(64, (*[8]*int)(nil)))
+	if *p != [8]*int{} {
+		t.Fatalf("AllocHotMutable block not zeroed")
+	}
+	KeepAlive(p)
+}
+
+func TestSetAllocErrorMode(t *testing.T) {
+	if PtrSize != 8 {
+		t.Skip("test requires a 64-bit heap arena")
+	}
+	if old := SetAllocErrorMode(AllocErrorPanic); old != AllocErrorFatal {
+		t.Fatalf("default AllocErrorMode is %d, want AllocErrorFatal", old)
+	}
+	defer SetAllocErrorMode(AllocErrorFatal)
+	// The heap arena cannot hold an object as big as all of it.
+	var err interface{}
+	func() {
+		defer func() { err = recover() }()
+		AllocDeferGC(MaxMem, nil)
+	}()
+	e, ok := err.(Error)
+	if !ok {
+		t.Fatalf("huge allocation recovered %v, want a runtime.Error", err)
+	}
+	if !strings.HasPrefix(e.Error(), "runtime: out of memory") {
+		t.Fatalf("huge allocation panicked with %q", e.Error())
+	}
+	// Allocation still works after the failure.
+	hugeSink = make([]byte, 1<<20)
+	hugeSink = nil
+}
+
+func TestInMalloc(t *testing.T) {
+	if InMalloc() {
+		t.Fatalf("InMalloc() = true outside an allocation")
+	}
+	if !InMallocWhileMallocing() {
+		t.Fatalf("InMalloc() = false during an allocation")
+	}
+}
+
+type objectTypeT struct {
+	p *int
+	n [4]int
+}
+
+var objectTypeSink []interface{}
+
+func TestObjectType(t *testing.T) {
+	x := new(objectTypeT)
+	if got := ObjectType(unsafe.Pointer(x)); got != nil {
+		t.Errorf("ObjectType without GODEBUG=objtypes = %T, want nil", got)
+	}
+	defer SetObjTypes(SetObjTypes(1))
+	x = new(objectTypeT)
+	s := make([]*int, 10)
+	a := new([3]int64)
+	objectTypeSink = append(objectTypeSink, x, s, a)
+	for _, tt := range []struct {
+		p    unsafe.Pointer
+		want reflect.Type
+	}{
+		{unsafe.Pointer(x), reflect.TypeOf(objectTypeT{})},
+		{unsafe.Pointer(&x.n[2]), reflect.TypeOf(objectTypeT{})},
+		{unsafe.Pointer(&s[0]), reflect.TypeOf((*int)(nil))},
+		{unsafe.Pointer(a), reflect.TypeOf([3]int64{})},
+		{unsafe.Pointer(&objectTypeSink), nil},
+	} {
+		if got := reflect.TypeOf(ObjectType(tt.p)); got != tt.want {
+			t.Errorf("ObjectType(%p) has type %v, want %v", tt.p, got, tt.want)
+		}
+	}
+	if v, ok := ObjectType(unsafe.Pointer(x)).(objectTypeT); !ok || v != (objectTypeT{}) {
+		t.Errorf("ObjectType(%p) = %v, want the zero objectTypeT", x, v)
+	}
+	objectTypeSink = nil
+}
+
+func TestMallocCycles(t *testing.T) {
+	count := func() (n uint64) {
+		for _, b := range MallocCycles() {
+			if b.MinCycles > b.MaxCycles {
+				t.Fatalf("bad bucket %+v", b)
+			}
+			n += b.Count
+		}
+		return n
+	}
+	before := count()
+	defer SetMallocTSC(SetMallocTSC(1))
+	for i := 0; i < 10000; i++ {
+		mallocSink = uintptr(unsafe.Pointer(new([4]int)))
+	}
+	if count() == before {
+		t.Fatalf("no fast-path allocations sampled with GODEBUG=malloctsc=1")
+	}
+}
+
+func TestObjectSize(t *testing.T) {
+	for _, tt := range []struct {
+		p    unsafe.Pointer
+		want uintptr
+	}{
+		{unsafe.Pointer(new([40]byte)), 48},
+		{unsafe.Pointer(&new([800]byte)[400]), 896},
+		{unsafe.Pointer(new([40 << 10]byte)), 40 << 10},
+		{unsafe.Pointer(&allocProfileSink), 0},
+	} {
+		if got := ObjectSize(tt.p); got != tt.want {
+			t.Errorf("ObjectSize(%p) = %d, want %d", tt.p, got, tt.want)
+		}
+	}
+}
+
+type zeroTailObj struct {
+	p [4]*int
+	b [200]byte
+}
+
+var zeroTailSink *zeroTailObj
+
+func TestZeroTail(t *testing.T) {
+	zeroTailSink = new(zeroTailObj)
+	x := zeroTailSink
+	for i := range x.p {
+		x.p[i] = new(int)
+	}
+	for i := range x.b {
+		x.b[i] = 1
+	}
+	// The prefix ends inside x.p[1], which is kept.
+	ZeroTail(unsafe.Pointer(x), unsafe.Sizeof(uintptr(0))+1)
+	if x.p[0] == nil || x.p[1] == nil || x.p[2] != nil || x.p[3] != nil {
+		t.Errorf("ZeroTail cleared the wrong pointers: %v", x.p)
+	}
+	for i, c := range x.b {
+		if c != 0 {
+			t.Fatalf("ZeroTail left byte %d set", i)
+		}
+	}
+
+	b := make([]byte, 100<<10)
+	dmaSink = b
+	for i := range b {
+		b[i] = 1
+	}
+	ZeroTail(unsafe.Pointer(&b[0]), 1000)
+	if b[999] != 1 || b[1000] != 0 || b[len(b)-1] != 0 {
+		t.Errorf("ZeroTail(b, 1000) left b[999], b[1000], b[len(b)-1] = %d, %d, %d", b[999], b[1000], b[len(b)-1])
+	}
+
+	defer func() {
+		zeroTailSink, dmaSink = nil, nil
+		if recover() == nil {
+			t.Errorf("ZeroTail of an inner pointer did not panic")
+		}
+	}()
+	ZeroTail(unsafe.Pointer(&b[1]), 0)
+}
+
+type allocTraceObj struct {
+	p *int
+	n [40]byte
+}
+
+func TestAllocTrace(t *testing.T) {
+	defer EnableAllocTrace(0)
+	EnableAllocTrace(4)
+	objs := make([]*allocTraceObj, 8)
+	for i := range objs {
+		objs[i] = new(allocTraceObj)
+	}
+	events := AllocTraceDump()
+	found := 0
+	for _, e := range events {
+		for _, obj := range objs[len(objs)-4:] {
+			if e.Addr == uintptr(unsafe.Pointer(obj)) {
+				if e.Type != "runtime_test.allocTraceObj" || e.Size != 48 {
+					t.Errorf("event for %p = %+v", obj, e)
+				}
+				found++
+			}
+		}
+	}
+	// The goroutine may have moved to another P, taking its
+	// earlier events with the old ring.
+	if found == 0 {
+		t.Errorf("no events for the last 4 allocations in %+v", events)
+	}
+	for i := 1; i < len(events); i++ {
+		if events[i].P == events[i-1].P && events[i].Time < events[i-1].Time {
+			t.Errorf("events out of order: %+v before %+v", events[i-1], events[i])
+		}
+	}
+
+	EnableAllocTrace(0)
+	mallocSink = uintptr(unsafe.Pointer(new(allocTraceObj)))
+	if events := AllocTraceDump(); events != nil {
+		t.Errorf("AllocTraceDump with tracing disabled = %+v, want nil", events)
+	}
+}
+
+func TestSlowAllocStats(t *testing.T) {
+	refills0, large0, fast0 := SlowAllocStats()
+	for i := 0; i < 1000; i++ {
+		mallocSink = uintptr(unsafe.Pointer(new([64]byte)))
+	}
+	for i := 0; i < 10; i++ {
+		mallocSink = uintptr(unsafe.Pointer(new([64 << 10]byte)))
+	}
+	refills, large, fast := SlowAllocStats()
+	// 1000 64-byte objects fill more than one 8KB span.
+	if refills <= refills0 {
+		t.Errorf("refills = %d, want more than %d", refills, refills0)
+	}
+	if large < large0+10 {
+		t.Errorf("largeAllocs = %d, want at least %d", large, large0+10)
+	}
+	if fast < fast0+900 {
+		t.Errorf("fastPath = %d, want at least %d", fast, fast0+900)
+	}
+}
+
+type typeAllocLimitObj struct {
+	p *int
+}
+
+var typeAllocLimitSink *typeAllocLimitObj
+
+func TestSetTypeAllocLimit(t *testing.T) {
+	SetTypeAllocLimit((*typeAllocLimitObj)(nil), 10)
+	defer SetTypeAllocLimit((*typeAllocLimitObj)(nil), 0)
+	start := time.Now()
+	for i := 0; i < 15; i++ {
+		typeAllocLimitSink = new(typeAllocLimitObj)
+	}
+	// 15 allocations at 10 per second take about a second.
+	if d := time.Since(start); d < 500*time.Millisecond {
+		t.Errorf("15 allocations limited to 10 per second took %v", d)
+	}
+
+	SetTypeAllocLimit((*typeAllocLimitObj)(nil), 0)
+	start = time.Now()
+	for i := 0; i < 1000; i++ {
+		typeAllocLimitSink = new(typeAllocLimitObj)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("1000 unlimited allocations took %v", d)
+	}
+}
+
+func TestAvgAllocSize(t *testing.T) {
+	defer GOMAXPROCS(GOMAXPROCS(1))
+	// The average samples one allocation in 8.
+	for i := 0; i < 4000; i++ {
+		mallocSink = uintptr(unsafe.Pointer(new([1000]byte)))
+	}
+	if avg := AvgAllocSize(); avg < 990 || avg > 1000 {
+		t.Errorf("AvgAllocSize() = %d after allocating 1000-byte objects, want about 1000", avg)
+	}
+	// Sizes are counted as requested, not as rounded up to cache
+	// lines.
+	for i := 0; i < 4000; i++ {
+		mallocSink = uintptr(AllocHotMutable(72, nil))
+	}
+	if avg := AvgAllocSize(); avg < 70 || avg > 80 {
+		t.Errorf("AvgAllocSize() = %d after allocating 72-byte hot mutable objects, want about 72", avg)
+	}
+}
+
+func TestPerPCacheAlloc(t *testing.T) {
+	before := PerPCacheAlloc()
+	if len(before) != GOMAXPROCS(-1) {
+		t.Fatalf("PerPCacheAlloc returned %d entries, want %d", len(before), GOMAXPROCS(-1))
+	}
+	for i := 0; i < 100; i++ {
+		allocProfileSink = append(allocProfileSink, new([64]byte))
+	}
+	allocProfileSink = nil
+	var delta int
+	for i, n := range PerPCacheAlloc() {
+		delta += n - before[i]
+	}
+	if delta < 100*64 {
+		t.Fatalf("per-P cache allocation grew by %d bytes, want at least %d", delta, 100*64)
+	}
+}
+
+func TestMCacheStats(t *testing.T) {
+	before := MCacheStats()
+	if len(before) != GOMAXPROCS(-1) {
+		t.Fatalf("MCacheStats returned %d entries, want %d", len(before), GOMAXPROCS(-1))
+	}
+	for i := 0; i < 100; i++ {
+		allocProfileSink = append(allocProfileSink, new([64]byte))
+	}
+	allocProfileSink = nil
+	var delta uint64
+	for i, st := range MCacheStats() {
+		delta += st.CacheAlloc - before[i].CacheAlloc
+		if len(st.Free) != len(SpansPerClass()) {
+			t.Fatalf("MCacheStats has %d size classes, want %d", len(st.Free), len(SpansPerClass()))
+		}
+		if st.TinyUsed > 16 {
+			t.Errorf("P %d uses %d bytes of a 16-byte tiny block", i, st.TinyUsed)
+		}
+	}
+	if delta < 100*64 {
+		t.Fatalf("per-P cache allocation grew by %d bytes, want at least %d", delta, 100*64)
+	}
+}
+
+func TestCentralCacheContention(t *testing.T) {
+	defer GOMAXPROCS(GOMAXPROCS(4))
+	// Each 32 kB object fills a span, so every allocation refills
+	// the P's cache from the same central free list.
+	before := CentralCacheContention()
+	for round := 0; round < 20; round++ {
+		done := make(chan uintptr)
+		for p := 0; p < 4; p++ {
+			go func() {
+				var x uintptr
+				for i := 0; i < 2000; i++ {
+					x ^= uintptr(unsafe.Pointer(new([32 << 10]byte)))
+				}
+				done <- x
+			}()
+		}
+		for p := 0; p < 4; p++ {
+			mallocSink ^= <-done
+		}
+		if CentralCacheContention() > before {
+			return
+		}
+	}
+	t.Fatalf("CentralCacheContention stayed at %d with 4 Ps refilling one size class", before)
+}
+
+var allocProfileSink []*[64]byte
+
+//go:noinline
+func allocProfileSite(n int) {
+	for i := 0; i < n; i++ {
+		allocProfileSink = append(allocProfileSink, new([64]byte))
+	}
+}
+
+func TestAllocProfileTable(t *testing.T) {
+	defer func(old int) { MemProfileRate = old }(MemProfileRate)
+	MemProfileRate = 1
+
+	const N = 100
+	allocProfileSite(N)
+	GC()
+	GC()
+	defer func() { allocProfileSink = nil }()
+
+	var objects, bytes int64
+	for _, s := range AllocProfileTable() {
+		if s.Func == "runtime_test.allocProfileSite" {
+			objects += s.InUseObjects
+			bytes += s.InUseBytes
+		}
+	}
+	if objects < N || bytes < N*64 {
+		t.Fatalf("allocProfileSite has %d objects and %d bytes in use; want at least %d and %d", objects, bytes, N, N*64)
+	}
+}
+
+var allocUnprofiledSink []unsafe.Pointer
+
+//go:noinline
+func allocUnprofiledSite(n int) {
+	for i := 0; i < n; i++ {
+		allocUnprofiledSink = append(allocUnprofiledSink, AllocUnprofiled(64, nil))
+	}
+}
+
+func TestAllocUnprofiled(t *testing.T) {
+	defer func(old int) { MemProfileRate = old }(MemProfileRate)
+	MemProfileRate = 1
+
+	allocUnprofiledSink = make([]unsafe.Pointer, 0, 100)
+	allocUnprofiledSite(100)
+	GC()
+	GC()
+	defer func() { allocUnprofiledSink = nil }()
+
+	for _, s := range AllocProfileTable() {
+		if s.Func == "runtime_test.allocUnprofiledSite" {
+			t.Fatalf("AllocUnprofiled allocations appear in the profile: %+v", s)
+		}
+	}
+}
+
+//go:noinline
+func profileExcludeSite(n int) {
+	for i := 0; i < n; i++ {
+		allocUnprofiledSink = append(allocUnprofiledSink, unsafe.Pointer(new([64]byte)))
+	}
+}
+
+func TestSetGoroutineProfileExclude(t *testing.T) {
+	defer func(old int) { MemProfileRate = old }(MemProfileRate)
+	MemProfileRate = 1
+
+	allocUnprofiledSink = make([]unsafe.Pointer, 0, 300)
+	defer func() { allocUnprofiledSink = nil }()
+	done := make(chan bool)
+	go func() {
+		if SetGoroutineProfileExclude(true) {
+			t.Errorf("new goroutine excluded from the profile")
+		}
+		profileExcludeSite(100)
+		// A child inherits the setting only if asked to.
+		child := make(chan bool)
+		go func() {
+			profileExcludeSite(100)
+			child <- true
+		}()
+		<-child
+		SetGoroutineProfileExcludeInherit(true)
+		go func() {
+			profileExcludeSite(100)
+			done <- true
+		}()
+	}()
+	<-done
+	GC()
+	GC()
+
+	var n int64
+	for _, s := range AllocProfileTable() {
+		if s.Func == "runtime_test.profileExcludeSite" {
+			n += s.InUseObjects
+		}
+	}
+	// Only the allocations of the included child are profiled.
+	if n != 100 {
+		t.Errorf("%d allocations profiled, want 100", n)
+	}
+}
+
+func TestAllocLazyBitmap(t *testing.T) {
+	// Keep a collection from running before the block is set up.
+	b := AllocationBarrier()
+	defer b.Release()
+	GC()
+
+	const n = 8192
+	p := (*[n]*int)(AllocLazyBitmap(n*unsafe.Sizeof(uintptr(0)), (**int)(nil)))
+	if !LazyBitsPending(unsafe.Pointer(p)) {
+		t.Fatalf("bitmap written at allocation")
+	}
+	finalized := make(chan int, 4)
+	for i := 0; i < 4; i++ {
+		x := new(int)
+		*x = i
+		SetFinalizer(x, func(x *int) { finalized <- *x })
+		p[i*2000] = x
+	}
+	GC()
+	if LazyBitsPending(unsafe.Pointer(p)) {
+		t.Fatalf("bitmap not written by GC")
+	}
+	GC()
+	select {
+	case i := <-finalized:
+		t.Fatalf("object %d stored in lazy bitmap block was freed", i)
+	case <-time.After(100 * time.Millisecond):
+	}
+	for i := 0; i < 4; i++ {
+		if *p[i*2000] != i {
+			t.Fatalf("object %d corrupted", i)
+		}
+	}
+}
+
+type slabNode struct {
+	n    int
+	next *int
+}
+
+var (
+	slabSink  [][]byte
+	slabStale []*[64]*int
+)
+
+func TestRegisterTypedRegion(t *testing.T) {
+	for _, size := range []int{64 << 10, 512} {
+		testRegisterTypedRegion(t, size)
+	}
+}
+
+func testRegisterTypedRegion(t *testing.T, size int) {
+	// Free many blocks of the slabs' size whose heap bitmap says
+	// every word is a pointer, so that small slabs reuse some.
+	slabStale = make([]*[64]*int, 1000)
+	for i := range slabStale {
+		slabStale[i] = new([64]*int)
+	}
+	slabStale = nil
+	GC()
+
+	// The bytes outside a registered region must not be scanned:
+	// an address stored there does not keep its object alive.
+	const nslab = 32
+	slabSink = make([][]byte, nslab)
+	defer func() { slabSink = nil }()
+	hidden := make(chan bool, nslab)
+	for i := range slabSink {
+		slabSink[i] = make([]byte, size)
+		y := new([2]int)
+		SetFinalizer(y, func(*[2]int) { hidden <- true })
+		*(*uintptr)(unsafe.Pointer(&slabSink[i][size/2-8])) = uintptr(unsafe.Pointer(y))
+		RegisterTypedRegion(unsafe.Pointer(&slabSink[i][size/2]), (*slabNode)(nil))
+	}
+	p := unsafe.Pointer(&slabSink[0][size/2])
+
+	freed := make(chan bool, 1)
+	func() {
+		x := new(int)
+		*x = 42
+		SetFinalizer(x, func(*int) { freed <- true })
+		(*slabNode)(p).next = x
+	}()
+	GC()
+	select {
+	case <-freed:
+		t.Fatalf("object referenced only from a registered region was freed")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if got := *(*slabNode)(p).next; got != 42 {
+		t.Fatalf("object in registered region = %d, want 42", got)
+	}
+	for i := 0; i < nslab; i++ {
+		select {
+		case <-hidden:
+		case <-time.After(4 * time.Second):
+			t.Fatalf("%d-byte slab: bytes outside a registered region were scanned as pointers", size)
+		}
+	}
+
+	// Registering a type without pointers drops the reference.
+	RegisterTypedRegion(p, (*[2]uintptr)(nil))
+	GC()
+	select {
+	case <-freed:
+	case <-time.After(4 * time.Second):
+		t.Fatalf("object was not freed after its region was made pointer-free")
+	}
+
+	for _, q := range []unsafe.Pointer{
+		unsafe.Pointer(&slabSink[0][1]),      // misaligned
+		unsafe.Pointer(&slabSink[0][size-8]), // does not fit
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("RegisterTypedRegion(%p) did not panic", q)
+				}
+			}()
+			RegisterTypedRegion(q, (*slabNode)(nil))
+		}()
+	}
+}
+
+func TestMaxAllocSeen(t *testing.T) {
+	ResetMaxAllocSeen()
+	const n = 3<<20 + 5
+	hugeSink = make([]byte, n)
+	hugeSink = nil
+	if got := MaxAllocSeen(); got < n {
+		t.Errorf("MaxAllocSeen() = %d after allocating %d bytes", got, n)
+	}
+	if got := ResetMaxAllocSeen(); got < n {
+		t.Errorf("ResetMaxAllocSeen() = %d, want at least %d", got, n)
+	}
+	if got := MaxAllocSeen(); got >= n {
+		t.Errorf("MaxAllocSeen() = %d after reset", got)
+	}
+
+	// Small allocations are recorded per P and seen before they
+	// are flushed.
+	const small = 20000
+	hugeSink = make([]byte, small)
+	hugeSink = nil
+	if got := MaxAllocSeen(); got < small {
+		t.Errorf("MaxAllocSeen() = %d after allocating %d bytes", got, small)
+	}
+
+	// Sizes are recorded as requested, not as rounded up to cache
+	// lines.
+	ResetMaxAllocSeen()
+	p := AllocHotMutable(100, nil)
+	if got, rounded := MaxAllocSeen(), ObjectSize(p); got >= rounded {
+		t.Errorf("MaxAllocSeen() = %d after allocating 100 bytes in a %d-byte block", got, rounded)
+	}
+}
+
+func TestTrimFreeLists(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		hugeSink = make([]byte, 8+i%2000)
+	}
+	hugeSink = nil
+	TrimFreeLists()
+	// Allocation must pick up where the caches were emptied.
+	var live []*[64]byte
+	for i := 0; i < 1000; i++ {
+		p := new([64]byte)
+		p[0] = byte(i)
+		live = append(live, p)
+	}
+	TrimFreeLists()
+	GC()
+	for i, p := range live {
+		if p[0] != byte(i) {
+			t.Fatalf("object %d corrupted after TrimFreeLists", i)
+		}
+	}
+}
+
+var allocLabelSink []byte
+
+func TestAllocLabel(t *testing.T) {
+	const label = 0x5ca1ab1e
+	before := AllocByLabel()[label]
+	old := SetAllocLabel(label)
+	done := make(chan bool)
+	// The new goroutine inherits the label.
+	go func() {
+		for i := 0; i < 100; i++ {
+			allocLabelSink = make([]byte, 1000)
+		}
+		done <- true
+	}()
+	if got := SetAllocLabel(old); got != label {
+		t.Errorf("SetAllocLabel returned %#x, want %#x", got, label)
+	}
+	<-done
+	if got, want := AllocByLabel()[label]-before, uint64(100*1024); got < want {
+		t.Errorf("%d bytes allocated under label, want at least %d", got, want)
+	}
+}
+
+func TestWithAllocDebugName(t *testing.T) {
+	const outer, inner = "runtime_test outer", "runtime_test inner"
+	before := AllocByDebugName()
+	old := SetAllocLabel(0)
+	defer SetAllocLabel(old)
+	WithAllocDebugName(outer, func() {
+		for i := 0; i < 10; i++ {
+			allocLabelSink = make([]byte, 1000)
+		}
+		WithAllocDebugName(inner, func() {
+			allocLabelSink = make([]byte, 4000)
+		})
+	})
+	if l := SetAllocLabel(0); l != 0 {
+		t.Errorf("label %#x left set after WithAllocDebugName", l)
+	}
+	after := AllocByDebugName()
+	if got := after[outer] - before[outer]; got < 10*1024 || got >= 10*1024+4096 {
+		t.Errorf("%d bytes allocated under %q, want 10240", got, outer)
+	}
+	if got := after[inner] - before[inner]; got != 4096 {
+		t.Errorf("%d bytes allocated under %q, want 4096", got, inner)
+	}
+}
+
+func TestNamedPool(t *testing.T) {
+	const name = "runtime_test.TestNamedPool"
+	pool := NewNamedPool(name)
+	if pool.Name() != name {
+		t.Fatalf("Name() = %q, want %q", pool.Name(), name)
+	}
+	var live []unsafe.Pointer
+	for i := 0; i < 100; i++ {
+		// 8-byte pointer-free objects come from the tiny allocator.
+		live = append(live, pool.Alloc(8, nil))
+		live = append(live, pool.Alloc(64, (*[8]*int)(nil)))
+	}
+	if got, want := PoolLiveStats()[name], (PoolStat{200, 100 * (8 + 64)}); got != want {
+		t.Fatalf("PoolLiveStats()[%q] = %+v, want %+v", name, got, want)
+	}
+	GC()
+	if got, want := PoolLiveStats()[name], (PoolStat{200, 100 * (8 + 64)}); got != want {
+		t.Fatalf("after GC, PoolLiveStats()[%q] = %+v, want %+v", name, got, want)
+	}
+	KeepAlive(live)
+	live = nil
+	GC()
+	if got := PoolLiveStats()[name]; got != (PoolStat{}) {
+		t.Fatalf("after freeing all objects, PoolLiveStats()[%q] = %+v, want zero", name, got)
 	}
 }
 
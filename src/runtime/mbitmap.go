@@ -63,6 +63,8 @@
 // checkmark. However, because non-pointer allocations are combined
 // into larger 16-byte (maxTinySize) allocations, a plain 8-byte allocation
 // must be a pointer, so the type bit in the first word is not actually needed.
+// (Low-fragmentation mode, which allocates 8-byte non-pointer blocks, cannot
+// be enabled in checkmark mode.)
 // It is still used in general, except in checkmark the type bit is repurposed
 // as the checkmark bit and then reinitialized (to 1) as the type bit when
 // finished.
@@ -611,6 +613,15 @@ func heapBitsBulkBarrier(p, size uintptr) {
 		return
 	}
 
+	// The bitmap of a large object may still be pending. Skipping
+	// the barriers in that case would race with the GC, which may
+	// claim the object and scan its old contents after the check,
+	// so write the bitmap first.
+	s := spanOfUnchecked(p)
+	if atomic.Load(&s.lazybits) != 0 {
+		s.ensureHeapBits()
+	}
+
 	h := heapBitsForAddr(p)
 	for i := uintptr(0); i < size; i += sys.PtrSize {
 		if h.isPointer() {
@@ -720,6 +731,7 @@ func (h heapBits) initSpan(s *mspan) {
 	s.gcmarkBits = nil
 	s.gcmarkBits = newMarkBits(s.nelems)
 	s.allocBits = newAllocBits(s.nelems)
+	s.lazybits = 0
 
 	// Clear bits corresponding to objects.
 	if total%heapBitmapScale != 0 {
@@ -852,6 +864,10 @@ func (s *mspan) countFree() int {
 // and the bitmap for a span always falls on byte boundaries,
 // so there are no write-write races for access to the heap bitmap.
 // Hence, heapBitsSetType can access the bitmap without atomics.
+// The exception is RegisterTypedRegion, which may write the bitmap of
+// a small object while a neighboring object is being allocated; once
+// it has been used on a small object, smallTypedRegions is set and
+// bitmap bytes shared with other objects are updated atomically.
 //
 // There can be read-write races between heapBitsSetType and things
 // that read the heap bitmap like scanobject. However, since
@@ -870,10 +886,11 @@ func heapBitsSetType(x, size, dataSize uintptr, typ *_type) {
 	// dataSize is always size rounded up to the next malloc size class,
 	// except in the case of allocating a defer block, in which case
 	// size is sizeof(_defer{}) (at least 6 words) and dataSize may be
-	// arbitrarily larger.
+	// arbitrarily larger, and of allocating a typed prefix for AllocSSO,
+	// in which case size is typ.size.
 	//
-	// The checks for size == sys.PtrSize and size == 2*sys.PtrSize can therefore
-	// assume that dataSize == size without checking it explicitly.
+	// The check for size == sys.PtrSize can therefore assume that
+	// dataSize == size without checking it explicitly.
 
 	if sys.PtrSize == 8 && size == sys.PtrSize {
 		// It's one word and it has pointers, it must be a pointer.
@@ -883,7 +900,14 @@ func heapBitsSetType(x, size, dataSize uintptr, typ *_type) {
 		// in addition to the one being allocated.
 		// However, since all allocated one-word objects are pointers
 		// (non-pointers are aggregated into tinySize allocations),
-		// initSpan sets the pointer bits for us. Nothing to do here.
+		// initSpan sets the pointer bits for us. Nothing to do here,
+		// unless low-fragmentation mode or SetTinyAlloc has let
+		// one-word non-pointers clear the bits of this slot; see
+		// oneWordNoScan.
+		if oneWordNoScan != 0 {
+			h := heapBitsForAddr(x)
+			atomic.Or8(h.bitp, (bitPointer|bitMarked)<<h.shift)
+		}
 		if doubleCheck {
 			h := heapBitsForAddr(x)
 			if !h.isPointer() {
@@ -908,16 +932,17 @@ func heapBitsSetType(x, size, dataSize uintptr, typ *_type) {
 		if typ.size == sys.PtrSize {
 			// We're allocating a block big enough to hold two pointers.
 			// On 64-bit, that means the actual object must be two pointers,
-			// or else we'd have used the one-pointer-sized block.
+			// or else we'd have used the one-pointer-sized block, unless
+			// it is one pointer followed by the inline buffer of AllocSSO.
 			// On 32-bit, however, this is the 8-byte block, the smallest one.
 			// So it could be that we're allocating one pointer and this was
 			// just the smallest block available. Distinguish by checking dataSize.
 			// (In general the number of instances of typ being allocated is
 			// dataSize/typ.size.)
-			if sys.PtrSize == 4 && dataSize == sys.PtrSize {
-				// 1 pointer object. On 32-bit machines clear the bit for the
-				// unused second word.
-				if gcphase == _GCoff {
+			if dataSize == sys.PtrSize {
+				// 1 pointer object. Clear the bit for the unused
+				// second word.
+				if gcphase == _GCoff && smallTypedRegions == 0 {
 					*h.bitp &^= (bitPointer | bitMarked | ((bitPointer | bitMarked) << heapBitsShift)) << h.shift
 					*h.bitp |= (bitPointer | bitMarked) << h.shift
 				} else {
@@ -926,7 +951,7 @@ func heapBitsSetType(x, size, dataSize uintptr, typ *_type) {
 				}
 			} else {
 				// 2-element slice of pointer.
-				if gcphase == _GCoff {
+				if gcphase == _GCoff && smallTypedRegions == 0 {
 					*h.bitp |= (bitPointer | bitMarked | bitPointer<<heapBitsShift) << h.shift
 				} else {
 					atomic.Or8(h.bitp, (bitPointer|bitMarked|bitPointer<<heapBitsShift)<<h.shift)
@@ -944,7 +969,7 @@ func heapBitsSetType(x, size, dataSize uintptr, typ *_type) {
 		}
 		b := uint32(*ptrmask)
 		hb := (b & 3) | bitMarked
-		if gcphase == _GCoff {
+		if gcphase == _GCoff && smallTypedRegions == 0 {
 			// bitPointer == 1, bitMarked is 1 << 4, heapBitsShift is 1.
 			// 110011 is shifted h.shift and complemented.
 			// This clears out the bits that are about to be
@@ -1179,7 +1204,7 @@ func heapBitsSetType(x, size, dataSize uintptr, typ *_type) {
 		nb -= 2
 		// Note: no bitMarker for second word because that's
 		// the checkmark.
-		if gcphase == _GCoff {
+		if gcphase == _GCoff && smallTypedRegions == 0 {
 			*hbitp &^= uint8((bitPointer | bitMarked | (bitPointer << heapBitsShift)) << (2 * heapBitsShift))
 			*hbitp |= uint8(hb)
 		} else {
@@ -1301,7 +1326,7 @@ Phase3:
 	// But if w == nw+2, we need to write first two in hb.
 	// The byte is shared with the next object so we may need an atomic.
 	if w == nw+2 {
-		if gcphase == _GCoff {
+		if gcphase == _GCoff && smallTypedRegions == 0 {
 			*hbitp = *hbitp&^(bitPointer|bitMarked|(bitPointer|bitMarked)<<heapBitsShift) | uint8(hb)
 		} else {
 			atomic.And8(hbitp, ^uint8(bitPointer|bitMarked|(bitPointer|bitMarked)<<heapBitsShift))
@@ -1373,11 +1398,43 @@ Phase4:
 	}
 }
 
+const (
+	lazyBitsPending = 1 // the bitmap has not been written
+	lazyBitsWriting = 2 // ensureHeapBits is writing the bitmap
+)
+
+// ensureHeapBits writes the heap bitmap of the large object in s if
+// it was allocated with flagLazyBitmap and the bitmap has not been
+// written yet. Code that reads the heap bits of an object that may be
+// such an object must call ensureHeapBits first. Concurrent callers
+// wait for the one that writes the bitmap.
+//go:nowritebarrier
+func (s *mspan) ensureHeapBits() {
+	for {
+		switch atomic.Load(&s.lazybits) {
+		case 0:
+			return
+		case lazyBitsPending:
+			if atomic.Cas(&s.lazybits, lazyBitsPending, lazyBitsWriting) {
+				heapBitsSetType(s.base(), s.elemsize, s.lazysize, s.lazytype)
+				atomic.Store(&s.lazybits, 0)
+				return
+			}
+		default:
+			procyield(10)
+		}
+	}
+}
+
 // heapBitsSetTypeNoScan marks x as noscan by setting the first word
 // of x in the heap bitmap to scalar/dead.
 func heapBitsSetTypeNoScan(x uintptr) {
 	h := heapBitsForAddr(uintptr(x))
-	*h.bitp &^= (bitPointer | bitMarked) << h.shift
+	if smallTypedRegions == 0 {
+		*h.bitp &^= (bitPointer | bitMarked) << h.shift
+	} else {
+		atomic.And8(h.bitp, ^uint8((bitPointer|bitMarked)<<h.shift))
+	}
 }
 
 var debugPtrmask struct {
@@ -1891,7 +1948,9 @@ func getgcmask(ep interface{}) (mask []byte) {
 	// heap
 	var n uintptr
 	var base uintptr
-	if mlookup(uintptr(p), &base, &n, nil) != 0 {
+	var s *mspan
+	if mlookup(uintptr(p), &base, &n, &s) != 0 {
+		s.ensureHeapBits()
 		mask = make([]byte, n/sys.PtrSize)
 		for i := uintptr(0); i < n; i += sys.PtrSize {
 			hbits := heapBitsForAddr(base + i)
@@ -14,8 +14,12 @@ import "unsafe"
 type mcache struct {
 	// The following members are accessed on every malloc,
 	// so they are grouped here for better caching.
-	next_sample int32   // trigger heap sample after allocating this many bytes
-	local_scan  uintptr // bytes of scannable heap allocated
+	next_sample      int32   // trigger heap sample after allocating this many bytes
+	local_scan       uintptr // bytes of scannable heap allocated
+	local_cachealloc uintptr // bytes allocated from cache by this P; never flushed
+	avgsize          uintptr // moving average of allocation sizes, scaled by 1<<avgAllocSizeShift; see AvgAllocSize
+	avgsample        uint32  // allocations since avgsize was last updated
+	local_maxalloc   uintptr // largest size allocated since last flushed to maxAllocSeen
 
 	// Allocator cache for tiny objects w/o pointers.
 	// See "Tiny allocator" comment in malloc.go.
@@ -29,9 +33,11 @@ type mcache struct {
 	tiny             uintptr
 	tinyoffset       uintptr
 	local_tinyallocs uintptr // number of tiny allocs not counted in other stats
+	local_nalloc     uintptr // number of small allocs not counted in other stats, other than tiny allocs
 
 	// The rest is not accessed on every malloc.
-	alloc [_NumSizeClasses]*mspan // spans to allocate from
+	alloc     [_NumSizeClasses]*mspan // spans to allocate from
+	coldalloc [_NumSizeClasses]*mspan // spans to allocate cold objects from; see AllocCold
 
 	stackcache [_NumStackOrders]stackfreelist
 
@@ -40,6 +46,21 @@ type mcache struct {
 	local_largefree  uintptr                  // bytes freed for large objects (>maxsmallsize)
 	local_nlargefree uintptr                  // number of frees for large objects (>maxsmallsize)
 	local_nsmallfree [_NumSizeClasses]uintptr // number of frees for small objects (<=maxsmallsize)
+	local_nrefill    uintptr                  // number of span refills
+	local_nlarge     uintptr                  // number of allocations of large objects (>maxsmallsize)
+	local_ntrigcheck uintptr                  // number of GC trigger checks; see GCTriggerStats
+	local_ntrigfire  uintptr                  // number of GC trigger checks that fired
+
+	// Number of allocations in each size class, 0 being large
+	// objects, for AllocRatePerClass. Flushed with the stats above.
+	local_nclassalloc [_NumSizeClasses]uintptr
+
+	// Number of allocations in each bucket of the allocation size
+	// histogram, for AllocSizeHistogram. Flushed with the stats above.
+	local_sizehist [allocSizeHistBuckets]uintptr
+
+	tscsample uint32 // fast-path allocations since the last GODEBUG=malloctsc sample
+	evsample  uint32 // allocations since the last AllocEventChannel event
 }
 
 // A gclink is a node in a linked list of blocks, like mlink,
@@ -78,6 +99,7 @@ func allocmcache() *mcache {
 	memclr(unsafe.Pointer(c), unsafe.Sizeof(*c))
 	for i := 0; i < _NumSizeClasses; i++ {
 		c.alloc[i] = &emptymspan
+		c.coldalloc[i] = &emptymspan
 	}
 	c.next_sample = nextSample()
 	return c
@@ -101,13 +123,18 @@ func freemcache(c *mcache) {
 }
 
 // Gets a span that has a free object in it and assigns it
-// to be the cached span for the given sizeclass. Returns this span.
-func (c *mcache) refill(sizeclass int32) *mspan {
+// to be the cached span for the given sizeclass, for cold objects
+// if cold is set. Returns this span.
+func (c *mcache) refill(sizeclass int32, cold bool) *mspan {
 	_g_ := getg()
 
 	_g_.m.locks++
+	alloc := &c.alloc
+	if cold {
+		alloc = &c.coldalloc
+	}
 	// Return the current cached span to the central lists.
-	s := c.alloc[sizeclass]
+	s := alloc[sizeclass]
 
 	if uintptr(s.allocCount) != s.nelems {
 		throw("refill of span with free space remaining")
@@ -118,7 +145,7 @@ func (c *mcache) refill(sizeclass int32) *mspan {
 	}
 
 	// Get a new cached span from the central lists.
-	s = mheap_.central[sizeclass].mcentral.cacheSpan()
+	s = mheap_.centralFor(sizeclass, cold).cacheSpan()
 	if s == nil {
 		throw("out of memory")
 	}
@@ -127,11 +154,31 @@ func (c *mcache) refill(sizeclass int32) *mspan {
 		throw("span has no free space")
 	}
 
-	c.alloc[sizeclass] = s
+	alloc[sizeclass] = s
 	_g_.m.locks--
 	return s
 }
 
+// cacheSpanNear makes s, a span of small objects that are not cold, the
+// span c allocates from for its size class, returning the span c was
+// using to the central lists. It reports whether it could; it cannot if
+// s is full, unswept, or cached by another mcache.
+func (c *mcache) cacheSpanNear(s *mspan) bool {
+	_g_ := getg()
+
+	_g_.m.locks++
+	central := &mheap_.central[s.sizeclass].mcentral
+	ok := central.cacheThisSpan(s)
+	if ok {
+		if old := c.alloc[s.sizeclass]; old != &emptymspan {
+			central.uncacheSpan(old)
+		}
+		c.alloc[s.sizeclass] = s
+	}
+	_g_.m.locks--
+	return ok
+}
+
 func (c *mcache) releaseAll() {
 	for i := 0; i < _NumSizeClasses; i++ {
 		s := c.alloc[i]
@@ -139,8 +186,30 @@ func (c *mcache) releaseAll() {
 			mheap_.central[i].mcentral.uncacheSpan(s)
 			c.alloc[i] = &emptymspan
 		}
+		s = c.coldalloc[i]
+		if s != &emptymspan {
+			mheap_.coldcentral[i].mcentral.uncacheSpan(s)
+			c.coldalloc[i] = &emptymspan
+		}
 	}
 	// Clear tinyalloc pool.
 	c.tiny = 0
 	c.tinyoffset = 0
 }
+
+// TrimFreeLists returns the free memory cached by each P to the
+// shared free lists: the unallocated objects in the spans each P is
+// allocating from, for every size class, and the P's cache of free
+// goroutine stacks. After a burst of allocation these caches can hold
+// a lot of memory that the Ps that allocated it may not need again
+// soon. Once returned, the memory can be used by any P, and spans
+// left with no live objects are freed at the next garbage collection,
+// after which FreeOSMemory can return them to the operating system.
+//
+// TrimFreeLists stops the world while it runs. Later allocations
+// refill the caches as usual.
+func TrimFreeLists() {
+	stopTheWorld("trim free lists")
+	systemstack(flushallmcaches)
+	startTheWorld()
+}
@@ -18,13 +18,15 @@ import "runtime/internal/atomic"
 type mcentral struct {
 	lock      mutex
 	sizeclass int32
+	cold      bool      // holds spans of cold objects; see AllocCold
 	nonempty  mSpanList // list of spans with a free object, ie a nonempty free list
 	empty     mSpanList // list of spans with no free objects (or cached in an mcache)
 }
 
 // Initialize a single central free list.
-func (c *mcentral) init(sizeclass int32) {
+func (c *mcentral) init(sizeclass int32, cold bool) {
 	c.sizeclass = sizeclass
+	c.cold = cold
 	c.nonempty.init()
 	c.empty.init()
 }
@@ -35,7 +37,11 @@ func (c *mcentral) cacheSpan() *mspan {
 	spanBytes := uintptr(class_to_allocnpages[c.sizeclass]) * _PageSize
 	deductSweepCredit(spanBytes, 0)
 
-	lock(&c.lock)
+	if !trylock(&c.lock) {
+		// Another P holds this size class's lock.
+		atomic.Xadd64(&memstats.central_contended, 1)
+		lock(&c.lock)
+	}
 	sg := mheap_.sweepgen
 retry:
 	var s *mspan
@@ -99,6 +105,33 @@ retry:
 	// At this point s is a non-empty span, queued at the end of the empty list,
 	// c is unlocked.
 havespan:
+	c.startCaching(s, spanBytes)
+	return s
+}
+
+// cacheThisSpan is like cacheSpan, but only for s, which must hold
+// objects of c's size class. It succeeds, reporting true, only if s is
+// swept, has free objects, and is not cached by any mcache.
+func (c *mcentral) cacheThisSpan(s *mspan) bool {
+	spanBytes := uintptr(class_to_allocnpages[c.sizeclass]) * _PageSize
+	deductSweepCredit(spanBytes, 0)
+
+	lock(&c.lock)
+	if s.list != &c.nonempty || s.sweepgen != mheap_.sweepgen {
+		unlock(&c.lock)
+		return false
+	}
+	c.nonempty.remove(s)
+	c.empty.insertBack(s)
+	unlock(&c.lock)
+	c.startCaching(s, spanBytes)
+	return true
+}
+
+// startCaching accounts for the free objects of the span s, of
+// spanBytes bytes, which has just been moved to the end of c's empty
+// list to be cached, and prepares s for allocation.
+func (c *mcentral) startCaching(s *mspan, spanBytes uintptr) {
 	cap := int32((s.npages << _PageShift) / s.elemsize)
 	n := cap - int32(s.allocCount)
 	if n == 0 || s.freeindex == s.nelems || uintptr(s.allocCount) == s.nelems {
@@ -126,8 +159,6 @@ havespan:
 	// Adjust the allocCache so that s.freeindex corresponds to the low bit in
 	// s.allocCache.
 	s.allocCache >>= s.freeindex % 64
-
-	return s
 }
 
 // Return span from an MCache.
@@ -214,6 +245,7 @@ func (c *mcentral) grow() *mspan {
 
 	p := s.base()
 	s.limit = p + size*n
+	s.cold = c.cold
 
 	heapBitsForSpan(s.base()).initSpan(s)
 	return s
@@ -0,0 +1,21 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build amd64,!plan9
+
+package runtime
+
+import "unsafe"
+
+// nonTemporalClear reports whether memclrNoTemporal uses non-temporal
+// stores on this platform.
+const nonTemporalClear = true
+
+// memclrNoTemporal clears n bytes starting at ptr, like memclr, but with
+// non-temporal stores that bypass the cache, so that clearing a large
+// block does not evict data in use. ptr must be 16-byte aligned and n a
+// multiple of 64.
+// in memclr_nontemporal_amd64.s
+//go:noescape
+func memclrNoTemporal(ptr unsafe.Pointer, n uintptr)
@@ -0,0 +1,31 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !plan9
+
+#include "textflag.h"
+
+// void runtime·memclrNoTemporal(void*, uintptr)
+// ptr must be 16-byte aligned and n a multiple of 64.
+TEXT runtime·memclrNoTemporal(SB), NOSPLIT, $0-16
+	MOVQ	ptr+0(FP), DI
+	MOVQ	n+8(FP), BX
+	PXOR	X0, X0
+	TESTQ	BX, BX
+	JEQ	done
+loop:
+	// MOVNTDQ writes around the cache. SSE2 is always
+	// available on amd64, so no feature check is needed.
+	MOVNTO	X0, 0(DI)
+	MOVNTO	X0, 16(DI)
+	MOVNTO	X0, 32(DI)
+	MOVNTO	X0, 48(DI)
+	ADDQ	$64, DI
+	SUBQ	$64, BX
+	JNE	loop
+	// Order the streaming stores before any later stores,
+	// as for VMOVNTDQ in memclr.
+	SFENCE
+done:
+	RET
@@ -0,0 +1,19 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !amd64 plan9
+
+package runtime
+
+import "unsafe"
+
+// nonTemporalClear reports whether memclrNoTemporal uses non-temporal
+// stores on this platform.
+const nonTemporalClear = false
+
+// memclrNoTemporal clears n bytes starting at ptr. This platform has no
+// non-temporal clear, so it is memclr.
+func memclrNoTemporal(ptr unsafe.Pointer, n uintptr) {
+	memclr(ptr, n)
+}
@@ -33,11 +33,20 @@ var allfin *finblock // list of all blocks
 type finalizer struct {
 	fn   *funcval       // function to call
 	arg  unsafe.Pointer // ptr to object
-	nret uintptr        // bytes of return values from fn
+	nret uintptr        // bytes of return values from fn, plus finFlags
 	fint *_type         // type of first argument of fn
 	ot   *ptrtype       // type of ptr to object
 }
 
+// Flags kept in the high bits of the nret of a finalizer, which leaves
+// the finalizer layout unchanged.
+const (
+	finNoAlloc  uintptr = 1 << (8*sys.PtrSize - 1) // set by SetFinalizerNoAlloc
+	finSnapshot uintptr = 1 << (8*sys.PtrSize - 2) // set by SetSnapshotFinalizer
+
+	finFlags = finNoAlloc | finSnapshot
+)
+
 var finalizer1 = [...]byte{
 	// Each Finalizer is 5 words, ptr ptr INT ptr ptr (INT = uintptr here)
 	// Each byte describes 8 words.
@@ -66,6 +75,35 @@ var finalizer1 = [...]byte{
 	0<<0 | 1<<1 | 1<<2 | 1<<3 | 1<<4 | 0<<5 | 1<<6 | 1<<7,
 }
 
+// dequeuefinalizer removes the finalizer queued for the object at p
+// from finq, storing it in *f, and reports whether there was one.
+func dequeuefinalizer(p unsafe.Pointer, f *finalizer) bool {
+	lock(&finlock)
+	for fb := finq; fb != nil; fb = fb.next {
+		for i := int32(0); i < fb.cnt; i++ {
+			if fb.fin[i].arg != p {
+				continue
+			}
+			*f = fb.fin[i]
+			// Fill the hole with the most recently queued
+			// finalizer, at the top of finq.
+			fb.fin[i] = finq.fin[finq.cnt-1]
+			finq.fin[finq.cnt-1] = finalizer{}
+			finq.cnt--
+			if finq.cnt == 0 {
+				b := finq
+				finq = b.next
+				b.next = finc
+				finc = b
+			}
+			unlock(&finlock)
+			return true
+		}
+	}
+	unlock(&finlock)
+	return false
+}
+
 func queuefinalizer(p unsafe.Pointer, fn *funcval, nret uintptr, fint *_type, ot *ptrtype) {
 	lock(&finlock)
 	if finq == nil || finq.cnt == int32(len(finq.fin)) {
@@ -104,6 +142,7 @@ func queuefinalizer(p unsafe.Pointer, fn *funcval, nret uintptr, fint *_type, ot
 	f.arg = p
 	fingwake = true
 	unlock(&finlock)
+	atomic.Xadd64(&finstats.queued, 1)
 }
 
 //go:nowritebarrier
@@ -111,7 +150,7 @@ func iterate_finq(callback func(*funcval, unsafe.Pointer, uintptr, *_type, *ptrt
 	for fb := allfin; fb != nil; fb = fb.alllink {
 		for i := int32(0); i < fb.cnt; i++ {
 			f := &fb.fin[i]
-			callback(f.fn, f.arg, f.nret, f.fint, f.ot)
+			callback(f.fn, f.arg, f.nret&^finFlags, f.fint, f.ot)
 		}
 	}
 }
@@ -133,6 +172,39 @@ var (
 	fingRunning bool
 )
 
+// finconcurrency is the maximum number of goroutines that run
+// finalizers at once, counting fing. finhelpers is the number of
+// helper goroutines currently running alongside fing. Both are
+// protected by finlock.
+var (
+	finconcurrency int32 = 1
+	finhelpers     int32
+)
+
+// finbatch is the number of finalizers a finalizer goroutine takes off
+// finq at a time. It is protected by finlock.
+var finbatch int32 = 1
+
+// finstats counts finalizers through their life for FinalizerStats.
+// Its fields are accessed atomically.
+var finstats struct {
+	registered uint64
+	queued     uint64
+	run        uint64
+	runNanos   uint64
+}
+
+// finalizerRan records that a finalizer that started at start has
+// finished running.
+func finalizerRan(start int64) {
+	atomic.Xadd64(&finstats.runNanos, nanotime()-start)
+	atomic.Xadd64(&finstats.run, 1)
+}
+
+// resurrectionAudit is the function set by SetResurrectionAudit.
+// It is protected by finlock.
+var resurrectionAudit func(typ string)
+
 func createfing() {
 	// start the finalizer goroutine exactly once
 	if fingCreate == 0 && atomic.Cas(&fingCreate, 0, 1) {
@@ -142,78 +214,249 @@ func createfing() {
 
 // This is the goroutine that runs all of the finalizers
 func runfinq() {
+	finworker(true)
+}
+
+// runfinqhelper runs finalizers alongside fing when the finalizer
+// concurrency is above 1. Unlike fing, it exits as soon as the
+// finalizer queue is empty.
+func runfinqhelper() {
+	finworker(false)
+}
+
+// finworker takes finalizers off finq, up to finbatch at a time, and
+// runs them. primary is true for fing, which parks when the queue is
+// empty.
+//
+// Any finalizers in the queue may run in parallel: if A points at B
+// and both have finalizers, the garbage collector queues only A's
+// finalizer, and B's is not queued until a later cycle finds B
+// unreachable once A has been freed.
+func finworker(primary bool) {
 	var (
 		frame    unsafe.Pointer
 		framecap uintptr
+		batch    []finalizer // finalizers taken off finq, from next on not yet run
+		next     int
+		audit    func(typ string)
 	)
 
 	for {
-		lock(&finlock)
-		fb := finq
-		finq = nil
-		if fb == nil {
-			gp := getg()
-			fing = gp
-			fingwait = true
-			goparkunlock(&finlock, "finalizer wait", traceEvGoBlock, 1)
-			continue
-		}
-		unlock(&finlock)
-		if raceenabled {
-			racefingo()
-		}
-		for fb != nil {
-			for i := fb.cnt; i > 0; i-- {
-				f := &fb.fin[i-1]
-
-				framesz := unsafe.Sizeof((interface{})(nil)) + f.nret
-				if framecap < framesz {
-					// The frame does not contain pointers interesting for GC,
-					// all not yet finalized objects are stored in finq.
-					// If we do not mark it as FlagNoScan,
-					// the last finalized object is not collected.
-					frame = mallocgc(framesz, nil, true)
-					framecap = framesz
-				}
-
-				if f.fint == nil {
-					throw("missing type in runfinq")
+		if next == len(batch) {
+			lock(&finlock)
+			fb := finq
+			if fb == nil {
+				if !primary {
+					finhelpers--
+					unlock(&finlock)
+					return
 				}
-				switch f.fint.kind & kindMask {
-				case kindPtr:
-					// direct use of pointer
-					*(*unsafe.Pointer)(frame) = f.arg
-				case kindInterface:
-					ityp := (*interfacetype)(unsafe.Pointer(f.fint))
-					// set up with empty interface
-					(*eface)(frame)._type = &f.ot.typ
-					(*eface)(frame).data = f.arg
-					if len(ityp.mhdr) != 0 {
-						// convert to interface with methods
-						// this conversion is guaranteed to succeed - we checked in SetFinalizer
-						assertE2I(ityp, *(*eface)(frame), (*iface)(frame))
-					}
-				default:
-					throw("bad kind in runfinq")
+				gp := getg()
+				fing = gp
+				fingwait = true
+				goparkunlock(&finlock, "finalizer wait", traceEvGoBlock, 1)
+				continue
+			}
+			if n := finbatch; cap(batch) < int(n) {
+				// Don't allocate with finlock held.
+				unlock(&finlock)
+				batch, next = make([]finalizer, 0, n), 0
+				continue
+			}
+			// Move the most recently queued finalizers to batch and
+			// drop the finalizer queue references to the objects.
+			batch, next = batch[:0], 0
+			for ; fb != nil && len(batch) < int(finbatch); fb = finq {
+				batch = append(batch, fb.fin[fb.cnt-1])
+				fb.fin[fb.cnt-1] = finalizer{}
+				fb.cnt--
+				if fb.cnt == 0 {
+					finq = fb.next
+					fb.next = finc
+					finc = fb
 				}
-				fingRunning = true
-				reflectcall(nil, unsafe.Pointer(f.fn), frame, uint32(framesz), uint32(framesz))
-				fingRunning = false
-
-				// drop finalizer queue references to finalized object
-				f.fn = nil
-				f.arg = nil
-				f.ot = nil
-				fb.cnt = i - 1
 			}
-			next := fb.next
-			lock(&finlock)
-			fb.next = finc
-			finc = fb
+			audit = resurrectionAudit
+			spawn := finq != nil && finhelpers < finconcurrency-1
+			if spawn {
+				finhelpers++
+			}
 			unlock(&finlock)
-			fb = next
+			if spawn {
+				go runfinqhelper()
+			}
+			if raceenabled {
+				racefingo()
+			}
+		}
+		// Move the next finalizer to this stack.
+		f := batch[next]
+		batch[next] = finalizer{}
+		next++
+
+		// Call audit while f still holds the object, before the
+		// object is only in frame, which the GC does not scan.
+		if audit != nil {
+			audit(f.ot.elem.string())
+		}
+
+		noalloc := f.nret&finNoAlloc != 0
+		framesz := unsafe.Sizeof((interface{})(nil)) + f.nret&^finFlags
+		if framecap < framesz {
+			// The frame does not contain pointers interesting for GC,
+			// the object being finalized is held by f.
+			// If we do not mark it as FlagNoScan,
+			// the last finalized object is not collected.
+			frame = mallocgc(framesz, nil, 0)
+			framecap = framesz
+		}
+
+		finalizerArgs(frame, &f)
+		if primary {
+			fingRunning = true
 		}
+		gp := getg()
+		gp.noalloc = noalloc
+		start := nanotime()
+		reflectcall(nil, unsafe.Pointer(f.fn), frame, uint32(framesz), uint32(framesz))
+		finalizerRan(start)
+		gp.noalloc = false
+		if primary {
+			fingRunning = false
+		}
+	}
+}
+
+// finalizerArgs writes the argument of the finalizer f, the object
+// being finalized converted to the type of the finalizer's parameter,
+// to frame.
+func finalizerArgs(frame unsafe.Pointer, f *finalizer) {
+	if f.fint == nil {
+		throw("missing type in runfinq")
+	}
+	switch f.fint.kind & kindMask {
+	case kindPtr:
+		// direct use of pointer
+		*(*unsafe.Pointer)(frame) = f.arg
+	case kindInterface:
+		ityp := (*interfacetype)(unsafe.Pointer(f.fint))
+		// set up with empty interface
+		(*eface)(frame)._type = &f.ot.typ
+		(*eface)(frame).data = f.arg
+		if f.nret&finSnapshot != 0 {
+			(*eface)(frame)._type = f.ot.elem
+			(*eface)(frame).data = finalizerSnapshot(f.ot.elem, f.arg)
+		}
+		if len(ityp.mhdr) != 0 {
+			// convert to interface with methods
+			// this conversion is guaranteed to succeed - we checked in SetFinalizer
+			assertE2I(ityp, *(*eface)(frame), (*iface)(frame))
+		}
+	default:
+		throw("bad kind in runfinq")
+	}
+}
+
+// finalizerSnapshot returns the data word of an interface holding a
+// copy of the object of type typ at p, for SetSnapshotFinalizer.
+func finalizerSnapshot(typ *_type, p unsafe.Pointer) unsafe.Pointer {
+	if isDirectIface(typ) {
+		return *(*unsafe.Pointer)(p)
+	}
+	x := newobject(typ)
+	typedmemmove(typ, x, p)
+	return x
+}
+
+// SetFinalizerConcurrency sets the maximum number of goroutines that
+// run finalizers at the same time and returns the previous setting.
+// A call with n < 1 does not change the setting. The initial setting
+// is 1: a single goroutine runs all finalizers, sequentially.
+//
+// With n > 1, finalizers queued by the garbage collector are spread
+// over up to n goroutines, and finalizers for unrelated objects may run
+// in parallel. Dependency order is still respected: if A points at B,
+// the finalizer for B does not run until A has been finalized and freed.
+func SetFinalizerConcurrency(n int) int {
+	lock(&finlock)
+	old := int(finconcurrency)
+	if n >= 1 {
+		finconcurrency = int32(n)
+	}
+	unlock(&finlock)
+	return old
+}
+
+// SetFinalizerBatchSize sets the number of queued finalizers that a
+// goroutine running finalizers takes off the queue at a time, and
+// returns the previous setting. A call with n < 1 does not change the
+// setting. The initial setting is 1.
+//
+// Taking finalizers off the queue costs a lock acquisition each time,
+// which can be a noticeable part of the cost of running many cheap
+// finalizers. With a larger batch size, the finalizers of a batch are
+// run one after the other by the goroutine that took them, so they are
+// not shared with the other finalizer goroutines allowed by
+// SetFinalizerConcurrency.
+func SetFinalizerBatchSize(n int) int {
+	lock(&finlock)
+	old := int(finbatch)
+	if n >= 1 {
+		finbatch = int32(n)
+	}
+	unlock(&finlock)
+	return old
+}
+
+// SetResurrectionAudit arranges for fn to be called each time the
+// garbage collector resurrects an object because it has a finalizer,
+// with the name of the object's type, such as "main.T". A nil fn turns
+// auditing off.
+//
+// The collector resurrects an object when it finds the object
+// unreachable but with a finalizer set: instead of freeing it, it keeps
+// the object and everything it points to alive until the finalizer has
+// run, and the memory is not reclaimed before the following cycle at
+// the earliest. To avoid running user code inside the collector, fn is
+// called on the goroutine that runs the finalizer, just before the
+// finalizer itself, so a slow fn delays finalizers.
+func SetResurrectionAudit(fn func(typ string)) {
+	lock(&finlock)
+	resurrectionAudit = fn
+	unlock(&finlock)
+}
+
+// A FinalizerSummary holds counts of finalizers over the life of the
+// program, as returned by FinalizerStats. Survival callbacks set by
+// SetSurvivalCallback are counted as finalizers once they are due.
+type FinalizerSummary struct {
+	Registered uint64 // finalizers set by SetFinalizer and the like
+	Queued     uint64 // finalizers queued to run because their object became unreachable, or run by RunFinalizer
+	Run        uint64 // finalizers that have returned
+	Pending    uint64 // queued finalizers that have not yet returned
+	RunNanos   uint64 // total time spent running finalizers, in nanoseconds
+	AvgNanos   uint64 // average time a finalizer took to run, in nanoseconds
+}
+
+// FinalizerStats returns counts of the finalizers set, queued and run
+// so far, and of the time spent running them. A Pending count that
+// keeps growing means finalizers are queued faster than they run (see
+// SetFinalizerConcurrency), and a high AvgNanos points to finalizers
+// that block. The counts are read without stopping the world, so they
+// may not be consistent with each other if finalizers are running.
+func FinalizerStats() FinalizerSummary {
+	var st FinalizerSummary
+	// Read the completed counts first so that Pending is never
+	// negative.
+	st.Run = atomic.Load64(&finstats.run)
+	st.RunNanos = atomic.Load64(&finstats.runNanos)
+	st.Queued = atomic.Load64(&finstats.queued)
+	st.Registered = atomic.Load64(&finstats.registered)
+	st.Pending = st.Queued - st.Run
+	if st.Run > 0 {
+		st.AvgNanos = st.RunNanos / st.Run
 	}
+	return st
 }
 
 // SetFinalizer sets the finalizer associated with obj to the provided
@@ -227,11 +470,20 @@ func runfinq() {
 // SetFinalizer(obj, nil) clears any finalizer associated with obj.
 //
 // The argument obj must be a pointer to an object allocated by
-// calling new or by taking the address of a composite literal.
+// calling new or by taking the address of a composite literal, or a
+// pointer into such an object, such as the address of one of its fields.
+// In the latter case the finalizer is attached to the enclosing object,
+// as if set on a pointer to its beginning, so it runs once the whole
+// object is unreachable, the object can have only one finalizer, and
+// SetFinalizer(p, nil) clears it for any pointer p into the object. The
+// finalizer is still passed obj itself. Pointer-free objects smaller than
+// 16 bytes, which may share a block with other objects (see mallocgc),
+// are the exception: each pointer into such a block can have a
+// finalizer of its own.
 // The argument finalizer must be a function that takes a single argument
 // to which obj's type can be assigned, and can have arbitrary ignored return
 // values. If either of these is not true, SetFinalizer aborts the
-// program.
+// program, or panics if SetFinalizerStrict(false) has been called.
 //
 // Finalizers are run in dependency order: if A points at B, both have
 // finalizers, and they are otherwise unreachable, only the finalizer
@@ -277,7 +529,8 @@ func runfinq() {
 // To avoid this problem, call runtime.KeepAlive(p) after the call to
 // syscall.Write.
 //
-// A single goroutine runs all finalizers for a program, sequentially.
+// By default a single goroutine runs all finalizers for a program,
+// sequentially; see SetFinalizerConcurrency.
 // If a finalizer must run for a long time, it should do so by starting
 // a new goroutine.
 
@@ -288,9 +541,15 @@ func runfinq() {
 //
 // SetFinalizer(x, nil) 会清理任何与 x 相关联的终结器。
 //
-// 实参 x 必须是一个对象的指针，该对象通过调用新的或获取一个复合字面地址来分配。
+// 实参 x 必须是一个对象的指针，该对象通过调用新的或获取一个复合字面地址来分配，
+// 或者是指向这种对象内部的指针，例如其某个字段的地址。对于后者，终结器会附加到外围对象上，
+// 如同在指向其起始处的指针上设置一样：它会在整个对象无法访问后运行，该对象只能拥有一个终结器，
+// 且对任何指向该对象内部的指针 p 调用 SetFinalizer(p, nil) 都会将其清理。终结器仍以 x 本身作为实参。
+// 小于 16 字节且不含指针的对象可能与其它对象共享内存块（见 mallocgc），因此是例外：
+// 指向这种块的每个指针都可以拥有自己的终结器。
 // 实参 f 必须是一个函数，该函数获取一个 x 的类型的单一实参，并拥有可任意忽略的返回值。
-// 只要这些条件有一个不满足，SetFinalizer 就会跳过该程序。
+// 只要这些条件有一个不满足，SetFinalizer 就会跳过该程序；若已调用
+// SetFinalizerStrict(false)，则会引发恐慌。
 //
 // 终结器按照依赖顺序运行：若 A 指向 B，则二者都有终结器，当只有 A 的终结器运行时，
 // 它们才无法访问；一旦 A 被释放，则 B 的终结器便可运行。若循环依赖的结构包含块及其终结器，
@@ -302,24 +561,240 @@ func runfinq() {
 // 来关闭与其相关联的操作系统文件描述符，但依赖终结器去刷新一个内存中的I/O缓存是错误的，
 // 因为该缓存不会在程序退出时被刷新。
 //
-// 一个程序的单个Go程会按顺序运行所有的终结器。若某个终结器需要长时间运行，
-// 它应当通过开始一个新的Go程来继续。
+// 默认情况下，一个程序的单个Go程会按顺序运行所有的终结器，见 SetFinalizerConcurrency。
+// 若某个终结器需要长时间运行，它应当通过开始一个新的Go程来继续。
 // TODO(osc): 仍需校对及语句优化
 func SetFinalizer(obj interface{}, finalizer interface{}) {
+	setFinalizer("SetFinalizer", obj, finalizer, 0)
+}
+
+// SetFinalizerNoAlloc is like SetFinalizer, but for a finalizer that
+// must not allocate memory from the heap, such as cleanup code that
+// must run reliably while the program is short of memory. If the
+// finalizer allocates, directly or through a function it calls, the
+// program crashes at the allocation with a traceback that shows where
+// it happened, rather than continuing with a finalizer that breaks its
+// own rules. Creating closures, converting to interfaces, appending to
+// slices and using defer may all allocate.
+func SetFinalizerNoAlloc(obj interface{}, finalizer interface{}) {
+	setFinalizer("SetFinalizerNoAlloc", obj, finalizer, finNoAlloc)
+}
+
+// SetSnapshotFinalizer is like SetFinalizer, but the finalizer fn is
+// passed a copy of the object obj points to, as an interface value
+// holding a T if obj is a *T, instead of obj itself. The copy is made
+// when the finalizer is about to run, once the garbage collector has
+// found the object unreachable, so it shows the object's last state,
+// which nothing else can change, and the finalizer cannot resurrect the
+// object by storing the pointer somewhere: the object is freed by the
+// next collection even if fn still runs, or keeps the copy. The copy
+// costs an allocation of the object's size, which is made by the
+// goroutine that runs finalizers and delays the finalizers after it.
+// SetSnapshotFinalizer(obj, nil) clears any finalizer of obj.
+func SetSnapshotFinalizer(obj interface{}, fn func(snapshot interface{})) {
+	var finalizer interface{}
+	if fn != nil {
+		finalizer = fn
+	}
+	setFinalizer("SetSnapshotFinalizer", obj, finalizer, finSnapshot)
+}
+
+// setFinalizer implements SetFinalizer, SetFinalizerNoAlloc and
+// SetSnapshotFinalizer for the function named fn. flags is a
+// combination of finFlags.
+func setFinalizer(fn string, obj interface{}, finalizer interface{}, flags uintptr) {
 	if debug.sbrk != 0 {
 		// debug.sbrk never frees memory, so no finalizers run
 		// (and we don't have the data structures to record them).
 		return
 	}
+	p, off, ot := finalizerTarget(fn, obj)
+	if p == nil {
+		return
+	}
+
+	f := efaceOf(&finalizer)
+	ftyp := f._type
+	if ftyp == nil {
+		// switch to system stack and remove finalizer
+		systemstack(func() {
+			removefinalizer(p)
+		})
+		return
+	}
+
+	fint, nret := finalizerArg(fn, obj, ftyp)
+
+	// make sure we have a finalizer goroutine
+	createfing()
+
+	var ok bool
+	systemstack(func() {
+		ok = addfinalizer(p, off, (*funcval)(f.data), nret|flags, fint, ot)
+	})
+	if !ok {
+		badFinalizer("runtime." + fn + ": finalizer already set")
+	}
+}
+
+// SetFinalizers sets finalizer as the finalizer of each object in objs,
+// as if by calling SetFinalizer(obj, finalizer) for each one, but more
+// cheaply: each object's type is checked against finalizer only if it
+// differs from the type of the previous object, and all the finalizers
+// are recorded in a single switch to the system stack. A nil finalizer
+// clears the finalizers of all the objects.
+//
+// The objects are checked as for SetFinalizer before any finalizer is
+// set, so a bad argument leaves all the objects unchanged. An object
+// that already has a finalizer is reported, as for SetFinalizer, only
+// after the finalizers of the others have been set.
+func SetFinalizers(objs []interface{}, finalizer interface{}) {
+	if debug.sbrk != 0 || len(objs) == 0 {
+		return
+	}
+	ps := make([]unsafe.Pointer, len(objs))
+	offs := make([]uintptr, len(objs))
+	ots := make([]*ptrtype, len(objs))
+	for i, obj := range objs {
+		ps[i], offs[i], ots[i] = finalizerTarget("SetFinalizers", obj)
+	}
+
+	f := efaceOf(&finalizer)
+	ftyp := f._type
+	if ftyp == nil {
+		systemstack(func() {
+			for _, p := range ps {
+				if p != nil {
+					removefinalizer(p)
+				}
+			}
+		})
+		return
+	}
+
+	fints := make([]*_type, len(objs))
+	var nret uintptr
+	var lastType, lastFint *_type
+	for i, obj := range objs {
+		if ps[i] == nil {
+			continue
+		}
+		if etyp := efaceOf(&obj)._type; etyp != lastType {
+			lastFint, nret = finalizerArg("SetFinalizers", obj, ftyp)
+			lastType = etyp
+		}
+		fints[i] = lastFint
+	}
+
+	createfing()
+
+	ok := true
+	systemstack(func() {
+		for i, p := range ps {
+			if p != nil && !addfinalizer(p, offs[i], (*funcval)(f.data), nret, fints[i], ots[i]) {
+				ok = false
+			}
+		}
+	})
+	if !ok {
+		badFinalizer("runtime.SetFinalizers: finalizer already set")
+	}
+}
+
+// HasFinalizer reports whether a finalizer set by SetFinalizer is
+// attached to obj, which must be a pointer. As with SetFinalizer, a
+// finalizer belongs to the object obj points into, so one set on a
+// pointer to a field of an object is also reported for a pointer to the
+// object, and vice versa, except for the pointer-free objects smaller
+// than 16 bytes that may share a block. Once the garbage collector has
+// found the object unreachable and queued its finalizer to run, the
+// finalizer is no longer attached. HasFinalizer reports false for nil
+// and for pointers outside the heap, which never have finalizers.
+func HasFinalizer(obj interface{}) bool {
+	e := efaceOf(&obj)
+	etyp := e._type
+	if etyp == nil {
+		return false
+	}
+	if etyp.kind&kindMask != kindPtr {
+		panic(plainError("runtime.HasFinalizer: argument is " + etyp.string() + ", not pointer"))
+	}
+	if _, base, _ := findObject(e.data); base == nil {
+		return false
+	}
+	return hasspecial(finalizerKey(e.data), _KindSpecialFinalizer)
+}
+
+// RunFinalizer runs the finalizer of obj, which must be a pointer, now,
+// on the calling goroutine, and reports whether there was one to run.
+// The finalizer is removed first, so it never runs a second time, and
+// obj is not freed early: it stays allocated until the garbage
+// collector finds it unreachable, like any object without a finalizer.
+//
+// A finalizer is run if it is attached to obj, as reported by
+// HasFinalizer, or if the garbage collector has queued it but no
+// finalizer goroutine has started on it yet. Once a finalizer goroutine
+// has taken the finalizer, RunFinalizer returns false without waiting
+// for it to finish. RunFinalizer is meant for releasing resources at a
+// known point, such as at shutdown, instead of whenever a collection
+// happens to find the objects holding them.
+func RunFinalizer(obj interface{}) bool {
 	e := efaceOf(&obj)
 	etyp := e._type
 	if etyp == nil {
-		throw("runtime.SetFinalizer: first argument is nil")
+		return false
 	}
 	if etyp.kind&kindMask != kindPtr {
-		throw("runtime.SetFinalizer: first argument is " + etyp.string() + ", not pointer")
+		panic(plainError("runtime.RunFinalizer: argument is " + etyp.string() + ", not pointer"))
 	}
-	ot := (*ptrtype)(unsafe.Pointer(etyp))
+	if _, base, _ := findObject(e.data); base == nil {
+		return false
+	}
+	var f finalizer
+	key := finalizerKey(e.data)
+	if s := (*specialfinalizer)(unsafe.Pointer(removespecial(key, _KindSpecialFinalizer))); s != nil {
+		f = finalizer{s.fn, add(key, s.argoff), s.nret, s.fint, s.ot}
+		lock(&mheap_.speciallock)
+		mheap_.specialfinalizeralloc.free(unsafe.Pointer(s))
+		unlock(&mheap_.speciallock)
+		// Count it as queued, as if the collector had found obj
+		// unreachable.
+		atomic.Xadd64(&finstats.queued, 1)
+	} else if !dequeuefinalizer(e.data, &f) {
+		return false
+	}
+
+	framesz := unsafe.Sizeof((interface{})(nil)) + f.nret&^finFlags
+	// As in finworker, the frame is not scanned; obj keeps the
+	// object alive.
+	frame := mallocgc(framesz, nil, 0)
+	finalizerArgs(frame, &f)
+	gp := getg()
+	gp.noalloc = f.nret&finNoAlloc != 0
+	start := nanotime()
+	reflectcall(nil, unsafe.Pointer(f.fn), frame, uint32(framesz), uint32(framesz))
+	finalizerRan(start)
+	gp.noalloc = false
+	KeepAlive(obj)
+	return true
+}
+
+// finalizerTarget checks that obj, the first argument to the exported
+// function fn, can have a finalizer, and returns the address at which
+// the finalizer is recorded (see finalizerKey), the offset from it of
+// the pointer obj holds, and obj's type. It returns a nil pointer for
+// zero-sized and linker-allocated objects, whose finalizers are never
+// run.
+func finalizerTarget(fn string, obj interface{}) (p unsafe.Pointer, off uintptr, ot *ptrtype) {
+	e := efaceOf(&obj)
+	etyp := e._type
+	if etyp == nil {
+		badFinalizer("runtime." + fn + ": first argument is nil")
+	}
+	if etyp.kind&kindMask != kindPtr {
+		badFinalizer("runtime." + fn + ": first argument is " + etyp.string() + ", not pointer")
+	}
+	ot = (*ptrtype)(unsafe.Pointer(etyp))
 	if ot.elem == nil {
 		throw("nil elem type!")
 	}
@@ -330,7 +805,7 @@ func SetFinalizer(obj interface{}, finalizer interface{}) {
 	if base == nil {
 		// 0-length objects are okay.
 		if e.data == unsafe.Pointer(&zerobase) {
-			return
+			return nil, 0, nil
 		}
 
 		// Global initializers might be linker-allocated.
@@ -346,41 +821,47 @@ func SetFinalizer(obj interface{}, finalizer interface{}) {
 				datap.data <= uintptr(e.data) && uintptr(e.data) < datap.edata ||
 				datap.bss <= uintptr(e.data) && uintptr(e.data) < datap.ebss ||
 				datap.noptrbss <= uintptr(e.data) && uintptr(e.data) < datap.enoptrbss {
-				return
+				return nil, 0, nil
 			}
 		}
-		throw("runtime.SetFinalizer: pointer not in allocated block")
+		badFinalizer("runtime." + fn + ": pointer not in allocated block")
 	}
 
-	if e.data != base {
-		// As an implementation detail we allow to set finalizers for an inner byte
-		// of an object if it could come from tiny alloc (see mallocgc for details).
-		if ot.elem == nil || ot.elem.kind&kindNoPointers == 0 || ot.elem.size >= maxTinySize {
-			throw("runtime.SetFinalizer: pointer not at beginning of allocated block")
-		}
-	}
+	// e.data may point into the middle of the object.
+	p = finalizerKey(e.data)
+	return p, uintptr(e.data) - uintptr(p), ot
+}
 
-	f := efaceOf(&finalizer)
-	ftyp := f._type
-	if ftyp == nil {
-		// switch to system stack and remove finalizer
-		systemstack(func() {
-			removefinalizer(e.data)
-		})
-		return
+// finalizerKey returns the address at which the finalizer for the
+// pointer p into a heap object is recorded: the beginning of the
+// object, unless the object's block may be a tiny block shared with
+// other objects (see mallocgc), in which case each pointer into the
+// block is a key of its own and p itself is returned.
+func finalizerKey(p unsafe.Pointer) unsafe.Pointer {
+	s, base, n := findObject(p)
+	if base == nil || n <= maxTinySize && !objectHasPointers(s, uintptr(base)) {
+		return p
 	}
+	return base
+}
 
+// finalizerArg checks that a finalizer of type ftyp, passed to the
+// exported function fn, can be called with obj, and returns the type
+// of its parameter and the size of its results.
+func finalizerArg(fn string, obj interface{}, ftyp *_type) (fint *_type, nret uintptr) {
+	etyp := efaceOf(&obj)._type
+	ot := (*ptrtype)(unsafe.Pointer(etyp))
 	if ftyp.kind&kindMask != kindFunc {
-		throw("runtime.SetFinalizer: second argument is " + ftyp.string() + ", not a function")
+		badFinalizer("runtime." + fn + ": second argument is " + ftyp.string() + ", not a function")
 	}
 	ft := (*functype)(unsafe.Pointer(ftyp))
 	if ft.dotdotdot() {
-		throw("runtime.SetFinalizer: cannot pass " + etyp.string() + " to finalizer " + ftyp.string() + " because dotdotdot")
+		badFinalizer("runtime." + fn + ": cannot pass " + etyp.string() + " to finalizer " + ftyp.string() + " because dotdotdot")
 	}
 	if ft.dotdotdot() || ft.inCount != 1 {
-		throw("runtime.SetFinalizer: cannot pass " + etyp.string() + " to finalizer " + ftyp.string())
+		badFinalizer("runtime." + fn + ": cannot pass " + etyp.string() + " to finalizer " + ftyp.string())
 	}
-	fint := ft.in()[0]
+	fint = ft.in()[0]
 	switch {
 	case fint == etyp:
 		// ok - same type
@@ -401,25 +882,210 @@ func SetFinalizer(obj interface{}, finalizer interface{}) {
 			goto okarg
 		}
 	}
-	throw("runtime.SetFinalizer: cannot pass " + etyp.string() + " to finalizer " + ftyp.string())
+	badFinalizer("runtime." + fn + ": cannot pass " + etyp.string() + " to finalizer " + ftyp.string())
 okarg:
 	// compute size needed for return parameters
-	nret := uintptr(0)
 	for _, t := range ft.out() {
 		nret = round(nret, uintptr(t.align)) + uintptr(t.size)
 	}
 	nret = round(nret, sys.PtrSize)
+	return fint, nret
+}
 
-	// make sure we have a finalizer goroutine
-	createfing()
+// finalizerLenient is 1 if SetFinalizer panics on bad arguments
+// instead of aborting the program. See SetFinalizerStrict.
+var finalizerLenient uint32
+
+// badFinalizer reports a misuse of SetFinalizer.
+func badFinalizer(msg string) {
+	if atomic.Load(&finalizerLenient) != 0 {
+		panic(plainError(msg))
+	}
+	throw(msg)
+}
+
+// SetFinalizerStrict sets how SetFinalizer reports invalid arguments,
+// such as an obj that is not a pointer to a heap object or a finalizer
+// of the wrong type, or an obj that already has a finalizer, and returns
+// the previous setting. In strict mode, the default, SetFinalizer aborts
+// the program, as documented for SetFinalizer. With strict false it
+// panics instead, so a caller such as a plugin host can recover from
+// the misuse. The setting applies to the whole program, and to the
+// other functions that set finalizers or finalizer-like callbacks, such
+// as SetFinalizers and SetSurvivalCallback, as well.
+func SetFinalizerStrict(strict bool) bool {
+	var v uint32
+	if !strict {
+		v = 1
+	}
+	return atomic.Xchg(&finalizerLenient, v) == 0
+}
+
+// SetSurvivalCallback arranges for fn(obj) to be called once obj has
+// survived afterGCs garbage collections while still reachable, as an
+// alarm that obj may have been leaked. The callback runs on the goroutine
+// that runs finalizers, and at most once: if obj becomes unreachable
+// first, fn is never called.
+//
+// An object has at most one survival callback. SetSurvivalCallback
+// replaces any previous callback for obj, and
+// SetSurvivalCallback(obj, 0, nil) removes it.
+//
+// The argument obj must be a pointer to the beginning of an object
+// allocated by calling new, by taking the address of a composite literal,
+// or by taking the address of a local variable. If fn refers to obj, obj remains
+// reachable for as long as the callback is set.
+func SetSurvivalCallback(obj interface{}, afterGCs int, fn func(interface{})) {
+	if debug.sbrk != 0 {
+		// debug.sbrk never frees memory, and has no specials.
+		return
+	}
+	e := efaceOf(&obj)
+	etyp := e._type
+	if etyp == nil {
+		badFinalizer("runtime.SetSurvivalCallback: first argument is nil")
+	}
+	if etyp.kind&kindMask != kindPtr {
+		badFinalizer("runtime.SetSurvivalCallback: first argument is " + etyp.string() + ", not pointer")
+	}
+	ot := (*ptrtype)(unsafe.Pointer(etyp))
+
+	_, base, _ := findObject(e.data)
+	if base == nil {
+		// Global or zero-sized objects are never freed,
+		// so there is nothing to watch.
+		return
+	}
+	if e.data != base {
+		badFinalizer("runtime.SetSurvivalCallback: pointer not at beginning of allocated block")
+	}
 
+	if fn == nil || afterGCs <= 0 {
+		systemstack(func() {
+			removesurvival(e.data)
+		})
+		return
+	}
+	// The callback is called like a finalizer of type
+	// func(interface{}) with no results.
+	var fi interface{} = fn
+	ft := (*functype)(unsafe.Pointer(efaceOf(&fi)._type))
+	fv := *(**funcval)(unsafe.Pointer(&fn))
 	systemstack(func() {
-		if !addfinalizer(e.data, (*funcval)(f.data), nret, fint, ot) {
-			throw("runtime.SetFinalizer: finalizer already set")
-		}
+		addsurvival(e.data, afterGCs, fv, ft.in()[0], ot)
 	})
 }
 
+// FinalizerBlockedBy returns the objects with finalizers that keep the
+// finalizer for obj from running, for debugging a finalizer that never
+// runs. Before freeing an object with a finalizer, the garbage collector
+// retains everything reachable from it, including its finalizer function,
+// so a finalizer cannot run while its object is reachable from another
+// object with a finalizer that has not run yet. FinalizerBlockedBy lists
+// each such object, as the pointer passed to SetFinalizer. If obj is
+// reachable from itself, it is listed too, and its finalizer never runs.
+//
+// The answer describes the heap at the time of the call, and only the
+// blocking objects: obj may still be reachable in other ways. It returns
+// nil if obj does not point into the heap. FinalizerBlockedBy stops the
+// world and may walk much of the heap once for each object with a
+// finalizer, so it is slow.
+func FinalizerBlockedBy(obj interface{}) []interface{} {
+	e := efaceOf(&obj)
+	etyp := e._type
+	if etyp == nil {
+		return nil
+	}
+	if etyp.kind&kindMask != kindPtr {
+		panic(plainError("runtime.FinalizerBlockedBy: argument is " + etyp.string() + ", not pointer"))
+	}
+
+	stopTheWorld("finalizer blocked by")
+	var blockers []interface{}
+	if _, target, _ := findObject(e.data); target != nil {
+		// Record each blocking object and its type as a pair of
+		// addresses. Only the result is allocated from the heap,
+		// after the walk, so that h_allspans doesn't change under it.
+		var found addrList
+		for _, s := range h_allspans {
+			if s.state != mSpanInUse {
+				continue
+			}
+			for sp := s.specials; sp != nil; sp = sp.next {
+				if sp.kind != _KindSpecialFinalizer {
+					continue
+				}
+				spf := (*specialfinalizer)(unsafe.Pointer(sp))
+				p := s.base() + uintptr(spf.special.offset)/s.elemsize*s.elemsize
+				if finalizerReaches(p, spf.fn, uintptr(target)) {
+					found.add(s.base() + uintptr(spf.special.offset) + spf.argoff)
+					found.add(uintptr(unsafe.Pointer(spf.ot)))
+				}
+			}
+		}
+		blockers = make([]interface{}, found.n/2)
+		for i := range blockers {
+			be := efaceOf(&blockers[i])
+			be.data = *(*unsafe.Pointer)(add(found.buf, uintptr(2*i)*sys.PtrSize))
+			be._type = &(*(**ptrtype)(add(found.buf, uintptr(2*i+1)*sys.PtrSize))).typ
+		}
+		found.free()
+	}
+	startTheWorld()
+	return blockers
+}
+
+// finalizerReaches reports whether the heap object at target can be
+// reached from the object at p, which has finalizer fn, as the garbage
+// collector follows pointers from p in markrootSpans: starting from the
+// pointers in p and from fn, but not from p itself.
+func finalizerReaches(p uintptr, fn *funcval, target uintptr) bool {
+	var stack addrList
+	var seen addrSet
+	defer stack.free()
+	defer seen.free()
+	stack.add(uintptr(unsafe.Pointer(fn)))
+	addObjectPointers(p, &stack)
+	for stack.n > 0 {
+		obj, _, _, _ := heapBitsForObject(stack.pop(), 0, 0)
+		if obj == 0 {
+			continue
+		}
+		if obj == target {
+			return true
+		}
+		if seen.add(obj) {
+			addObjectPointers(obj, &stack)
+		}
+	}
+	return false
+}
+
+// addObjectPointers adds the pointers held in the heap object at b
+// to l. It reads the heap bitmap like scanobject.
+func addObjectPointers(b uintptr, l *addrList) {
+	s := spanOfUnchecked(b)
+	s.ensureHeapBits()
+	n := s.elemsize
+	hbits := heapBitsForAddr(b)
+	if !hbits.hasPointers(n) {
+		return
+	}
+	for i := uintptr(0); i < n; i += sys.PtrSize {
+		if i != 0 {
+			hbits = hbits.next()
+		}
+		if i != 1*sys.PtrSize && !hbits.morePointers() {
+			break
+		}
+		if hbits.isPointer() {
+			if v := *(*uintptr)(unsafe.Pointer(b + i)); v != 0 {
+				l.add(v)
+			}
+		}
+	}
+}
+
 // Look up pointer v in heap. Return the span containing the object,
 // the start of the object, and the size of the object. If the object
 // does not exist, return nil, nil, 0.
@@ -6,6 +6,7 @@ package runtime_test
 
 import (
 	"runtime"
+	"strings"
 	"testing"
 	"time"
 	"unsafe"
@@ -110,6 +111,105 @@ func TestFinalizerInterfaceBig(t *testing.T) {
 func fin(v *int) {
 }
 
+type interiorFinObj struct {
+	p      *int
+	handle int
+	pad    [32]byte
+}
+
+var interiorFinSink *interiorFinObj
+
+func TestFinalizerInteriorPointer(t *testing.T) {
+	ch := make(chan uintptr, 1)
+	done := make(chan uintptr)
+	go func() {
+		v := &interiorFinObj{p: new(int)}
+		runtime.SetFinalizer(&v.handle, func(h *int) { ch <- uintptr(unsafe.Pointer(h)) })
+		interiorFinSink = v
+		done <- uintptr(unsafe.Pointer(&v.handle))
+	}()
+	want := <-done
+	runtime.GC()
+	select {
+	case <-ch:
+		t.Fatalf("finalizer for interior pointer ran while the object was reachable")
+	case <-time.After(100 * time.Millisecond):
+	}
+	interiorFinSink = nil
+	runtime.GC()
+	select {
+	case got := <-ch:
+		if got != want {
+			t.Errorf("finalizer called with %#x, want %#x", got, want)
+		}
+	case <-time.After(4 * time.Second):
+		t.Errorf("finalizer for interior pointer didn't run")
+	}
+}
+
+func TestFinalizerInteriorPointerOnePerObject(t *testing.T) {
+	defer runtime.SetFinalizerStrict(runtime.SetFinalizerStrict(false))
+	ran := make(chan bool, 1)
+	v := &interiorFinObj{p: new(int)}
+	runtime.SetFinalizer(&v.handle, func(*int) { ran <- true })
+	if !runtime.HasFinalizer(v) {
+		t.Fatalf("object has no finalizer after setting one on a pointer to a field")
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("second finalizer for the same object set")
+			}
+		}()
+		runtime.SetFinalizer(v, func(*interiorFinObj) {})
+	}()
+	runtime.SetFinalizer(v, nil)
+	if runtime.HasFinalizer(&v.handle) {
+		t.Fatalf("finalizer set on a pointer to a field not cleared through the object")
+	}
+	v = nil
+	runtime.GC()
+	select {
+	case <-ran:
+		t.Errorf("cleared finalizer ran")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+type finBlockNode struct {
+	next *finBlockNode
+	n    int
+}
+
+func TestFinalizerBlockedBy(t *testing.T) {
+	a := &finBlockNode{n: 1}
+	b := &finBlockNode{next: &finBlockNode{next: a}} // b reaches a indirectly
+	c := &finBlockNode{}                             // c's finalizer refers to a
+	d := &finBlockNode{next: b}                      // unrelated to a except through b
+	runtime.SetFinalizer(a, func(*finBlockNode) {})
+	runtime.SetFinalizer(b, func(*finBlockNode) {})
+	runtime.SetFinalizer(c, func(*finBlockNode) { _ = a.n })
+	defer func() {
+		runtime.SetFinalizer(a, nil)
+		runtime.SetFinalizer(b, nil)
+		runtime.SetFinalizer(c, nil)
+	}()
+
+	got := runtime.FinalizerBlockedBy(a)
+	if len(got) != 2 {
+		t.Fatalf("FinalizerBlockedBy(a) returned %d objects, want 2", len(got))
+	}
+	for _, x := range got {
+		if p, ok := x.(*finBlockNode); !ok || p != b && p != c {
+			t.Errorf("FinalizerBlockedBy(a) returned unexpected %v", x)
+		}
+	}
+	if got := runtime.FinalizerBlockedBy(b); len(got) != 0 {
+		t.Errorf("FinalizerBlockedBy(b) = %v, want none", got)
+	}
+	runtime.KeepAlive(d)
+}
+
 // Verify we don't crash at least. golang.org/issue/6857
 func TestFinalizerZeroSizedStruct(t *testing.T) {
 	type Z struct{}
@@ -221,6 +321,389 @@ func TestEmptyString(t *testing.T) {
 
 var ssglobal string
 
+func TestFinalizerConcurrency(t *testing.T) {
+	defer runtime.SetFinalizerConcurrency(runtime.SetFinalizerConcurrency(4))
+
+	const N = 8
+	started := make(chan bool, N)
+	release := make(chan bool)
+	done := make(chan bool, N)
+	alloc := make(chan bool)
+	go func() {
+		for i := 0; i < N; i++ {
+			// allocate struct with pointer to avoid hitting tinyalloc.
+			type T struct {
+				v int
+				p unsafe.Pointer
+			}
+			runtime.SetFinalizer(new(T), func(*T) {
+				started <- true
+				<-release
+				done <- true
+			})
+		}
+		alloc <- true
+	}()
+	<-alloc
+	runtime.GC()
+
+	// With a single finalizer goroutine the second finalizer could
+	// not start until the first was released.
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(4 * time.Second):
+			close(release)
+			t.Fatalf("%d finalizers running concurrently, want at least 2", i)
+		}
+	}
+	close(release)
+	for i := 0; i < N; i++ {
+		select {
+		case <-done:
+		case <-time.After(4 * time.Second):
+			t.Fatalf("only %d of %d finalizers ran", i, N)
+		}
+	}
+}
+
+func TestSetFinalizerBatchSize(t *testing.T) {
+	old := runtime.SetFinalizerBatchSize(16)
+	defer runtime.SetFinalizerBatchSize(old)
+	if old != 1 {
+		t.Errorf("initial finalizer batch size %d, want 1", old)
+	}
+
+	const N = 100
+	done := make(chan bool, N)
+	alloc := make(chan bool)
+	go func() {
+		for i := 0; i < N; i++ {
+			// allocate struct with pointer to avoid hitting tinyalloc.
+			type T struct {
+				v int
+				p unsafe.Pointer
+			}
+			runtime.SetFinalizer(new(T), func(*T) { done <- true })
+		}
+		alloc <- true
+	}()
+	<-alloc
+	runtime.GC()
+	for i := 0; i < N; i++ {
+		select {
+		case <-done:
+		case <-time.After(4 * time.Second):
+			t.Fatalf("%d of %d finalizers ran", i, N)
+		}
+	}
+}
+
+var survivalSink *objtype
+
+func TestSurvivalCallback(t *testing.T) {
+	live := make(chan bool, 1)
+	survivalSink = new(objtype)
+	runtime.SetSurvivalCallback(survivalSink, 2, func(x interface{}) {
+		live <- x.(*objtype) == survivalSink
+	})
+
+	dead := make(chan bool, 1)
+	done := make(chan bool)
+	go func() {
+		runtime.SetSurvivalCallback(new(objtype), 2, func(interface{}) {
+			dead <- true
+		})
+		done <- true
+	}()
+	<-done
+
+	for i := 0; i < 3; i++ {
+		runtime.GC()
+	}
+	select {
+	case ok := <-live:
+		if !ok {
+			t.Errorf("survival callback called with wrong object")
+		}
+	case <-time.After(4 * time.Second):
+		t.Errorf("survival callback did not run for reachable object")
+	}
+	select {
+	case <-dead:
+		t.Errorf("survival callback ran for unreachable object")
+	default:
+	}
+	survivalSink = nil
+}
+
+func TestSetFinalizerStrict(t *testing.T) {
+	if !runtime.SetFinalizerStrict(false) {
+		t.Fatalf("SetFinalizerStrict not strict by default")
+	}
+	defer runtime.SetFinalizerStrict(true)
+
+	mustPanic := func(name string, f func()) {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("%s did not panic", name)
+			}
+		}()
+		f()
+	}
+	mustPanic("SetFinalizer on non-pointer", func() {
+		runtime.SetFinalizer(1, func(int) {})
+	})
+	v := new(int)
+	mustPanic("SetFinalizer with wrong finalizer type", func() {
+		runtime.SetFinalizer(v, func(*string) {})
+	})
+	runtime.SetFinalizer(v, func(*int) {})
+	mustPanic("SetFinalizer twice", func() {
+		runtime.SetFinalizer(v, func(*int) {})
+	})
+	runtime.SetFinalizer(v, nil)
+	mustPanic("SetSurvivalCallback on non-pointer", func() {
+		runtime.SetSurvivalCallback(1, 1, func(interface{}) {})
+	})
+	w := new([4]int)
+	mustPanic("SetSurvivalCallback on interior pointer", func() {
+		runtime.SetSurvivalCallback(&w[1], 1, func(interface{}) {})
+	})
+}
+
+// setFinalizers sets a finalizer sending x[0] to done on each of n
+// new objects, then clears it on the second half of them.
+func setFinalizers(n int, done chan int) {
+	objs := make([]interface{}, n)
+	for i := range objs {
+		x := new([16]int)
+		x[0] = i
+		objs[i] = x
+	}
+	runtime.SetFinalizers(objs, func(x *[16]int) { done <- x[0] })
+	runtime.SetFinalizers(objs[n/2:], nil)
+}
+
+func TestSetFinalizers(t *testing.T) {
+	const N = 100
+	done := make(chan int, N)
+	setFinalizers(N, done)
+	runtime.GC()
+	seen := make(map[int]bool)
+	for len(seen) < N/2 {
+		select {
+		case i := <-done:
+			if i >= N/2 {
+				t.Fatalf("cleared finalizer ran for object %d", i)
+			}
+			seen[i] = true
+		case <-time.After(4 * time.Second):
+			t.Fatalf("only %d of %d finalizers ran", len(seen), N/2)
+		}
+	}
+}
+
+func TestSetFinalizersStrict(t *testing.T) {
+	runtime.SetFinalizerStrict(false)
+	defer runtime.SetFinalizerStrict(true)
+
+	v, w := new(int), new(int)
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("SetFinalizers with wrong finalizer type did not panic")
+			}
+		}()
+		runtime.SetFinalizers([]interface{}{v, new(string)}, func(*int) {})
+	}()
+	// The failed call set no finalizers.
+	runtime.SetFinalizers([]interface{}{v, w}, func(*int) {})
+	runtime.SetFinalizers([]interface{}{v, w}, nil)
+}
+
+func TestHasFinalizer(t *testing.T) {
+	if runtime.HasFinalizer((*int)(nil)) {
+		t.Errorf("HasFinalizer(nil) = true")
+	}
+	x := new([4]int)
+	if runtime.HasFinalizer(x) {
+		t.Errorf("HasFinalizer of new object = true")
+	}
+	runtime.SetFinalizer(x, func(*[4]int) {})
+	if !runtime.HasFinalizer(x) {
+		t.Errorf("HasFinalizer after SetFinalizer = false")
+	}
+	if !runtime.HasFinalizer(&x[1]) {
+		t.Errorf("HasFinalizer of interior pointer = false")
+	}
+	runtime.SetFinalizer(x, nil)
+	if runtime.HasFinalizer(x) {
+		t.Errorf("HasFinalizer after clearing the finalizer = true")
+	}
+	if runtime.HasFinalizer(Foo1) {
+		t.Errorf("HasFinalizer of a global = true")
+	}
+}
+
+func TestRunFinalizer(t *testing.T) {
+	x := new([4]int)
+	if runtime.RunFinalizer(x) {
+		t.Errorf("RunFinalizer of object without finalizer = true")
+	}
+	runs := 0
+	runtime.SetFinalizer(x, func(p *[4]int) {
+		if p != x {
+			t.Errorf("finalizer called with %p, want %p", p, x)
+		}
+		runs++
+	})
+	if !runtime.RunFinalizer(x) || runs != 1 {
+		t.Fatalf("RunFinalizer did not run the finalizer")
+	}
+	if runtime.HasFinalizer(x) || runtime.RunFinalizer(x) || runs != 1 {
+		t.Errorf("finalizer still attached after RunFinalizer")
+	}
+	x = nil
+	runtime.GC()
+	runtime.GC()
+	if runs != 1 {
+		t.Errorf("finalizer ran %d times", runs)
+	}
+}
+
+func TestFinalizerStats(t *testing.T) {
+	before := runtime.FinalizerStats()
+	done := make(chan bool, 1)
+	func() {
+		x := new([4]int)
+		runtime.SetFinalizer(x, func(*[4]int) {
+			time.Sleep(time.Millisecond)
+			done <- true
+		})
+	}()
+	runtime.GC()
+	select {
+	case <-done:
+	case <-time.After(4 * time.Second):
+		t.Fatal("finalizer did not run")
+	}
+	// The count is updated after the finalizer returns.
+	var after runtime.FinalizerSummary
+	for i := 0; i < 1000; i++ {
+		after = runtime.FinalizerStats()
+		if after.Run > before.Run {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if after.Registered <= before.Registered || after.Queued <= before.Queued || after.Run <= before.Run {
+		t.Errorf("FinalizerStats() = %+v after %+v, want more finalizers registered, queued and run", after, before)
+	}
+	if after.RunNanos < before.RunNanos+uint64(time.Millisecond) || after.AvgNanos == 0 {
+		t.Errorf("FinalizerStats() = %+v after %+v, want at least 1ms more run time", after, before)
+	}
+	if after.Pending != after.Queued-after.Run {
+		t.Errorf("FinalizerStats() = %+v, inconsistent Pending", after)
+	}
+}
+
+type snapshotted struct {
+	n    int
+	name string
+}
+
+func TestSetSnapshotFinalizer(t *testing.T) {
+	done := make(chan interface{}, 1)
+	func() {
+		x := &snapshotted{1, "before"}
+		runtime.SetSnapshotFinalizer(x, func(snapshot interface{}) { done <- snapshot })
+		x.n, x.name = 2, "after"
+	}()
+	runtime.GC()
+	select {
+	case v := <-done:
+		s, ok := v.(snapshotted)
+		if !ok {
+			t.Fatalf("finalizer got %T, want snapshotted", v)
+		}
+		if s.n != 2 || s.name != "after" {
+			t.Errorf("finalizer got %+v, want the last state of the object", s)
+		}
+	case <-time.After(4 * time.Second):
+		t.Fatalf("finalizer did not run")
+	}
+
+	// A nil function clears the finalizer.
+	x := new(snapshotted)
+	runtime.SetSnapshotFinalizer(x, func(interface{}) {})
+	runtime.SetSnapshotFinalizer(x, nil)
+	if runtime.HasFinalizer(x) {
+		t.Errorf("SetSnapshotFinalizer(x, nil) did not clear the finalizer")
+	}
+}
+
+func TestSetFinalizerNoAlloc(t *testing.T) {
+	done := make(chan int, 1)
+	func() {
+		x := new([16]int)
+		x[0] = 42
+		runtime.SetFinalizerNoAlloc(x, func(x *[16]int) { done <- x[0] })
+	}()
+	runtime.GC()
+	select {
+	case v := <-done:
+		if v != 42 {
+			t.Errorf("finalizer got %d, want 42", v)
+		}
+	case <-time.After(4 * time.Second):
+		t.Fatalf("finalizer did not run")
+	}
+
+	output := runTestProg(t, "testprog", "FinalizerNoAlloc")
+	want := "fatal error: heap allocation in finalizer set by SetFinalizerNoAlloc"
+	if !strings.HasPrefix(output, want) {
+		t.Fatalf("output does not start with %q:\n%s", want, output)
+	}
+}
+
+type resurrected struct {
+	p *int
+}
+
+func TestSetResurrectionAudit(t *testing.T) {
+	audit := make(chan string, 10)
+	runtime.SetResurrectionAudit(func(typ string) {
+		select {
+		case audit <- typ:
+		default:
+		}
+	})
+	defer runtime.SetResurrectionAudit(nil)
+
+	done := make(chan bool, 1)
+	func() {
+		x := &resurrected{new(int)}
+		runtime.SetFinalizer(x, func(*resurrected) { done <- true })
+	}()
+	runtime.GC()
+	// Finalizers left over from other tests may be audited first.
+	timeout := time.After(4 * time.Second)
+	for found := false; !found; {
+		select {
+		case typ := <-audit:
+			found = typ == "runtime_test.resurrected"
+		case <-timeout:
+			t.Fatalf("resurrection audit not called")
+		}
+	}
+	select {
+	case <-done:
+	case <-time.After(4 * time.Second):
+		t.Fatalf("finalizer did not run after the audit")
+	}
+}
+
 // Test for issue 7656.
 func TestFinalizerOnGlobal(t *testing.T) {
 	runtime.SetFinalizer(Foo1, func(p *Object1) {})
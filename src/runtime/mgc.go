@@ -131,9 +131,8 @@ import (
 )
 
 const (
-	_DebugGC         = 0
-	_ConcurrentSweep = true
-	_FinBlockSize    = 4 * 1024
+	_DebugGC      = 0
+	_FinBlockSize = 4 * 1024
 
 	// sweepMinHeapDistance is a lower bound on the heap distance
 	// (in bytes) reserved for concurrent sweeping between GC
@@ -359,6 +358,13 @@ type gcControllerState struct {
 	// dedicated mark workers get started.
 	dedicatedMarkWorkersNeeded int64
 
+	// dedicatedMarkWorkersRunning is the number of dedicated mark
+	// workers draining right now, and dedicatedMarkWorkersPeak is
+	// the most that have drained at once during this cycle. Both
+	// are updated atomically.
+	dedicatedMarkWorkersRunning uint32
+	dedicatedMarkWorkersPeak    uint32
+
 	// assistWorkPerByte is the ratio of scan work to allocated
 	// bytes that should be performed by mutator assists. This is
 	// computed at the beginning of each cycle and updated every
@@ -404,6 +410,7 @@ func (c *gcControllerState) startCycle() {
 	c.dedicatedMarkTime = 0
 	c.fractionalMarkTime = 0
 	c.idleMarkTime = 0
+	c.dedicatedMarkWorkersPeak = 0
 
 	// If this is the first GC cycle or we're operating on a very
 	// small heap, fake heap_marked so it looks like next_gc is
@@ -440,6 +447,12 @@ func (c *gcControllerState) startCycle() {
 	} else {
 		c.fractionalMarkWorkersNeeded = 0
 	}
+	if limit := int64(atomic.Load(&gcWorkerLimit)); limit != 0 && c.dedicatedMarkWorkersNeeded+c.fractionalMarkWorkersNeeded > limit {
+		// The limit is reached by dedicated workers alone.
+		c.dedicatedMarkWorkersNeeded = limit
+		c.fractionalUtilizationGoal = 0
+		c.fractionalMarkWorkersNeeded = 0
+	}
 
 	// Clear per-P state
 	for _, p := range &allp {
@@ -592,6 +605,7 @@ func (c *gcControllerState) endCycle() {
 			" goalΔ=", goalGrowthRatio-h_t,
 			" actualΔ=", h_a-h_t,
 			" u_a/u_g=", u_a/u_g,
+			" workers_peak=", c.dedicatedMarkWorkersPeak,
 			"\n")
 	}
 }
@@ -847,6 +861,26 @@ var work struct {
 
 	// debug.gctrace heap sizes for this cycle.
 	heap0, heap1, heap2, heapGoal uint64
+
+	// While a GCStats caller holds gcResultSema, the mark
+	// termination of a cycle run by goroutine resultg fills in
+	// result.
+	resultg *g
+	result  GCResult
+
+	// While a GCMarkOnly caller holds gcResultSema, the mark
+	// termination of a cycle run by goroutine markOnlyg records
+	// the unreachable objects in unreachable and keeps them.
+	markOnlyg   *g
+	unreachable addrList
+
+	// backpressureWaiters is a list of G's, linked through
+	// schedlink, that triggered this cycle with GC backpressure
+	// enabled and are waiting for it to finish.
+	backpressureWaiters struct {
+		lock mutex
+		head guintptr
+	}
 }
 
 // GC runs a garbage collection and blocks the caller until the
@@ -856,6 +890,554 @@ func GC() {
 	gcStart(gcForceBlockMode, false)
 }
 
+// GCQuick runs a garbage collection with a shorter pause than GC.
+// Like GC, it stops the world to mark the heap and blocks the caller
+// until marking is complete. Unlike GC, it does not also sweep the whole
+// heap before restarting the world: the unreachable memory it finds is
+// reclaimed lazily afterwards, by the background sweeper and by
+// allocating goroutines, as after an automatic collection. So when
+// GCQuick returns, heap statistics such as MemStats.HeapAlloc may still
+// include memory that is about to be freed, and allocations soon after
+// the call pay a small cost for sweeping. Call ForceSweepComplete to
+// finish the sweep when needed.
+func GCQuick() {
+	gcStart(gcForceMode, false)
+}
+
+// StressGC runs n garbage collections in a row, each as by GC, for
+// tests that hunt for bugs that depend on the timing of collections.
+// Between collections it yields the processor, so that the goroutines
+// under test run in between, each time with the heap freshly collected
+// and swept. A single call exercises every phase of the collector n
+// times; running it in a loop alongside the code under test keeps the
+// collector busy for as long as needed.
+func StressGC(n int) {
+	for i := 0; i < n; i++ {
+		gcStart(gcForceBlockMode, false)
+		Gosched()
+	}
+}
+
+// gcLastEnd is the nanotime() at which the last collection finished,
+// or 0 if none has. Accessed atomically.
+var gcLastEnd uint64
+
+// LastGCTime returns the time at which the last garbage collection
+// finished, in nanoseconds since the Unix epoch, as MemStats.LastGC,
+// or 0 if no collection has finished yet. It costs a single load,
+// unlike ReadMemStats, which stops the world.
+func LastGCTime() int64 {
+	return int64(atomic.Load64(&memstats.last_gc))
+}
+
+// TimeSinceLastGC returns the time, in nanoseconds, that has passed
+// since the last garbage collection finished, or since the program
+// started if no collection has finished yet. A program can use it to
+// tell whether what it last learned about the heap, for instance from
+// ReadMemStats, may be out of date. Unlike the difference between
+// LastGCTime and the current time, it is not affected by changes to
+// the system clock.
+func TimeSinceLastGC() int64 {
+	end := int64(atomic.Load64(&gcLastEnd))
+	if end == 0 {
+		end = runtimeInitTime
+	}
+	return nanotime() - end
+}
+
+// gcLastScanBytes is the scan work of the last collection, or 0 if
+// none has finished. Accessed atomically.
+var gcLastScanBytes uintptr
+
+// LastGCScanBytes returns the number of bytes of heap objects the last
+// garbage collection scanned for pointers, or 0 if no collection has
+// finished yet. Only the part of each object up to its last pointer is
+// scanned, so this counts the pointer-bearing part of the live heap,
+// not all of it, and objects allocated during the collection count as
+// scanned; stacks and globals are not included. Together with the
+// collection's pause and CPU times it gives the mark throughput.
+func LastGCScanBytes() uintptr {
+	return atomic.Loaduintptr(&gcLastScanBytes)
+}
+
+// A GCResult describes a single garbage collection.
+type GCResult struct {
+	Reclaimed    uint64 // bytes found unreachable by this collection
+	Live         uint64 // bytes marked live by this collection
+	PauseNs      uint64 // total stop-the-world pause, in nanoseconds
+	FreedObjects uint64 // objects freed by sweeping after this collection
+}
+
+// gcResultSema serializes GCStats and GCMarkOnly callers.
+var gcResultSema uint32 = 1
+
+// GCStats runs a garbage collection, like GC, and returns statistics
+// about that collection. Unlike comparing MemStats read before and
+// after a call to GC, the result is not affected by allocation or
+// other collections happening at the same time.
+func GCStats() GCResult {
+	semacquire(&gcResultSema, false)
+	work.resultg = getg()
+	work.result = GCResult{}
+	gcStart(gcForceBlockMode, false)
+	r := work.result
+	work.resultg = nil
+	semrelease(&gcResultSema)
+	return r
+}
+
+// gcWorkerLimit is the limit on mark workers set by SetGCWorkerCount,
+// or 0 if there is none. Accessed atomically.
+var gcWorkerLimit uint32
+
+// SetGCWorkerCount limits the number of CPUs the garbage collector
+// marks with at once to n and returns the previous limit. A limit of 0,
+// the initial setting, means that the collector uses its default of a
+// quarter of GOMAXPROCS for background marking, rounded up with a
+// worker that runs part of the time, and in addition any processor
+// that would otherwise be idle. A call with n < 0 does not change the
+// limit. A new limit on the dedicated workers takes effect at the
+// start of the next cycle.
+//
+// With a limit set, background marking uses at most n processors and
+// never runs on idle ones, and the stop-the-world phases of a
+// collection use at most n threads, so that collections leave the
+// other CPUs of the machine to other work. Goroutines that allocate
+// while marking is in progress still help with it, as they always do,
+// in proportion to how much they allocate; a limit well below the
+// default therefore makes collections take longer and shifts more of
+// their cost onto allocating goroutines.
+func SetGCWorkerCount(n int) int {
+	if n < 0 {
+		return int(atomic.Load(&gcWorkerLimit))
+	}
+	return int(atomic.Xchg(&gcWorkerLimit, uint32(n)))
+}
+
+// gcBackpressure is 1 if GC backpressure is enabled.
+// See SetGCBackpressure.
+var gcBackpressure uint32
+
+// SetGCBackpressure enables or disables GC backpressure and returns
+// the previous setting. It is disabled by default.
+//
+// Normally an allocation that pushes the heap past the collection
+// trigger starts a collection and continues, and allocation proceeds
+// concurrently with marking, slowed only by mark assists. Under a burst
+// of allocation this lets the heap grow well past its goal before the
+// collection finishes. With backpressure enabled, the goroutine whose
+// allocation starts a collection blocks until that collection is
+// complete, and other goroutines yield the processor when they need
+// more memory from the heap while a collection is running. This bounds
+// heap growth during bursts at the cost of allocation latency.
+func SetGCBackpressure(enable bool) bool {
+	var v uint32
+	if enable {
+		v = 1
+	}
+	return atomic.Xchg(&gcBackpressure, v) != 0
+}
+
+// GCBackpressure reports whether GC backpressure is enabled.
+// See SetGCBackpressure.
+func GCBackpressure() bool {
+	return atomic.Load(&gcBackpressure) != 0
+}
+
+// gcBackpressureWait applies GC backpressure to an allocating goroutine
+// that needed more memory from the heap. If started is true, the
+// goroutine has just tried to start a collection and waits for the
+// current cycle to finish; otherwise it yields if a cycle is running.
+// It does nothing in the situations where gcStart declines to start
+// a cycle.
+func gcBackpressureWait(started bool) {
+	mp := acquirem()
+	if gp := getg(); gp == mp.g0 || mp.locks > 1 || mp.preemptoff != "" {
+		releasem(mp)
+		return
+	}
+	releasem(mp)
+	if !started {
+		if gcphase != _GCoff {
+			Gosched()
+		}
+		return
+	}
+
+	lock(&work.backpressureWaiters.lock)
+	// gcMarkTermination turns off the GC phase before waking
+	// waiters, so checking it under the lock cannot miss the
+	// wakeup.
+	if gcphase == _GCoff {
+		unlock(&work.backpressureWaiters.lock)
+		return
+	}
+	gp := getg()
+	gp.schedlink = work.backpressureWaiters.head
+	work.backpressureWaiters.head.set(gp)
+	goparkunlock(&work.backpressureWaiters.lock, "GC backpressure wait", traceEvGoBlock, 1)
+}
+
+// gcWakeBackpressureWaiters readies the goroutines blocked in
+// gcBackpressureWait. It is called at the end of each GC cycle.
+func gcWakeBackpressureWaiters() {
+	lock(&work.backpressureWaiters.lock)
+	injectglist(work.backpressureWaiters.head.ptr())
+	work.backpressureWaiters.head.set(nil)
+	unlock(&work.backpressureWaiters.lock)
+}
+
+// GCMarkOnly runs a garbage collection, like GC, but instead of freeing
+// the objects it finds unreachable, it returns pointers to them. It is
+// a debugging aid for checking which objects the collector considers
+// dead, for example to track down an object that is freed while it is
+// still in use, or to confirm that objects expected to be live are.
+//
+// The returned objects are not freed by this collection, and while the
+// result refers to them they stay allocated and can be inspected.
+// Objects with finalizers are included, and their finalizers do not
+// run until a later collection finds them unreachable. The result
+// includes objects of every kind, including ones allocated by the
+// runtime and by the standard library, and can be very large.
+func GCMarkOnly() []unsafe.Pointer {
+	semacquire(&gcResultSema, false)
+	defer semrelease(&gcResultSema)
+	for {
+		work.markOnlyg = getg()
+		gcStart(gcForceBlockMode, false)
+		work.markOnlyg = nil
+		numgc := atomic.Load(&memstats.numgc)
+
+		// The kept objects are now referred to only by
+		// work.unreachable, which the collector does not scan,
+		// so they survive only until the next collection.
+		// Holding worldsema keeps that collection from
+		// starting while the result is built.
+		semacquire(&worldsema, false)
+		u := &work.unreachable
+		if numgc != memstats.numgc || gcphase != _GCoff {
+			// Another collection ran or is running.
+			// It may free the objects, so try again.
+			semrelease(&worldsema)
+			u.free()
+			continue
+		}
+		var ptrs []unsafe.Pointer
+		if u.n > 0 {
+			// Not triggering a GC, which would need worldsema.
+			t := allocElemType("GCMarkOnly", sys.PtrSize, (*unsafe.Pointer)(nil))
+			p := mallocgc(u.n*sys.PtrSize, t, flagNoGCTrigger)
+			*(*slice)(unsafe.Pointer(&ptrs)) = slice{p, int(u.n), int(u.n)}
+			for i := range ptrs {
+				ptrs[i] = unsafe.Pointer(*(*uintptr)(add(u.buf, uintptr(i)*sys.PtrSize)))
+			}
+		}
+		semrelease(&worldsema)
+		u.free()
+		return ptrs
+	}
+}
+
+// gcKeepUnreachable marks every allocated object that the collection
+// did not mark and records its address in work.unreachable, so that
+// GCMarkOnly can return it. Marking all of them, rather than just some,
+// keeps any object that a kept object points to as well.
+//
+// The world must be stopped, after marking and before sweeping.
+func gcKeepUnreachable() {
+	u := &work.unreachable
+	u.n = 0
+	for _, s := range h_allspans {
+		if s.state != mSpanInUse {
+			continue
+		}
+		for i := uintptr(0); i < s.nelems; i++ {
+			if i >= s.freeindex && s.isFree(i) {
+				continue
+			}
+			mbits := s.markBitsForIndex(i)
+			if mbits.isMarked() {
+				continue
+			}
+			mbits.setMarkedNonAtomic()
+			u.add(s.base() + i*s.elemsize)
+			// Account for the object as marked, since
+			// sweeping will keep it.
+			memstats.heap_marked += uint64(s.elemsize)
+			memstats.heap_live += uint64(s.elemsize)
+		}
+	}
+}
+
+// An addrList is a list of addresses held in memory obtained from
+// the operating system, so it can grow while the world is stopped
+// for mark termination, when heap allocation is not allowed.
+type addrList struct {
+	buf    unsafe.Pointer // *[cap]uintptr, from sysAlloc
+	n, cap uintptr
+}
+
+func (l *addrList) add(p uintptr) {
+	if l.n == l.cap {
+		ncap := l.cap * 2
+		if ncap == 0 {
+			ncap = _PageSize / sys.PtrSize
+		}
+		buf := sysAlloc(ncap*sys.PtrSize, &memstats.gc_sys)
+		if buf == nil {
+			throw("runtime: cannot allocate memory")
+		}
+		if l.buf != nil {
+			memmove(buf, l.buf, l.n*sys.PtrSize)
+			sysFree(l.buf, l.cap*sys.PtrSize, &memstats.gc_sys)
+		}
+		l.buf, l.cap = buf, ncap
+	}
+	*(*uintptr)(add(l.buf, l.n*sys.PtrSize)) = p
+	l.n++
+}
+
+// pop removes and returns the last address in l, which must not be empty.
+func (l *addrList) pop() uintptr {
+	l.n--
+	return *(*uintptr)(add(l.buf, l.n*sys.PtrSize))
+}
+
+func (l *addrList) free() {
+	if l.buf != nil {
+		sysFree(l.buf, l.cap*sys.PtrSize, &memstats.gc_sys)
+	}
+	*l = addrList{}
+}
+
+// An addrSet is a set of nonzero addresses, kept in an open-addressed
+// hash table in memory obtained from the operating system, like an
+// addrList.
+type addrSet struct {
+	buf    unsafe.Pointer // *[cap]uintptr, from sysAlloc; 0 is an empty slot
+	n, cap uintptr        // cap is a power of two
+}
+
+// add adds p to s and reports whether it was not already in s.
+func (s *addrSet) add(p uintptr) bool {
+	if 2*(s.n+1) > s.cap {
+		s.grow()
+	}
+	mask := s.cap - 1
+	i := (p / sys.PtrSize * 0x9e3779b9) & mask
+	for {
+		q := (*uintptr)(add(s.buf, i*sys.PtrSize))
+		if *q == p {
+			return false
+		}
+		if *q == 0 {
+			*q = p
+			s.n++
+			return true
+		}
+		i = (i + 1) & mask
+	}
+}
+
+func (s *addrSet) grow() {
+	old := *s
+	ncap := old.cap * 2
+	if ncap == 0 {
+		ncap = _PageSize / sys.PtrSize
+	}
+	buf := sysAlloc(ncap*sys.PtrSize, &memstats.gc_sys)
+	if buf == nil {
+		throw("runtime: cannot allocate memory")
+	}
+	*s = addrSet{buf: buf, cap: ncap}
+	for i := uintptr(0); i < old.cap; i++ {
+		if p := *(*uintptr)(add(old.buf, i*sys.PtrSize)); p != 0 {
+			s.add(p)
+		}
+	}
+	if old.buf != nil {
+		sysFree(old.buf, old.cap*sys.PtrSize, &memstats.gc_sys)
+	}
+}
+
+func (s *addrSet) free() {
+	if s.buf != nil {
+		sysFree(s.buf, s.cap*sys.PtrSize, &memstats.gc_sys)
+	}
+	*s = addrSet{}
+}
+
+// concurrentSweep is 1 if the heap is swept concurrently with the
+// program after each collection, and 0 if each collection sweeps
+// the whole heap before it restarts the world.
+// See SetConcurrentSweep.
+var concurrentSweep uint32 = 1
+
+// SetConcurrentSweep enables or disables concurrent sweeping and
+// returns the previous setting. It is enabled by default.
+//
+// After marking, the collector sweeps the heap to free unmarked objects.
+// Normally it does so concurrently with the program, in the background
+// and as allocation needs more memory, so that freed memory shows up in
+// MemStats gradually. With concurrent sweeping disabled, each collection
+// sweeps the whole heap before restarting the world, so the heap has
+// settled as soon as the collection ends. This lengthens GC pauses and
+// is intended for tests and measurements that examine the heap right
+// after a collection. GC always sweeps synchronously.
+func SetConcurrentSweep(enable bool) bool {
+	var v uint32
+	if enable {
+		v = 1
+	}
+	return atomic.Xchg(&concurrentSweep, v) != 0
+}
+
+// ConcurrentSweep reports whether concurrent sweeping is enabled.
+// See SetConcurrentSweep.
+func ConcurrentSweep() bool {
+	return atomic.Load(&concurrentSweep) != 0
+}
+
+// gcTriggerHolds is the number of unreleased AllocationBarrier tokens.
+// While it is nonzero, gcShouldStart reports false. Accessed atomically.
+var gcTriggerHolds uint32
+
+// An AllocationBarrierToken keeps garbage collections from starting
+// automatically until it is released. See AllocationBarrier.
+type AllocationBarrierToken struct {
+	released uint32
+}
+
+// AllocationBarrier keeps the garbage collector from starting a new
+// cycle on its own, as it normally does when the heap grows or when
+// no collection has run for a while, until Release is called on the
+// returned token. Allocation continues as usual in the meantime, and
+// the heap grows without bound. An explicit call to GC still runs a
+// collection. A cycle that is already running when AllocationBarrier
+// is called is not stopped; calling GC first ensures there is none.
+//
+// AllocationBarrier is meant for tests of code whose behavior depends
+// on when collections happen. Tokens nest: automatic collections
+// resume once every token has been released.
+func AllocationBarrier() *AllocationBarrierToken {
+	atomic.Xadd(&gcTriggerHolds, 1)
+	return &AllocationBarrierToken{}
+}
+
+// Release ends the barrier created by the AllocationBarrier call that
+// returned t. Calls after the first have no effect.
+func (t *AllocationBarrierToken) Release() {
+	if atomic.Cas(&t.released, 0, 1) {
+		atomic.Xadd(&gcTriggerHolds, -1)
+	}
+}
+
+// gcSuppressUntil is the deadline set by WithGCSuppressed, as a Unix
+// time in nanoseconds, or 0 if there is none. Accessed atomically.
+var gcSuppressUntil uint64
+
+// WithGCSuppressed keeps the garbage collector from starting a new
+// cycle on its own until untilNanos, a Unix time in nanoseconds such as
+// time.Now().Add(50*time.Millisecond).UnixNano(), so that a latency-
+// critical piece of work, such as handling a request, is not slowed by
+// a collection. A cycle that is already running is not stopped, and an
+// explicit call to GC still runs a collection.
+//
+// To keep the heap from growing without bound, suppression gives way
+// once the live heap reaches twice the size at which a collection would
+// normally start (see debug.SetGCPercent), and a collection starts as
+// usual. When the deadline passes, collections resume; if the heap has
+// grown past the normal trigger in the meantime, the next allocation
+// that needs more memory starts one at once.
+//
+// The deadline only moves forward: if goroutines handling overlapping
+// requests each call WithGCSuppressed, collections are suppressed until
+// the latest of their deadlines. A deadline in the past has no effect.
+func WithGCSuppressed(untilNanos int64) {
+	for {
+		old := atomic.Load64(&gcSuppressUntil)
+		if untilNanos <= int64(old) || atomic.Cas64(&gcSuppressUntil, old, uint64(untilNanos)) {
+			return
+		}
+	}
+}
+
+// gcSuppressed reports whether WithGCSuppressed currently keeps an
+// automatic collection from starting.
+func gcSuppressed() bool {
+	until := atomic.Load64(&gcSuppressUntil)
+	if until == 0 {
+		return false
+	}
+	if int64(until) <= unixnanotime() {
+		atomic.Cas64(&gcSuppressUntil, until, 0)
+		return false
+	}
+	return memstats.heap_live < 2*memstats.next_gc
+}
+
+// gcTriggerFunc points to the function set by SetGCTriggerFunc, or is
+// nil if there is none. Accessed atomically.
+var gcTriggerFunc unsafe.Pointer // *func(heapAlloc, nextGC uintptr) bool
+
+// SetGCTriggerFunc replaces the test that decides when the heap has
+// grown enough to start a garbage collection. Normally a cycle starts
+// once the heap reaches the goal set by debug.SetGCPercent; with fn set,
+// the allocator instead calls fn with the number of bytes of live heap
+// and that goal, and starts a cycle in the background whenever fn
+// returns true. A nil fn restores the normal test. The other conditions
+// still apply: no cycle starts while one is running, while collection
+// is disabled by debug.SetGCPercent(-1), or while it is suppressed by
+// WithGCSuppressed, and the collector paces its work as usual once a
+// cycle has started.
+//
+// fn is called from within the allocator, by whichever goroutine
+// allocates, possibly by several goroutines at once, and many times
+// during a busy phase of allocation. It must therefore be fast and
+// safe for concurrent use, and it must not allocate memory from the
+// heap, block, or call into the garbage collector, such as by calling
+// GC or SetGCTriggerFunc, since doing so reenters the allocator at the
+// point where it is deciding whether to collect.
+func SetGCTriggerFunc(fn func(heapAlloc, nextGC uintptr) bool) {
+	var p *func(heapAlloc, nextGC uintptr) bool
+	if fn != nil {
+		p = new(func(heapAlloc, nextGC uintptr) bool)
+		*p = fn
+	}
+	atomicstorep(unsafe.Pointer(&gcTriggerFunc), unsafe.Pointer(p))
+}
+
+// gcHeapTriggered reports whether the heap has grown enough to start
+// a cycle, according to the function set by SetGCTriggerFunc if any.
+func gcHeapTriggered() bool {
+	if p := atomic.Loadp(unsafe.Pointer(&gcTriggerFunc)); p != nil {
+		fn := *(*func(heapAlloc, nextGC uintptr) bool)(p)
+		return fn(uintptr(memstats.heap_live), uintptr(memstats.next_gc))
+	}
+	return memstats.heap_live >= memstats.next_gc
+}
+
+// GCTriggerStats reports how many times an allocation has checked
+// whether the heap has reached the point where a collection should
+// start, and how many of those checks found that it had. Allocations
+// check only when they obtain a new span, not on every call. A check
+// that fires starts a collection unless another allocation has just
+// started one, so many more fires than collections mean that many
+// goroutines are reaching the trigger at once. GCTriggerStats stops the
+// world to collect the per-P counts.
+func GCTriggerStats() (checks, fired uint64) {
+	stopTheWorld("gc trigger stats")
+	systemstack(func() {
+		lock(&mheap_.lock)
+		cachestats()
+		checks = memstats.ntrigcheck
+		fired = memstats.ntrigfire
+		unlock(&mheap_.lock)
+	})
+	startTheWorld()
+	return
+}
+
 // gcMode indicates how concurrent a GC cycle should be.
 type gcMode int
 
@@ -872,7 +1454,7 @@ const (
 // If forceTrigger is true, it ignores the current heap size, but
 // checks all other conditions. In general this should be false.
 func gcShouldStart(forceTrigger bool) bool {
-	return gcphase == _GCoff && (forceTrigger || memstats.heap_live >= memstats.next_gc) && memstats.enablegc && panicking == 0 && gcpercent >= 0
+	return gcphase == _GCoff && (forceTrigger || gcHeapTriggered()) && memstats.enablegc && panicking == 0 && gcpercent >= 0 && atomic.Load(&gcTriggerHolds) == 0 && !gcSuppressed()
 }
 
 // gcStart transitions the GC from _GCoff to _GCmark (if mode ==
@@ -1021,11 +1603,15 @@ func gcStart(mode gcMode, forceTrigger bool) {
 		systemstack(startTheWorldWithSema)
 		now = nanotime()
 		work.pauseNS += now - work.pauseStart
+		recordPause(now - work.pauseStart)
 		work.tMark = now
 	} else {
 		t := nanotime()
 		work.tMark, work.tMarkTerm = t, t
 		work.heapGoal = work.heap0
+		// startCycle is not run, but the scan work must
+		// count this cycle only.
+		gcController.scanWork = 0
 
 		// Perform mark termination. This will restart the world.
 		gcMarkTermination()
@@ -1197,6 +1783,9 @@ func gcMarkTermination() {
 			gcMark(startTime)
 			clearCheckmarks()
 		}
+		if work.markOnlyg == gp {
+			gcKeepUnreachable()
+		}
 
 		// marking is complete so we can turn the write barrier off
 		setGCPhase(_GCoff)
@@ -1237,8 +1826,10 @@ func gcMarkTermination() {
 	// Update timing memstats
 	now, unixNow := nanotime(), unixnanotime()
 	work.pauseNS += now - work.pauseStart
+	recordPause(now - work.pauseStart)
 	work.tEnd = now
 	atomic.Store64(&memstats.last_gc, uint64(unixNow)) // must be Unix time to make sense to user
+	atomic.Store64(&gcLastEnd, uint64(now))
 	memstats.pause_ns[memstats.numgc%uint32(len(memstats.pause_ns))] = uint64(work.pauseNS)
 	memstats.pause_end[memstats.numgc%uint32(len(memstats.pause_end))] = uint64(unixNow)
 	memstats.pause_total_ns += uint64(work.pauseNS)
@@ -1256,14 +1847,37 @@ func gcMarkTermination() {
 	totalCpu := sched.totaltime + (now-sched.procresizetime)*int64(gomaxprocs)
 	memstats.gc_cpu_fraction = float64(work.totaltime) / float64(totalCpu)
 
+	recordHeapGoal(HeapGoalSample{
+		NumGC:   memstats.numgc + 1,
+		Trigger: work.heap0,
+		Goal:    work.heapGoal,
+		Peak:    work.heap1,
+		Marked:  work.heap2,
+		Forced:  work.mode != gcBackgroundMode,
+	})
+
 	memstats.numgc++
 
+	if work.resultg == gp {
+		// A forced cycle has swept everything by now.
+		r := &work.result
+		r.Live = work.heap2
+		if work.heap1 > work.heap2 {
+			r.Reclaimed = work.heap1 - work.heap2
+		}
+		r.PauseNs = uint64(work.pauseNS)
+		r.FreedObjects = uint64(sweep.nfreed)
+	}
+
 	// Reset sweep state.
 	sweep.nbgsweep = 0
 	sweep.npausesweep = 0
 
 	systemstack(startTheWorldWithSema)
 
+	gcWakeBackpressureWaiters()
+	heapWatermarkCheck()
+
 	// Free stack spans. This must be done between GC cycles.
 	systemstack(freeStackSpans)
 
@@ -1321,7 +1935,7 @@ func gcMarkTermination() {
 	mp = nil
 
 	// now that gc is done, kick off finalizer thread if needed
-	if !concurrentSweep {
+	if atomic.Load(&concurrentSweep) == 0 {
 		// give the queued finalizers, if any, a chance to run
 		Gosched()
 	}
@@ -1448,7 +2062,15 @@ func gcBgMarkWorker(_p_ *p) {
 		default:
 			throw("gcBgMarkWorker: unexpected gcMarkWorkerMode")
 		case gcMarkWorkerDedicatedMode:
+			n := atomic.Xadd(&gcController.dedicatedMarkWorkersRunning, 1)
+			for {
+				peak := atomic.Load(&gcController.dedicatedMarkWorkersPeak)
+				if n <= peak || atomic.Cas(&gcController.dedicatedMarkWorkersPeak, peak, n) {
+					break
+				}
+			}
 			gcDrain(&_p_.gcw, gcDrainNoBlock|gcDrainFlushBgCredit)
+			atomic.Xadd(&gcController.dedicatedMarkWorkersRunning, -1)
 		case gcMarkWorkerFractionalMode, gcMarkWorkerIdleMode:
 			gcDrain(&_p_.gcw, gcDrainUntilPreempt|gcDrainFlushBgCredit)
 		}
@@ -1632,6 +2254,7 @@ func gcMark(start_time int64) {
 	memstats.heap_live = work.bytesMarked
 	memstats.heap_marked = work.bytesMarked
 	memstats.heap_scan = uint64(gcController.scanWork)
+	atomic.Storeuintptr(&gcLastScanBytes, uintptr(gcController.scanWork))
 
 	minNextGC := memstats.heap_live + sweepMinHeapDistance*uint64(gcpercent)/100
 	if memstats.next_gc < minNextGC {
@@ -1664,9 +2287,10 @@ func gcSweep(mode gcMode) {
 	mheap_.sweepgen += 2
 	mheap_.sweepdone = 0
 	sweep.spanidx = 0
+	sweepStatsFlush()
 	unlock(&mheap_.lock)
 
-	if !_ConcurrentSweep || mode == gcForceBlockMode {
+	if atomic.Load(&concurrentSweep) == 0 || mode == gcForceBlockMode {
 		// Special case synchronous sweep.
 		// Record that no proportional sweeping has to happen.
 		lock(&mheap_.lock)
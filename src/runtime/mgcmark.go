@@ -308,6 +308,12 @@ func markrootSpans(gcw *gcWork, shard int) {
 	// collected heap) are roots. In practice, this means the fn
 	// field must be scanned.
 	//
+	// Survival callback specials are roots in the same way, but
+	// they do not retain anything reachable from the object.
+	//
+	// External reference specials (AllocExternal) are roots for
+	// the object itself: it is marked, and so scanned.
+	//
 	// TODO(austin): There are several ideas for making this more
 	// efficient in issue #11485.
 
@@ -353,6 +359,19 @@ func markrootSpans(gcw *gcWork, shard int) {
 		lock(&s.speciallock)
 
 		for sp := s.specials; sp != nil; sp = sp.next {
+			if sp.kind == _KindSpecialSurvival {
+				// Retain the callback, but not the object.
+				sps := (*specialsurvival)(unsafe.Pointer(sp))
+				scanblock(uintptr(unsafe.Pointer(&sps.fn)), sys.PtrSize, &oneptrmask[0], gcw)
+				continue
+			}
+			if sp.kind == _KindSpecialExternal {
+				p := s.base() + uintptr(sp.offset)
+				if obj, hbits, span, objIndex := heapBitsForObject(p, 0, 0); obj != 0 {
+					greyobject(obj, 0, 0, hbits, span, gcw, objIndex)
+				}
+				continue
+			}
 			if sp.kind != _KindSpecialFinalizer {
 				continue
 			}
@@ -1126,6 +1145,9 @@ func scanobject(b uintptr, gcw *gcWork) {
 	if n == 0 {
 		throw("scanobject n == 0")
 	}
+	if s.lazybits != 0 {
+		s.ensureHeapBits()
+	}
 
 	var i uintptr
 	for i = 0; i < n; i += sys.PtrSize {
@@ -1218,8 +1240,10 @@ func greyobject(obj, base, off uintptr, hbits heapBits, span *mspan, gcw *gcWork
 		// mbits.setMarked() // Avoid extra call overhead with manual inlining.
 		atomic.Or8(mbits.bytep, mbits.mask)
 		// If this is a noscan object, fast-track it to black
-		// instead of greying it.
-		if !hbits.hasPointers(span.elemsize) {
+		// instead of greying it. A large object allocated with
+		// flagLazyBitmap may have no bitmap yet; scanobject
+		// writes it.
+		if span.lazybits == 0 && !hbits.hasPointers(span.elemsize) {
 			gcw.bytesMarked += uint64(span.elemsize)
 			return
 		}
@@ -1294,6 +1318,31 @@ func gcmarknewobject(obj, size, scanSize uintptr) {
 	gcw.scanWork += int64(scanSize)
 }
 
+// gcgraynewobject marks a newly allocated object gray, queuing it to
+// be scanned like an object found by the collector, instead of black.
+// It is used only for flagGrayOnAlloc, which the grayalloc build tag
+// enables for experiments with allocation color: since a new object
+// holds no pointers, scanning it finds nothing, but the collector pays
+// for the scan.
+//
+//go:nowritebarrier
+func gcgraynewobject(obj, size uintptr, noscan bool) {
+	if useCheckmark && !gcBlackenPromptly { // The world should be stopped so this should not happen.
+		throw("gcgraynewobject called while doing checkmark")
+	}
+	markBitsForAddr(obj).setMarked()
+	gcw := &getg().m.p.ptr().gcw
+	if noscan {
+		// Like greyobject, fast-track noscan objects to black.
+		gcw.bytesMarked += uint64(size)
+		return
+	}
+	gcw.put(obj)
+	if gcBlackenPromptly {
+		gcw.dispose()
+	}
+}
+
 // Checkmarking
 
 // To help debug the concurrent GC we remark with the world
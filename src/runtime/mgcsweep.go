@@ -24,6 +24,53 @@ type sweepdata struct {
 
 	nbgsweep    uint32
 	npausesweep uint32
+
+	nfreed uintptr // objects freed this cycle; updated atomically
+
+	nspans     uintptr // spans swept this cycle; updated atomically
+	nbytes     uintptr // bytes freed this cycle; updated atomically
+	lastStats  SweepStat
+	totalStats SweepStat
+}
+
+// A SweepStat records the work done by the sweeper.
+type SweepStat struct {
+	Spans   uint64 // spans swept
+	Objects uint64 // unreachable objects freed
+	Bytes   uint64 // bytes freed
+}
+
+// SweepStats returns the work done by the sweeper in the most recently
+// completed sweep cycle and the total over the life of the program.
+// A sweep cycle begins at the end of a garbage collection and is
+// considered complete when the next collection begins; the sweep in
+// progress is not included in either result.
+func SweepStats() (last, total SweepStat) {
+	systemstack(func() {
+		lock(&mheap_.lock)
+		last = sweep.lastStats
+		total = sweep.totalStats
+		unlock(&mheap_.lock)
+	})
+	return
+}
+
+// sweepStatsFlush records the counts of the sweep cycle that just
+// ended and resets them for the next cycle.
+// mheap_.lock must be held.
+func sweepStatsFlush() {
+	last := SweepStat{
+		Spans:   uint64(sweep.nspans),
+		Objects: uint64(sweep.nfreed),
+		Bytes:   uint64(sweep.nbytes),
+	}
+	sweep.lastStats = last
+	sweep.totalStats.Spans += last.Spans
+	sweep.totalStats.Objects += last.Objects
+	sweep.totalStats.Bytes += last.Bytes
+	sweep.nspans = 0
+	sweep.nfreed = 0
+	sweep.nbytes = 0
 }
 
 //go:nowritebarrier
@@ -131,6 +178,41 @@ func gosweepdone() bool {
 	return mheap_.sweepdone != 0
 }
 
+// ForceSweepComplete sweeps whatever part of the heap the background
+// sweeper has not yet swept since the last garbage collection, and
+// returns once every span has been swept, including those being swept
+// by other goroutines. Heap statistics read afterwards, such as
+// MemStats.HeapAlloc and MemStats.HeapObjects, no longer include the
+// unreachable objects found by that collection. A collection that
+// starts in the meantime begins a new sweep, which ForceSweepComplete
+// does not wait for.
+func ForceSweepComplete() {
+	sg := mheap_.sweepgen
+	for gosweepone() != ^uintptr(0) {
+	}
+	// Spans claimed by other sweepers have sweepgen sg-1
+	// until they are swept.
+	for {
+		busy := false
+		systemstack(func() {
+			lock(&mheap_.lock)
+			if mheap_.sweepgen == sg {
+				for _, s := range h_allspans {
+					if s.state == mSpanInUse && atomic.Load(&s.sweepgen) == sg-1 {
+						busy = true
+						break
+					}
+				}
+			}
+			unlock(&mheap_.lock)
+		})
+		if !busy {
+			return
+		}
+		osyield()
+	}
+}
+
 // Returns only when span s has been swept.
 //go:nowritebarrier
 func (s *mspan) ensureSwept() {
@@ -181,6 +263,7 @@ func (s *mspan) sweep(preserve bool) bool {
 	}
 
 	atomic.Xadd64(&mheap_.pagesSwept, int64(s.npages))
+	atomic.Xadduintptr(&sweep.nspans, 1)
 
 	cl := s.sizeclass
 	size := s.elemsize
@@ -244,6 +327,13 @@ func (s *mspan) sweep(preserve bool) bool {
 					special = *specialp
 				}
 			}
+		} else if special.kind == _KindSpecialSurvival && (*specialsurvival)(unsafe.Pointer(special)).survived() {
+			// object is still live and has survived long enough:
+			// splice out the record and queue its callback.
+			y := special
+			special = special.next
+			*specialp = special
+			queuesurvival((*specialsurvival)(unsafe.Pointer(y)), unsafe.Pointer(p))
 		} else {
 			// object is still live: keep special record
 			specialp = &special.next
@@ -287,6 +377,10 @@ func (s *mspan) sweep(preserve bool) bool {
 		throw("sweep increased allocation count")
 	}
 
+	if nfreed > 0 {
+		atomic.Xadduintptr(&sweep.nfreed, uintptr(nfreed))
+		atomic.Xadduintptr(&sweep.nbytes, uintptr(nfreed)*size)
+	}
 	s.allocCount = nalloc
 	wasempty := s.nextFreeIndex() == s.nelems
 	s.freeindex = 0 // reset allocation index to start of span.
@@ -319,7 +413,7 @@ func (s *mspan) sweep(preserve bool) bool {
 
 	if nfreed > 0 && cl != 0 {
 		c.local_nsmallfree[cl] += uintptr(nfreed)
-		res = mheap_.central[cl].mcentral.freeSpan(s, preserve, wasempty)
+		res = mheap_.centralFor(int32(cl), s.cold).freeSpan(s, preserve, wasempty)
 		// MCentral_FreeSpan updates sweepgen
 	} else if freeToHeap {
 		// Free large span to heap
@@ -338,6 +432,10 @@ func (s *mspan) sweep(preserve bool) bool {
 		// have mysterious crashes due to confused memory reuse.
 		// It should be possible to switch back to SysFree if we also
 		// implement and then call some kind of MHeap_DeleteSpan.
+
+		// A large object allocated with flagLazyBitmap that died
+		// before any GC found it reachable never gets its bitmap.
+		s.lazybits = 0
 		if debug.efence > 0 {
 			s.limit = 0 // prevent mlookup from finding this span
 			sysFault(unsafe.Pointer(s.base()), size)
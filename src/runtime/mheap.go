@@ -45,6 +45,17 @@ type mheap struct {
 	nlargefree uint64                  // number of frees for large objects (>maxsmallsize)
 	nsmallfree [_NumSizeClasses]uint64 // number of frees for small objects (<=maxsmallsize)
 
+	// number of allocations in each size class, 0 being large objects
+	nclassalloc [_NumSizeClasses]uint64
+
+	// number of allocations in each bucket of the allocation size
+	// histogram since ResetAllocSizeHistogram
+	sizehist [allocSizeHistBuckets]uint64
+
+	// bytes released so far by a scavenge pass that is being
+	// continued because of SetScavengePace; protected by lock.
+	scavenged uintptr
+
 	// range of addresses we might see in the heap
 	bitmap         uintptr // Points to one byte past the end of the bitmap
 	bitmap_mapped  uintptr
@@ -62,10 +73,22 @@ type mheap struct {
 		pad      [sys.CacheLineSize]byte
 	}
 
+	// coldcentral holds the central free lists for spans of
+	// objects allocated by AllocCold, kept apart from central so
+	// that cold objects do not share spans with other objects.
+	coldcentral [_NumSizeClasses]struct {
+		mcentral mcentral
+		pad      [sys.CacheLineSize]byte
+	}
+
 	spanalloc             fixalloc // allocator for span*
 	cachealloc            fixalloc // allocator for mcache*
 	specialfinalizeralloc fixalloc // allocator for specialfinalizer*
 	specialprofilealloc   fixalloc // allocator for specialprofile*
+	specialsurvivalalloc  fixalloc // allocator for specialsurvival*
+	specialpoolalloc      fixalloc // allocator for specialpool*
+	specialtypealloc      fixalloc // allocator for specialtype*
+	specialexternalalloc  fixalloc // allocator for external reference specials
 	speciallock           mutex    // lock for special record allocators.
 }
 
@@ -187,6 +210,9 @@ type mspan struct {
 	incache     bool     // being used by an mcache
 	state       uint8    // mspaninuse etc
 	needzero    uint8    // needs to be zeroed before allocation
+	cold        bool     // holds objects allocated by AllocCold
+	dma         bool     // holds an object allocated by AllocDMA; released to the OS when freed
+	lifetime    uint8    // Lifetime of the object allocated by AllocWithLifetime, or of free pages
 	divShift    uint8    // for divide by elemsize - divMagic.shift
 	divShift2   uint8    // for divide by elemsize - divMagic.shift2
 	elemsize    uintptr  // computed from sizeclass or from npages
@@ -196,6 +222,22 @@ type mspan struct {
 	speciallock mutex    // guards specials list
 	specials    *special // linked list of special records sorted by offset.
 	baseMask    uintptr  // if non-0, elemsize is a power of 2, & this will get object allocation base
+
+	// For a large object allocated with flagLazyBitmap, lazybits
+	// is lazyBitsPending until the object's heap bitmap has been
+	// written from lazytype and lazysize. See ensureHeapBits.
+	lazybits uint32
+	lazysize uintptr
+	lazytype *_type
+}
+
+// centralFor returns the central free list for spans of the given
+// size class that hold cold objects, if cold is set, or other objects.
+func (h *mheap) centralFor(sizeclass int32, cold bool) *mcentral {
+	if cold {
+		return &h.coldcentral[sizeclass].mcentral
+	}
+	return &h.central[sizeclass].mcentral
 }
 
 func (s *mspan) base() uintptr {
@@ -364,6 +406,10 @@ func (h *mheap) init(spans_size uintptr) {
 	h.cachealloc.init(unsafe.Sizeof(mcache{}), nil, nil, &memstats.mcache_sys)
 	h.specialfinalizeralloc.init(unsafe.Sizeof(specialfinalizer{}), nil, nil, &memstats.other_sys)
 	h.specialprofilealloc.init(unsafe.Sizeof(specialprofile{}), nil, nil, &memstats.other_sys)
+	h.specialsurvivalalloc.init(unsafe.Sizeof(specialsurvival{}), nil, nil, &memstats.other_sys)
+	h.specialpoolalloc.init(unsafe.Sizeof(specialpool{}), nil, nil, &memstats.other_sys)
+	h.specialtypealloc.init(unsafe.Sizeof(specialtype{}), nil, nil, &memstats.other_sys)
+	h.specialexternalalloc.init(unsafe.Sizeof(special{}), nil, nil, &memstats.other_sys)
 
 	// h->mapcache needs no init
 	for i := range h.free {
@@ -374,7 +420,8 @@ func (h *mheap) init(spans_size uintptr) {
 	h.freelarge.init()
 	h.busylarge.init()
 	for i := range h.central {
-		h.central[i].mcentral.init(int32(i))
+		h.central[i].mcentral.init(int32(i), false)
+		h.coldcentral[i].mcentral.init(int32(i), true)
 	}
 
 	sp := (*slice)(unsafe.Pointer(&h_spans))
@@ -574,12 +621,15 @@ func (h *mheap) alloc(npage uintptr, sizeclass int32, large bool, needzero bool)
 		s = h.alloc_m(npage, sizeclass, large)
 	})
 
-	if s != nil {
-		if needzero && s.needzero != 0 {
+	if s != nil && needzero {
+		if s.needzero != 0 {
 			memclr(unsafe.Pointer(s.base()), s.npages<<_PageShift)
 		}
 		s.needzero = 0
 	}
+	// If the caller did not ask for zeroed memory, s.needzero
+	// tells it whether the memory is dirty. It is set again when
+	// s is freed.
 	return s
 }
 
@@ -661,12 +711,14 @@ HaveSpan:
 		h_spans[p] = t
 		h_spans[p+t.npages-1] = t
 		t.needzero = s.needzero
+		t.lifetime = s.lifetime
 		s.state = _MSpanStack // prevent coalescing with s
 		t.state = _MSpanStack
 		h.freeSpanLocked(t, false, false, s.unusedsince)
 		s.state = _MSpanFree
 	}
 	s.unusedsince = 0
+	s.lifetime = 0
 
 	p := (s.base() - h.arena_start) >> _PageShift
 	for n := uintptr(0); n < npage; n++ {
@@ -846,15 +898,21 @@ func (h *mheap) freeSpanLocked(s *mspan, acctinuse, acctidle bool, unusedsince i
 		s.unusedsince = nanotime()
 	}
 	s.npreleased = 0
+	if s.dma {
+		s.dma = false
+		s.release()
+	}
 
-	// Coalesce with earlier, later spans.
+	// Coalesce with earlier, later spans. Persistent pages are
+	// kept apart, so that the scavenger keeps only those.
+	persistent := s.lifetime == uint8(LifetimePersistent)
 	p := (s.base() - h.arena_start) >> _PageShift
 	if p > 0 {
 		t := h_spans[p-1]
-		if t != nil && t.state == _MSpanFree {
+		if t != nil && t.state == _MSpanFree && (t.lifetime == uint8(LifetimePersistent)) == persistent {
 			s.startAddr = t.startAddr
 			s.npages += t.npages
-			s.npreleased = t.npreleased // absorb released pages
+			s.npreleased += t.npreleased // absorb released pages
 			s.needzero |= t.needzero
 			p -= t.npages
 			h_spans[p] = s
@@ -865,7 +923,7 @@ func (h *mheap) freeSpanLocked(s *mspan, acctinuse, acctidle bool, unusedsince i
 	}
 	if (p+s.npages)*sys.PtrSize < h.spans_mapped {
 		t := h_spans[p+s.npages]
-		if t != nil && t.state == _MSpanFree {
+		if t != nil && t.state == _MSpanFree && (t.lifetime == uint8(LifetimePersistent)) == persistent {
 			s.npages += t.npages
 			s.npreleased += t.npreleased
 			s.needzero |= t.needzero
@@ -894,51 +952,98 @@ func (h *mheap) busyList(npages uintptr) *mSpanList {
 	return &h.busylarge
 }
 
-func scavengelist(list *mSpanList, now, limit uint64) uintptr {
+func scavengelist(list *mSpanList, now, limit uint64, max uintptr) uintptr {
 	if list.isEmpty() {
 		return 0
 	}
 
 	var sumreleased uintptr
-	for s := list.first; s != nil; s = s.next {
-		if (now-uint64(s.unusedsince)) > limit && s.npreleased != s.npages {
-			start := s.base()
-			end := start + s.npages<<_PageShift
-			if sys.PhysPageSize > _PageSize {
-				// We can only release pages in
-				// PhysPageSize blocks, so round start
-				// and end in. (Otherwise, madvise
-				// will round them *out* and release
-				// more memory than we want.)
-				start = (start + sys.PhysPageSize - 1) &^ (sys.PhysPageSize - 1)
-				end &^= sys.PhysPageSize - 1
-				if start == end {
-					continue
-				}
+	for s := list.first; s != nil && sumreleased < max; s = s.next {
+		if s.npreleased == s.npages {
+			continue
+		}
+		// Ephemeral pages go at once and persistent pages only
+		// when everything unused is released, as by FreeOSMemory.
+		switch s.lifetime {
+		case uint8(LifetimeEphemeral):
+		case uint8(LifetimePersistent):
+			if limit != 0 {
+				continue
 			}
-			len := end - start
-
-			released := len - (s.npreleased << _PageShift)
-			if sys.PhysPageSize > _PageSize && released == 0 {
+		default:
+			if now-uint64(s.unusedsince) <= limit {
 				continue
 			}
-			memstats.heap_released += uint64(released)
-			sumreleased += released
-			s.npreleased = len >> _PageShift
-			sysUnused(unsafe.Pointer(start), len)
 		}
+		sumreleased += s.release()
 	}
 	return sumreleased
 }
 
-func (h *mheap) scavenge(k int32, now, limit uint64) {
+// release returns the pages of the free span s to the operating system
+// and returns the number of bytes newly released. h.lock must be held.
+func (s *mspan) release() uintptr {
+	start := s.base()
+	end := start + s.npages<<_PageShift
+	if sys.PhysPageSize > _PageSize {
+		// We can only release pages in
+		// PhysPageSize blocks, so round start
+		// and end in. (Otherwise, madvise
+		// will round them *out* and release
+		// more memory than we want.)
+		start = (start + sys.PhysPageSize - 1) &^ (sys.PhysPageSize - 1)
+		end &^= sys.PhysPageSize - 1
+		if start == end {
+			return 0
+		}
+	}
+	len := end - start
+
+	released := len - (s.npreleased << _PageShift)
+	if sys.PhysPageSize > _PageSize && released == 0 {
+		return 0
+	}
+	memstats.heap_released += uint64(released)
+	s.npreleased = len >> _PageShift
+	sysUnused(unsafe.Pointer(start), len)
+	return released
+}
+
+// scavenge releases to the operating system the free spans that have
+// been unused for longer than limit, stopping once it has released max
+// bytes or once the heap memory not yet released has dropped to floor
+// bytes. It reports whether it stopped early because of max, in which
+// case the caller should call it again with the same k to continue the
+// pass.
+func (h *mheap) scavenge(k int32, now, limit uint64, max, floor uintptr) bool {
 	lock(&h.lock)
+	floored := false
+	if floor > 0 {
+		var room uintptr
+		if committed := uintptr(memstats.heap_sys - memstats.heap_released); committed > floor {
+			room = committed - floor
+		}
+		if room < max {
+			max, floored = room, true
+		}
+	}
 	var sumreleased uintptr
-	for i := 0; i < len(h.free); i++ {
-		sumreleased += scavengelist(&h.free[i], now, limit)
+	for i := 0; i < len(h.free) && sumreleased < max; i++ {
+		sumreleased += scavengelist(&h.free[i], now, limit, max-sumreleased)
+	}
+	if sumreleased < max {
+		sumreleased += scavengelist(&h.freelarge, now, limit, max-sumreleased)
+	}
+	more := sumreleased >= max && !floored
+	h.scavenged += sumreleased
+	sumreleased = h.scavenged
+	if !more {
+		h.scavenged = 0
 	}
-	sumreleased += scavengelist(&h.freelarge, now, limit)
 	unlock(&h.lock)
+	if more {
+		return true
+	}
 
 	if debug.gctrace > 0 {
 		if sumreleased > 0 {
@@ -948,12 +1053,81 @@ func (h *mheap) scavenge(k int32, now, limit uint64) {
 		// But we can't call ReadMemStats on g0 holding locks.
 		print("scvg", k, ": inuse: ", memstats.heap_inuse>>20, ", idle: ", memstats.heap_idle>>20, ", sys: ", memstats.heap_sys>>20, ", released: ", memstats.heap_released>>20, ", consumed: ", (memstats.heap_sys-memstats.heap_released)>>20, " (MB)\n")
 	}
+	return false
+}
+
+// scavengePace is the background scavenger's rate limit in bytes per
+// second, or 0 for no limit. It is accessed atomically.
+var scavengePace uint64
+
+// scavengePaceTick is how often, in nanoseconds, sysmon continues a
+// scavenge pass that was cut short by scavengePace.
+const scavengePaceTick = 10 * 1e6
+
+// scavengeBudget returns the number of bytes the background scavenger
+// may release before it must wait scavengePaceTick.
+func scavengeBudget() uintptr {
+	pace := atomic.Load64(&scavengePace)
+	if pace == 0 {
+		return ^uintptr(0)
+	}
+	b := pace / (1e9 / scavengePaceTick)
+	if b == 0 {
+		// Always make progress, one span at a time.
+		b = 1
+	}
+	if b > uint64(^uintptr(0)) {
+		return ^uintptr(0)
+	}
+	return uintptr(b)
+}
+
+// SetScavengePace limits the rate at which the runtime's background
+// scavenger returns unused heap memory to the operating system to
+// about bytesPerSec bytes per second, and returns the previous limit.
+// A limit of 0, the default, lets each pass of the scavenger release
+// everything it finds at once. A low limit spreads the system calls
+// that release memory over time, so that they compete less with the
+// program for CPU, at the cost of holding on to unused memory longer.
+// The limit does not apply to debug.FreeOSMemory.
+func SetScavengePace(bytesPerSec uint64) uint64 {
+	return atomic.Xchg64(&scavengePace, bytesPerSec)
+}
+
+// ScavengePace returns the background scavenger's rate limit set by
+// SetScavengePace, in bytes per second, or 0 if it is unlimited.
+func ScavengePace() uint64 {
+	return atomic.Load64(&scavengePace)
+}
+
+// minHeap is the amount of heap memory the background scavenger keeps
+// from the operating system. It is accessed atomically.
+var minHeap uintptr
+
+// SetMinHeap sets a floor on the heap memory that the runtime's
+// background scavenger keeps, and returns the previous floor. The
+// scavenger normally returns heap memory that has been unused for a few
+// minutes to the operating system, and the program takes page faults
+// when it reuses that memory. With a floor, the scavenger stops
+// releasing memory once the heap memory obtained and not released is
+// down to about the given number of bytes, keeping that memory resident
+// for later bursts of allocation at the cost of a higher RSS. The default floor of 0
+// releases all unused memory. The floor does not apply to
+// debug.FreeOSMemory, and it does not make the heap grow: it only limits
+// what is given back.
+func SetMinHeap(bytes uintptr) uintptr {
+	return atomic.Xchguintptr(&minHeap, bytes)
+}
+
+// MinHeap returns the floor set by SetMinHeap, in bytes.
+func MinHeap() uintptr {
+	return atomic.Loaduintptr(&minHeap)
 }
 
 //go:linkname runtime_debug_freeOSMemory runtime/debug.freeOSMemory
 func runtime_debug_freeOSMemory() {
 	gcStart(gcForceBlockMode, false)
-	systemstack(func() { mheap_.scavenge(-1, ^uint64(0), 0) })
+	systemstack(func() { mheap_.scavenge(-1, ^uint64(0), 0, ^uintptr(0), 0) })
 }
 
 // Initialize a new span with the given start and npages.
@@ -976,6 +1150,9 @@ func (span *mspan) init(base uintptr, npages uintptr) {
 	span.freeindex = 0
 	span.allocBits = nil
 	span.gcmarkBits = nil
+	span.lazybits = 0
+	span.dma = false
+	span.lifetime = 0
 }
 
 func (span *mspan) inList() bool {
@@ -1041,6 +1218,10 @@ func (list *mSpanList) insertBack(span *mspan) {
 const (
 	_KindSpecialFinalizer = 1
 	_KindSpecialProfile   = 2
+	_KindSpecialSurvival  = 3
+	_KindSpecialPool      = 4
+	_KindSpecialType      = 5
+	_KindSpecialExternal  = 6
 	// Note: The finalizer special must be first because if we're freeing
 	// an object, a finalizer special will cause the freeing operation
 	// to abort, and we want to keep the other special records around
@@ -1143,6 +1324,31 @@ func removespecial(p unsafe.Pointer, kind uint8) *special {
 	return nil
 }
 
+// hasspecial reports whether there is a special record of the given
+// kind for exactly p, which must point into an allocated object.
+func hasspecial(p unsafe.Pointer, kind uint8) bool {
+	span := mheap_.lookupMaybe(p)
+	if span == nil {
+		throw("hasspecial on invalid pointer")
+	}
+
+	// Synchronize with the sweeper, as in removespecial.
+	mp := acquirem()
+	span.ensureSwept()
+	offset := uintptr(p) - span.base()
+	found := false
+	lock(&span.speciallock)
+	for s := span.specials; s != nil && uintptr(s.offset) <= offset; s = s.next {
+		if uintptr(s.offset) == offset && s.kind == kind {
+			found = true
+			break
+		}
+	}
+	unlock(&span.speciallock)
+	releasem(mp)
+	return found
+}
+
 // The described object has a finalizer set for it.
 type specialfinalizer struct {
 	special special
@@ -1150,10 +1356,12 @@ type specialfinalizer struct {
 	nret    uintptr
 	fint    *_type
 	ot      *ptrtype
+	argoff  uintptr // offset of the finalizer's argument from the special's address
 }
 
-// Adds a finalizer to the object p. Returns true if it succeeded.
-func addfinalizer(p unsafe.Pointer, f *funcval, nret uintptr, fint *_type, ot *ptrtype) bool {
+// Adds a finalizer to the object p, which is passed p+argoff. Returns
+// true if it succeeded.
+func addfinalizer(p unsafe.Pointer, argoff uintptr, f *funcval, nret uintptr, fint *_type, ot *ptrtype) bool {
 	lock(&mheap_.speciallock)
 	s := (*specialfinalizer)(mheap_.specialfinalizeralloc.alloc())
 	unlock(&mheap_.speciallock)
@@ -1162,6 +1370,7 @@ func addfinalizer(p unsafe.Pointer, f *funcval, nret uintptr, fint *_type, ot *p
 	s.nret = nret
 	s.fint = fint
 	s.ot = ot
+	s.argoff = argoff
 	if addspecial(p, &s.special) {
 		// This is responsible for maintaining the same
 		// GC-related invariants as markrootSpans in any
@@ -1182,6 +1391,7 @@ func addfinalizer(p unsafe.Pointer, f *funcval, nret uintptr, fint *_type, ot *p
 			}
 			releasem(mp)
 		}
+		atomic.Xadd64(&finstats.registered, 1)
 		return true
 	}
 
@@ -1221,13 +1431,163 @@ func setprofilebucket(p unsafe.Pointer, b *bucket) {
 	}
 }
 
+// The described object has a survival callback set for it.
+type specialsurvival struct {
+	special special
+	fn      *funcval // func(interface{})
+	fint    *_type   // interface{}
+	ot      *ptrtype
+	left    int // collections still to survive
+}
+
+// Adds a survival callback to the object p, replacing any existing one.
+func addsurvival(p unsafe.Pointer, n int, f *funcval, fint *_type, ot *ptrtype) {
+	removesurvival(p)
+	lock(&mheap_.speciallock)
+	s := (*specialsurvival)(mheap_.specialsurvivalalloc.alloc())
+	unlock(&mheap_.speciallock)
+	s.special.kind = _KindSpecialSurvival
+	s.fn = f
+	s.fint = fint
+	s.ot = ot
+	s.left = n
+	if !addspecial(p, &s.special) {
+		throw("addsurvival: survival callback already set")
+	}
+	// Maintain the invariant of markrootSpans, as addfinalizer does.
+	if gcphase != _GCoff {
+		mp := acquirem()
+		gcw := &mp.p.ptr().gcw
+		scanblock(uintptr(unsafe.Pointer(&s.fn)), sys.PtrSize, &oneptrmask[0], gcw)
+		if gcBlackenPromptly {
+			gcw.dispose()
+		}
+		releasem(mp)
+	}
+}
+
+// Removes the survival callback (if any) from the object p.
+func removesurvival(p unsafe.Pointer) {
+	s := (*specialsurvival)(unsafe.Pointer(removespecial(p, _KindSpecialSurvival)))
+	if s == nil {
+		return
+	}
+	lock(&mheap_.speciallock)
+	mheap_.specialsurvivalalloc.free(unsafe.Pointer(s))
+	unlock(&mheap_.speciallock)
+}
+
+// survived records that the object described by s has survived a
+// collection and reports whether its callback is now due.
+func (s *specialsurvival) survived() bool {
+	s.left--
+	return s.left <= 0
+}
+
+// Queues the survival callback s for the live object p and frees s.
+// It has already been unlinked from the MSpan specials list.
+func queuesurvival(s *specialsurvival, p unsafe.Pointer) {
+	queuefinalizer(p, s.fn, 0, s.fint, s.ot)
+	lock(&mheap_.speciallock)
+	mheap_.specialsurvivalalloc.free(unsafe.Pointer(s))
+	unlock(&mheap_.speciallock)
+}
+
+// The described object was allocated through a NamedPool.
+type specialpool struct {
+	special special
+	pool    *NamedPool
+	size    uintptr // requested size of the object
+}
+
+// Records that the object p of size bytes belongs to pool.
+func setpoolspecial(p unsafe.Pointer, pool *NamedPool, size uintptr) {
+	lock(&mheap_.speciallock)
+	s := (*specialpool)(mheap_.specialpoolalloc.alloc())
+	unlock(&mheap_.speciallock)
+	s.special.kind = _KindSpecialPool
+	s.pool = pool
+	s.size = size
+	if !addspecial(p, &s.special) {
+		throw("setpoolspecial: pool already set")
+	}
+}
+
+// The type of the described object is recorded (GODEBUG=objtypes=1).
+type specialtype struct {
+	special special
+	typ     *_type
+}
+
+// Records that the object p was allocated with type typ.
+func settypespecial(p unsafe.Pointer, typ *_type) {
+	lock(&mheap_.speciallock)
+	s := (*specialtype)(mheap_.specialtypealloc.alloc())
+	unlock(&mheap_.speciallock)
+	s.special.kind = _KindSpecialType
+	s.typ = typ
+	if !addspecial(p, &s.special) {
+		throw("settypespecial: type already set")
+	}
+}
+
+// Records that the object p is referenced from outside the heap
+// (AllocExternal). The record itself is the external reference: it
+// needs no data, and markrootSpans marks the object while it exists.
+func addexternal(p unsafe.Pointer) {
+	lock(&mheap_.speciallock)
+	s := (*special)(mheap_.specialexternalalloc.alloc())
+	unlock(&mheap_.speciallock)
+	s.kind = _KindSpecialExternal
+	if !addspecial(p, s) {
+		throw("addexternal: external reference already set")
+	}
+}
+
+// Removes the external reference from the object p, reporting whether
+// there was one.
+func removeexternal(p unsafe.Pointer) bool {
+	if mheap_.lookupMaybe(p) == nil {
+		return false
+	}
+	s := removespecial(p, _KindSpecialExternal)
+	if s == nil {
+		return false
+	}
+	lock(&mheap_.speciallock)
+	mheap_.specialexternalalloc.free(unsafe.Pointer(s))
+	unlock(&mheap_.speciallock)
+	return true
+}
+
+// Returns the type recorded for the object that contains p, which must
+// be in span span and in the object starting at base, or nil if none
+// was recorded. Objects in a tiny block each have a record of their
+// own, so the nearest record at or before p wins.
+func gettypespecial(span *mspan, base, p uintptr) *_type {
+	// Sweeping accesses the specials list w/o locks, as in removespecial.
+	mp := acquirem()
+	span.ensureSwept()
+	lo, hi := base-span.base(), p-span.base()
+	var typ *_type
+	lock(&span.speciallock)
+	for s := span.specials; s != nil && uintptr(s.offset) <= hi; s = s.next {
+		if uintptr(s.offset) >= lo && s.kind == _KindSpecialType {
+			typ = (*specialtype)(unsafe.Pointer(s)).typ
+		}
+	}
+	unlock(&span.speciallock)
+	releasem(mp)
+	return typ
+}
+
 // Do whatever cleanup needs to be done to deallocate s. It has
 // already been unlinked from the MSpan specials list.
 func freespecial(s *special, p unsafe.Pointer, size uintptr) {
 	switch s.kind {
 	case _KindSpecialFinalizer:
 		sf := (*specialfinalizer)(unsafe.Pointer(s))
-		queuefinalizer(p, sf.fn, sf.nret, sf.fint, sf.ot)
+		queuefinalizer(add(p, sf.argoff), sf.fn, sf.nret, sf.fint, sf.ot)
 		lock(&mheap_.speciallock)
 		mheap_.specialfinalizeralloc.free(unsafe.Pointer(sf))
 		unlock(&mheap_.speciallock)
@@ -1237,6 +1597,30 @@ func freespecial(s *special, p unsafe.Pointer, size uintptr) {
 		lock(&mheap_.speciallock)
 		mheap_.specialprofilealloc.free(unsafe.Pointer(sp))
 		unlock(&mheap_.speciallock)
+	case _KindSpecialSurvival:
+		// The object is being freed before its callback was due.
+		ss := (*specialsurvival)(unsafe.Pointer(s))
+		lock(&mheap_.speciallock)
+		mheap_.specialsurvivalalloc.free(unsafe.Pointer(ss))
+		unlock(&mheap_.speciallock)
+	case _KindSpecialPool:
+		sp := (*specialpool)(unsafe.Pointer(s))
+		atomic.Xadd64(&sp.pool.objects, -1)
+		atomic.Xadd64(&sp.pool.bytes, -int64(sp.size))
+		lock(&mheap_.speciallock)
+		mheap_.specialpoolalloc.free(unsafe.Pointer(sp))
+		unlock(&mheap_.speciallock)
+	case _KindSpecialType:
+		st := (*specialtype)(unsafe.Pointer(s))
+		lock(&mheap_.speciallock)
+		mheap_.specialtypealloc.free(unsafe.Pointer(st))
+		unlock(&mheap_.speciallock)
+	case _KindSpecialExternal:
+		// markrootSpans keeps the object alive while the
+		// record exists, but free it all the same.
+		lock(&mheap_.speciallock)
+		mheap_.specialexternalalloc.free(unsafe.Pointer(s))
+		unlock(&mheap_.speciallock)
 	default:
 		throw("bad special kind")
 		panic("not reached")
@@ -0,0 +1,303 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Heap integrity checking.
+//
+// HeapCheck walks the heap with the world stopped and checks the
+// invariants the allocator and the sweeper rely on, the same ones that
+// the throws in nextFree and sweep check as a side effect of their
+// work, and that heap pointers lead to allocated objects. The walk
+// records the first inconsistency it finds in a heapCheckFailure,
+// which holds only addresses and numbers, and the error is formatted
+// once the world has been restarted, since the walk must not allocate.
+
+package runtime
+
+import (
+	"runtime/internal/sys"
+	"unsafe"
+)
+
+// A heapCheckFailure describes the first inconsistency found by
+// heapCheck.
+type heapCheckFailure struct {
+	msg       string  // what is wrong; "" if nothing is
+	span      uintptr // base address of the span concerned
+	addr      uintptr // address concerned, or 0
+	got, want uintptr // values that disagree, if hasValues
+	hasValues bool
+}
+
+func (f *heapCheckFailure) set(msg string, s *mspan, addr uintptr) {
+	f.msg = msg
+	f.span = s.base()
+	f.addr = addr
+}
+
+func (f *heapCheckFailure) setValues(msg string, s *mspan, got, want uintptr) {
+	f.set(msg, s, 0)
+	f.got, f.want = got, want
+	f.hasValues = true
+}
+
+func (f *heapCheckFailure) error() error {
+	var buf [20]byte
+	str := "runtime: heap check: " + f.msg + " (span " + heapCheckHex(f.span)
+	if f.addr != 0 {
+		str += ", address " + heapCheckHex(f.addr)
+	}
+	if f.hasValues {
+		str += ": " + string(itoaDiv(buf[:], uint64(f.got), 0))
+		str += ", want " + string(itoaDiv(buf[:], uint64(f.want), 0))
+	}
+	return plainError(str + ")")
+}
+
+// heapCheckHex formats v in hexadecimal with a 0x prefix.
+func heapCheckHex(v uintptr) string {
+	const dig = "0123456789abcdef"
+	var buf [2 + 2*sys.PtrSize]byte
+	i := len(buf)
+	for {
+		i--
+		buf[i] = dig[v%16]
+		v /= 16
+		if v == 0 {
+			break
+		}
+	}
+	i--
+	buf[i] = 'x'
+	i--
+	buf[i] = '0'
+	return string(buf[i:])
+}
+
+// HeapCheck checks the integrity of the heap and returns an error
+// describing the first inconsistency it finds, or nil if it finds none.
+// It is meant for tracking down memory corruption, such as that caused
+// by unsafe or cgo code writing outside its objects.
+//
+// HeapCheck checks that every in-use span is mapped to its pages and
+// has the layout of its size class, that the allocation count of every
+// span agrees with its allocation bits and free index, that every span
+// is on the right central or heap free list, and that every pointer in
+// an allocated object that points into the heap arena points into an
+// allocated object or a goroutine stack. Corruption that leaves these
+// invariants intact, such as overwritten pointer-free data, goes
+// unnoticed.
+//
+// HeapCheck finishes the current sweep and then stops the world while
+// it examines every span and every pointer in the heap, so it is
+// expensive for large heaps.
+func HeapCheck() error {
+	ForceSweepComplete()
+	stopTheWorld("heap check")
+	var f heapCheckFailure
+	systemstack(func() {
+		lock(&mheap_.lock)
+		heapCheck(&f)
+		unlock(&mheap_.lock)
+	})
+	startTheWorld()
+	if f.msg == "" {
+		return nil
+	}
+	return f.error()
+}
+
+// heapCheck checks the heap and records the first inconsistency in f.
+// The world must be stopped and mheap_.lock held.
+func heapCheck(f *heapCheckFailure) {
+	h := &mheap_
+	for _, s := range h_allspans {
+		if s.state == mSpanInUse && !heapCheckSpan(f, s) {
+			return
+		}
+	}
+	for i := range h.central {
+		if !heapCheckCentral(f, &h.central[i].mcentral) || !heapCheckCentral(f, &h.coldcentral[i].mcentral) {
+			return
+		}
+	}
+	// The lists for spans of 0 pages are unused.
+	for i := 1; i < len(h.free); i++ {
+		if !heapCheckList(f, &h.free[i], _MSpanFree, uintptr(i)) {
+			return
+		}
+	}
+	if !heapCheckList(f, &h.freelarge, _MSpanFree, 0) {
+		return
+	}
+	for i := 1; i < len(h.busy); i++ {
+		if !heapCheckList(f, &h.busy[i], mSpanInUse, uintptr(i)) {
+			return
+		}
+	}
+	heapCheckList(f, &h.busylarge, mSpanInUse, 0)
+}
+
+// heapCheckSpan checks the in-use span s and the pointers in its
+// objects, reporting whether they are consistent.
+func heapCheckSpan(f *heapCheckFailure, s *mspan) bool {
+	h := &mheap_
+	base := s.base()
+	if s.npages == 0 || base < h.arena_start || base+s.npages<<_PageShift > h.arena_used {
+		f.set("span outside the heap arena", s, 0)
+		return false
+	}
+	first := (base - h.arena_start) >> _PageShift
+	for i := uintptr(0); i < s.npages; i++ {
+		if h_spans[first+i] != s {
+			f.set("page not mapped to its span", s, base+i<<_PageShift)
+			return false
+		}
+	}
+
+	if s.sizeclass == 0 {
+		if s.elemsize != s.npages<<_PageShift || s.nelems != 1 {
+			f.setValues("large object span with bad object size", s, s.elemsize, s.npages<<_PageShift)
+			return false
+		}
+	} else {
+		if s.sizeclass >= _NumSizeClasses {
+			f.setValues("span with bad size class", s, uintptr(s.sizeclass), _NumSizeClasses)
+			return false
+		}
+		if s.elemsize != uintptr(class_to_size[s.sizeclass]) {
+			f.setValues("span with bad object size", s, s.elemsize, uintptr(class_to_size[s.sizeclass]))
+			return false
+		}
+		if s.npages != uintptr(class_to_allocnpages[s.sizeclass]) {
+			f.setValues("span with bad page count", s, s.npages, uintptr(class_to_allocnpages[s.sizeclass]))
+			return false
+		}
+		if n := s.npages << _PageShift / s.elemsize; s.nelems != n {
+			f.setValues("span with bad object count", s, s.nelems, n)
+			return false
+		}
+	}
+
+	sg := h.sweepgen
+	if s.sweepgen != sg && s.sweepgen != sg-2 {
+		f.setValues("span with bad sweep generation", s, uintptr(s.sweepgen), uintptr(sg))
+		return false
+	}
+	if s.freeindex > s.nelems {
+		f.setValues("span with free index past its objects", s, s.freeindex, s.nelems)
+		return false
+	}
+	// Objects below freeindex are allocated; above it, the
+	// allocation bits say which are.
+	n := s.freeindex
+	for i := s.freeindex; i < s.nelems; i++ {
+		if !s.isFree(i) {
+			n++
+		}
+	}
+	if uintptr(s.allocCount) != n {
+		f.setValues("span allocation count disagrees with its allocation bits", s, uintptr(s.allocCount), n)
+		return false
+	}
+
+	// With the sweep finished, every allocated object in a swept
+	// span was reachable at the last collection or allocated since,
+	// so what it points to must be allocated too. The objects of
+	// an unswept span may be garbage pointing to freed memory.
+	if s.sweepgen != sg || s.lazybits != 0 {
+		return true
+	}
+	ok := true
+	s.forEachAllocated(func(x uintptr) {
+		if ok && objectHasPointers(s, x) {
+			ok = heapCheckObject(f, s, x)
+		}
+	})
+	return ok
+}
+
+// heapCheckObject checks that the pointers in the allocated object x in
+// the swept span s point to allocated memory.
+func heapCheckObject(f *heapCheckFailure, s *mspan, x uintptr) bool {
+	h := &mheap_
+	hbits := heapBitsForAddr(x)
+	for i := uintptr(0); i < s.elemsize; i += sys.PtrSize {
+		if i != 0 {
+			hbits = hbits.next()
+		}
+		// As in scanobject.
+		if i != 1*sys.PtrSize && !hbits.morePointers() {
+			break
+		}
+		if !hbits.isPointer() {
+			continue
+		}
+		v := *(*uintptr)(unsafe.Pointer(x + i))
+		if v < h.arena_start || v >= h.arena_used {
+			continue
+		}
+		t := h_spans[(v-h.arena_start)>>_PageShift]
+		if t == nil || t.state != mSpanInUse && t.state != _MSpanStack || v < t.base() || v >= t.base()+t.npages<<_PageShift {
+			f.set("pointer to memory not in use", s, x+i)
+			return false
+		}
+		if t.state != mSpanInUse || t.sweepgen != h.sweepgen {
+			continue
+		}
+		if t.sizeclass != 0 && v >= t.limit {
+			f.set("pointer past the objects of a span", s, x+i)
+			return false
+		}
+		if j := t.objIndex(v); j >= t.freeindex && t.isFree(j) {
+			f.set("pointer to a free object", s, x+i)
+			return false
+		}
+	}
+	return true
+}
+
+// heapCheckCentral checks that the spans on the lists of c belong there.
+func heapCheckCentral(f *heapCheckFailure, c *mcentral) bool {
+	for _, list := range [...]*mSpanList{&c.nonempty, &c.empty} {
+		for s := list.first; s != nil; s = s.next {
+			switch {
+			case s.list != list:
+				f.set("span on a central list it does not record", s, 0)
+			case s.state != mSpanInUse:
+				f.setValues("span not in use on a central list", s, uintptr(s.state), mSpanInUse)
+			case int32(s.sizeclass) != c.sizeclass:
+				f.setValues("span on the central list of another size class", s, uintptr(s.sizeclass), uintptr(c.sizeclass))
+			case s.cold != c.cold:
+				f.set("span on the central list of the wrong temperature", s, 0)
+			default:
+				continue
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// heapCheckList checks that the spans on the heap list l are in state
+// state and, unless npages is 0, have npages pages. Lists of spans of
+// any length have npages 0 and only hold spans of at least
+// _MaxMHeapList pages.
+func heapCheckList(f *heapCheckFailure, l *mSpanList, state uint8, npages uintptr) bool {
+	for s := l.first; s != nil; s = s.next {
+		switch {
+		case s.list != l:
+			f.set("span on a heap list it does not record", s, 0)
+		case s.state != state:
+			f.setValues("span in the wrong state on a heap list", s, uintptr(s.state), uintptr(state))
+		case npages != 0 && s.npages != npages:
+			f.setValues("span of the wrong length on a heap list", s, s.npages, npages)
+		case npages == 0 && s.npages < _MaxMHeapList:
+			f.setValues("short span on a heap list of long spans", s, s.npages, _MaxMHeapList)
+		default:
+			continue
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,211 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Isolated heaps.
+//
+// An isolated heap is a range of address space, reserved outside the
+// garbage-collected arena, from which memory is handed out by bumping a
+// pointer. Nothing in it is ever freed on its own; the whole range is
+// returned to the operating system at once by Destroy. The collector
+// does not know about the range: pointers into it are ignored when
+// scanning and its memory is never scanned, so it costs the collector
+// nothing and allocating from it never starts a collection.
+
+package runtime
+
+import (
+	"runtime/internal/sys"
+	"unsafe"
+)
+
+// isoHeapChunk is the granularity at which the reservation of an
+// isolated heap is mapped as allocation reaches it.
+const isoHeapChunk = 64 << 10
+
+// An IsolatedHeap is a region of memory outside the garbage-collected
+// heap, created by NewIsolatedHeap.
+//
+// Memory from an IsolatedHeap is not scanned by the garbage collector,
+// so it must not hold the only reference to an object in the ordinary
+// heap: such objects can be freed while still referred to. Pointers
+// between blocks of the same IsolatedHeap, and pointers from the
+// ordinary heap, global variables or stacks into an IsolatedHeap, are
+// allowed, but do not keep the IsolatedHeap alive; Destroy checks for
+// them.
+type IsolatedHeap struct {
+	lock      mutex
+	base      uintptr // start of the reserved range
+	end       uintptr // end of the reserved range
+	next      uintptr // next free byte
+	mapped    uintptr // end of the mapped part of the range
+	reserved  bool    // from sysReserve
+	destroyed bool
+}
+
+// NewIsolatedHeap reserves reserve bytes of address space, rounded up to
+// a whole number of pages, for a new IsolatedHeap. Memory is obtained
+// from the operating system only as blocks are allocated, and it does
+// not count towards the heap size that triggers garbage collections.
+// It is reported in MemStats.OtherSys.
+func NewIsolatedHeap(reserve uintptr) *IsolatedHeap {
+	if reserve == 0 || int(reserve) < 0 {
+		panic(plainError("runtime.NewIsolatedHeap: size out of range"))
+	}
+	reserve = round(reserve, _PageSize)
+	h := new(IsolatedHeap)
+	p := sysReserve(nil, reserve, &h.reserved)
+	if p == nil {
+		panic(plainError("runtime.NewIsolatedHeap: cannot reserve address space"))
+	}
+	if uintptr(p) < mheap_.arena_end && uintptr(p)+reserve > mheap_.arena_start {
+		// The arena may not be reserved with the operating
+		// system, and the heap will grow into it.
+		mSysStatInc(&memstats.other_sys, reserve)
+		sysFree(p, reserve, &memstats.other_sys)
+		panic(plainError("runtime.NewIsolatedHeap: cannot reserve address space"))
+	}
+	h.base = uintptr(p)
+	h.end = h.base + reserve
+	h.next = h.base
+	h.mapped = h.base
+	return h
+}
+
+// Alloc returns a zeroed block of size bytes from h, aligned to 8 bytes,
+// or nil if h has too little reserved space left. The block stays
+// allocated until h is destroyed. Alloc panics if h has been destroyed.
+func (h *IsolatedHeap) Alloc(size uintptr) unsafe.Pointer {
+	lock(&h.lock)
+	if h.destroyed {
+		unlock(&h.lock)
+		panic(plainError("runtime.IsolatedHeap.Alloc: heap destroyed"))
+	}
+	if size > h.end-h.next {
+		unlock(&h.lock)
+		return nil
+	}
+	p := h.next
+	h.next = round(p+size, 8)
+	if h.next > h.end {
+		h.next = h.end
+	}
+	if h.next > h.mapped {
+		n := round(h.next, isoHeapChunk)
+		if n > h.end {
+			n = h.end
+		}
+		sysMap(unsafe.Pointer(h.mapped), n-h.mapped, h.reserved, &memstats.other_sys)
+		h.mapped = n
+	}
+	unlock(&h.lock)
+	return unsafe.Pointer(p)
+}
+
+// Destroy frees all the memory of h and returns its address space to
+// the operating system, provided nothing outside h refers to it. After
+// a garbage collection, it stops the world and looks for pointers into
+// h in the heap, in global variables and, conservatively, in every word
+// of every goroutine stack, so a stale value in a stack frame can keep
+// h from being destroyed. References held elsewhere, for instance by
+// memory obtained through cgo, are not found.
+//
+// Destroy reports whether it destroyed h. If it finds a reference, it
+// leaves h as it was and returns false. Once h is destroyed, Alloc
+// panics, and further calls to Destroy return true and do nothing.
+func (h *IsolatedHeap) Destroy() bool {
+	GC()
+	stopTheWorld("destroy isolated heap")
+	if h.destroyed {
+		startTheWorld()
+		return true
+	}
+	var found bool
+	systemstack(func() {
+		found = isoHeapReferenced(h.base, h.end)
+	})
+	if !found {
+		h.destroyed = true
+	}
+	startTheWorld()
+	if found {
+		return false
+	}
+	// sysFree takes the whole reservation out of the statistic,
+	// which counts only the mapped part.
+	mSysStatInc(&memstats.other_sys, h.end-h.mapped)
+	sysFree(unsafe.Pointer(h.base), h.end-h.base, &memstats.other_sys)
+	return true
+}
+
+// isoHeapReferenced reports whether the heap, the global variables or
+// any goroutine stack holds a pointer into [lo, hi). The world must be
+// stopped.
+//
+//go:systemstack
+func isoHeapReferenced(lo, hi uintptr) bool {
+	var ptrs addrList
+	found := false
+	lock(&mheap_.lock)
+	for _, s := range h_allspans {
+		if s.state != mSpanInUse {
+			continue
+		}
+		s.forEachAllocated(func(x uintptr) {
+			addObjectPointers(x, &ptrs)
+		})
+		for i := uintptr(0); i < ptrs.n; i++ {
+			if v := *(*uintptr)(add(ptrs.buf, i*sys.PtrSize)); lo <= v && v < hi {
+				found = true
+			}
+		}
+		ptrs.n = 0
+		if found {
+			break
+		}
+	}
+	unlock(&mheap_.lock)
+	ptrs.free()
+	if found {
+		return true
+	}
+
+	for datap := &firstmoduledata; datap != nil; datap = datap.next {
+		if isoBlockReferences(datap.data, datap.edata-datap.data, datap.gcdatamask.bytedata, lo, hi) ||
+			isoBlockReferences(datap.bss, datap.ebss-datap.bss, datap.gcbssmask.bytedata, lo, hi) {
+			return true
+		}
+	}
+
+	for _, gp := range allgs {
+		if readgstatus(gp) == _Gdead {
+			continue
+		}
+		sp := gp.sched.sp
+		if gp.syscallsp != 0 {
+			sp = gp.syscallsp
+		}
+		if isoBlockReferences(sp, gp.stack.hi-sp, nil, lo, hi) {
+			return true
+		}
+	}
+	return false
+}
+
+// isoBlockReferences reports whether a pointer word of the n bytes at b
+// holds a value in [lo, hi). ptrmask marks the pointer words, one bit
+// per word, as in scanblock; if it is nil, every word is examined.
+func isoBlockReferences(b, n uintptr, ptrmask *uint8, lo, hi uintptr) bool {
+	for i := uintptr(0); i < n; i += sys.PtrSize {
+		if ptrmask != nil {
+			word := i / sys.PtrSize
+			if *addb(ptrmask, word/8)>>(word%8)&1 == 0 {
+				continue
+			}
+		}
+		if v := *(*uintptr)(unsafe.Pointer(b + i)); lo <= v && v < hi {
+			return true
+		}
+	}
+	return false
+}
@@ -148,13 +148,7 @@ func stkbucket(typ bucketType, size uintptr, stk []uintptr, alloc bool) *bucket
 		}
 	}
 
-	// Hash stack.
-	var h uintptr
-	for _, pc := range stk {
-		h += pc
-		h += h << 10
-		h ^= h >> 6
-	}
+	h := stackHash(stk)
 	// hash in size
 	h += size
 	h += h << 10
@@ -191,6 +185,17 @@ func stkbucket(typ bucketType, size uintptr, stk []uintptr, alloc bool) *bucket
 	return b
 }
 
+// stackHash returns the unfinalized hash of the call stack stk.
+func stackHash(stk []uintptr) uintptr {
+	var h uintptr
+	for _, pc := range stk {
+		h += pc
+		h += h << 10
+		h ^= h >> 6
+	}
+	return h
+}
+
 func eqslice(x, y []uintptr) bool {
 	if len(x) != len(y) {
 		return false
@@ -341,6 +346,39 @@ func (r *StackRecord) Stack() []uintptr {
 // at the beginning of main).
 var MemProfileRate int = 512 * 1024
 
+// SetGoroutineProfileExclude sets whether the heap allocations of the
+// calling goroutine are left out of the memory profile and returns the
+// previous setting. Goroutines start out included, unless they inherit
+// the setting as arranged by SetGoroutineProfileExcludeInherit. It is
+// meant for the goroutines of a profiler or other monitoring code,
+// whose allocations would otherwise show up in and skew the profiles
+// they watch, as AllocUnprofiled does for single allocations.
+// Allocations of excluded goroutines still count toward MemStats.
+func SetGoroutineProfileExclude(exclude bool) bool {
+	gp := getg()
+	old := gp.noprofile
+	gp.noprofile = exclude
+	return old
+}
+
+// SetGoroutineProfileExcludeInherit sets whether goroutines started by
+// the calling goroutine take on its SetGoroutineProfileExclude setting,
+// and this one, and returns the previous setting. By default they do
+// not, and their allocations are profiled.
+func SetGoroutineProfileExcludeInherit(inherit bool) bool {
+	gp := getg()
+	old := gp.noprofileinh
+	gp.noprofileinh = inherit
+	return old
+}
+
+// profileExcluded reports whether the goroutine allocating has been
+// excluded from the memory profile by SetGoroutineProfileExclude.
+func profileExcluded() bool {
+	gp := getg().m.curg
+	return gp != nil && gp.noprofile
+}
+
 // A MemProfileRecord describes the live objects allocated
 // by a particular call sequence (stack trace).
 type MemProfileRecord struct {
@@ -453,6 +491,97 @@ func iterate_memprof(fn func(*bucket, uintptr, *uintptr, uintptr, uintptr, uintp
 	unlock(&proflock)
 }
 
+// An AllocSite summarizes the live objects allocated by a single
+// call stack, as sampled by the memory profiler.
+type AllocSite struct {
+	StackHash    uintptr // hash of the allocating call stack
+	Func         string  // innermost non-runtime function on the stack
+	InUseBytes   int64   // bytes allocated and not yet freed
+	InUseObjects int64   // objects allocated and not yet freed
+}
+
+// AllocProfileTable returns the live heap as sampled by the memory
+// profiler, aggregated by allocating call stack. It is a lightweight
+// alternative to MemProfile for callers that want a top-allocators
+// view without symbolizing and aggregating full stacks themselves.
+//
+// Like MemProfile, the table describes the heap as of the most
+// recently completed garbage collection. Sites with no live objects
+// are omitted. If MemProfileRate is 0, AllocProfileTable returns nil.
+func AllocProfileTable() []AllocSite {
+	if MemProfileRate <= 0 {
+		return nil
+	}
+	for {
+		// Size the table without holding proflock, since
+		// allocating it may itself be sampled by the profiler.
+		lock(&proflock)
+		n := 0
+		for b := mbuckets; b != nil; b = b.allnext {
+			n++
+		}
+		unlock(&proflock)
+
+		sites := make([]AllocSite, 0, n)
+		first := make([]*bucket, 0, n) // first bucket seen for each site
+		mask := uintptr(1)
+		for mask < uintptr(2*n) {
+			mask <<= 1
+		}
+		mask--
+		index := make([]int32, mask+1) // open-addressed; site index + 1
+
+		lock(&proflock)
+		ok := true
+		for b := mbuckets; b != nil; b = b.allnext {
+			mp := b.mp()
+			if mp.alloc_bytes == mp.free_bytes {
+				continue
+			}
+			stk := b.stk()
+			h := stackHash(stk)
+			i := h & mask
+			for index[i] != 0 && !eqslice(first[index[i]-1].stk(), stk) {
+				i = (i + 1) & mask
+			}
+			if index[i] == 0 {
+				if len(sites) == cap(sites) {
+					// New buckets appeared since we sized the table.
+					ok = false
+					break
+				}
+				sites = append(sites, AllocSite{StackHash: h, Func: allocSiteFunc(stk)})
+				first = append(first, b)
+				index[i] = int32(len(sites))
+			}
+			s := &sites[index[i]-1]
+			s.InUseBytes += int64(mp.alloc_bytes - mp.free_bytes)
+			s.InUseObjects += int64(mp.allocs - mp.frees)
+		}
+		unlock(&proflock)
+		if ok {
+			return sites
+		}
+	}
+}
+
+// allocSiteFunc returns the name of the innermost function on stk
+// outside the runtime, that is, the function that made the allocation.
+func allocSiteFunc(stk []uintptr) string {
+	name := ""
+	for _, pc := range stk {
+		f := findfunc(pc - 1)
+		if f == nil {
+			continue
+		}
+		name = funcname(f)
+		if !hasprefix(name, "runtime.") {
+			break
+		}
+	}
+	return name
+}
+
 // BlockProfileRecord describes blocking events originated
 // at a particular call sequence (stack trace).
 type BlockProfileRecord struct {
@@ -670,3 +799,399 @@ func tracegc() {
 	gp.m.traceback = 0
 	unlock(&tracelock)
 }
+
+// Allocation trace rings. See EnableAllocTrace.
+
+var (
+	// allocTraceEnabled reports whether mallocgc records
+	// allocations in the per-P rings.
+	allocTraceEnabled bool
+
+	// allocTraceSize is the number of events each ring holds,
+	// or 0 if allocation tracing is disabled. Ps created while
+	// tracing is enabled get a ring of this size.
+	allocTraceSize int
+)
+
+// An allocTraceRing holds the most recent allocations made on a P.
+// It is only written by its own P with the M acquired, and only
+// read with the world stopped.
+type allocTraceRing struct {
+	n      uintptr // number of events ever recorded
+	events []allocTraceEvent
+}
+
+type allocTraceEvent struct {
+	p    uintptr
+	size uintptr
+	typ  *_type
+	when int64
+}
+
+func newAllocTraceRing(size int) *allocTraceRing {
+	return &allocTraceRing{events: make([]allocTraceEvent, size)}
+}
+
+func allocTraceRecord(p unsafe.Pointer, size uintptr, typ *_type) {
+	mp := acquirem()
+	if pp := mp.p.ptr(); pp != nil && pp.alloctrace != nil {
+		r := pp.alloctrace
+		e := &r.events[r.n%uintptr(len(r.events))]
+		e.p = uintptr(p)
+		e.size = size
+		e.typ = typ
+		e.when = nanotime()
+		r.n++
+	}
+	releasem(mp)
+}
+
+// An AllocEvent describes a heap allocation recorded by EnableAllocTrace.
+type AllocEvent struct {
+	Addr uintptr // address of the allocated object
+	Size uintptr // bytes allocated, after rounding up to the size class
+	Type string  // type of the object, or "" if it was allocated untyped
+	Time int64   // nanoseconds since the program started
+	P    int     // ID of the P that made the allocation
+}
+
+// EnableAllocTrace starts recording heap allocations into a ring buffer
+// of ringSize events per P, discarding any events recorded so far.
+// Once a ring is full, each allocation overwrites the oldest event
+// on that P. A ringSize of 0 or less disables recording.
+//
+// The rings live in ordinary memory, so the most recent allocations
+// can be recovered from a core dump as well as by AllocTraceDump.
+// Small allocations that are combined into an existing tiny block
+// are not recorded.
+//
+// EnableAllocTrace stops the world while it installs the rings.
+// Recording adds a timestamp read to every allocation.
+func EnableAllocTrace(ringSize int) {
+	if ringSize < 0 {
+		ringSize = 0
+	}
+	stopTheWorld("enable alloc trace")
+	allocTraceEnabled = false
+	allocTraceSize = ringSize
+	for _, p := range &allp {
+		if p == nil {
+			break
+		}
+		p.alloctrace = nil
+		if ringSize > 0 {
+			p.alloctrace = newAllocTraceRing(ringSize)
+		}
+	}
+	allocTraceEnabled = ringSize > 0
+	startTheWorld()
+}
+
+// AllocTraceDump returns the allocations recorded since the last call
+// to EnableAllocTrace that are still held in the ring buffers.
+// Events are grouped by P; the events of each P are in the order
+// they occurred. AllocTraceDump returns nil if tracing is disabled.
+//
+// AllocTraceDump stops the world while it copies the rings.
+func AllocTraceDump() []AllocEvent {
+	stopTheWorld("alloc trace dump")
+	// Don't record the allocation of the result.
+	enabled := allocTraceEnabled
+	allocTraceEnabled = false
+
+	var events []AllocEvent
+	if enabled {
+		n := 0
+		for _, p := range &allp {
+			if p == nil {
+				break
+			}
+			if r := p.alloctrace; r != nil {
+				if r.n < uintptr(len(r.events)) {
+					n += int(r.n)
+				} else {
+					n += len(r.events)
+				}
+			}
+		}
+		events = make([]AllocEvent, 0, n)
+		for _, p := range &allp {
+			if p == nil {
+				break
+			}
+			r := p.alloctrace
+			if r == nil {
+				continue
+			}
+			i := uintptr(0)
+			if r.n > uintptr(len(r.events)) {
+				i = r.n - uintptr(len(r.events))
+			}
+			for ; i < r.n; i++ {
+				e := &r.events[i%uintptr(len(r.events))]
+				ev := AllocEvent{
+					Addr: e.p,
+					Size: e.size,
+					Time: e.when - runtimeInitTime,
+					P:    int(p.id),
+				}
+				if e.typ != nil {
+					ev.Type = e.typ.string()
+				}
+				events = append(events, ev)
+			}
+		}
+	}
+
+	allocTraceEnabled = enabled
+	startTheWorld()
+	return events
+}
+
+// allocLabelTableBits is log2 of the number of distinct labels
+// AllocByLabel can count separately.
+const allocLabelTableBits = 10
+
+// allocLabels counts the bytes allocated under each label set by
+// SetAllocLabel. It is an open-addressed hash table with linear
+// probing. An entry is claimed for a label by a CAS on its label
+// field and never released. All fields are accessed atomically.
+var allocLabels [1 << allocLabelTableBits]struct {
+	label uint64
+	bytes uint64
+}
+
+// allocLabelOverflow counts the bytes allocated under labels that
+// found allocLabels full. Accessed atomically.
+var allocLabelOverflow uint64
+
+// allocLabelRecord adds size bytes to the count for label.
+func allocLabelRecord(label uint64, size uintptr) {
+	i := uintptr(label * 0x9e3779b97f4a7c15 >> (64 - allocLabelTableBits))
+	for n := 0; n < len(allocLabels); n++ {
+		e := &allocLabels[i]
+		l := atomic.Load64(&e.label)
+		if l == 0 {
+			if atomic.Cas64(&e.label, 0, label) {
+				l = label
+			} else {
+				l = atomic.Load64(&e.label)
+			}
+		}
+		if l == label {
+			atomic.Xadd64(&e.bytes, int64(size))
+			return
+		}
+		i = (i + 1) % uintptr(len(allocLabels))
+	}
+	atomic.Xadd64(&allocLabelOverflow, int64(size))
+}
+
+// SetAllocLabel sets the allocation label of the calling goroutine and
+// returns the previous label. Goroutines start with the label of the
+// goroutine that created them; the initial goroutine has label 0.
+// The bytes of every heap allocation a goroutine makes under a nonzero
+// label are added to that label's total, as reported by AllocByLabel.
+// A server can use this to attribute memory to the tenant or request
+// a goroutine works for without passing the label around. Labels with
+// the top bit set stand for the names of WithAllocDebugName.
+func SetAllocLabel(label uint64) uint64 {
+	gp := getg()
+	old := gp.alloclabel
+	gp.alloclabel = label
+	return old
+}
+
+// AllocByLabel returns the total number of bytes allocated under each
+// label set by SetAllocLabel since the program started, counting
+// each allocation's size rounded up to its size class. Freed memory
+// is not subtracted, so the totals measure allocation rate, not live
+// memory. Only the first 1024 distinct labels are counted separately;
+// allocations under any further labels are reported under label 0,
+// which is otherwise never counted.
+func AllocByLabel() map[uint64]uint64 {
+	m := make(map[uint64]uint64)
+	for i := range allocLabels {
+		e := &allocLabels[i]
+		if l := atomic.Load64(&e.label); l != 0 {
+			m[l] = atomic.Load64(&e.bytes)
+		}
+	}
+	if n := atomic.Load64(&allocLabelOverflow); n != 0 {
+		m[0] = n
+	}
+	return m
+}
+
+// allocDebugLabelBit is set in the allocation labels that
+// WithAllocDebugName uses for names, to keep them apart from labels set
+// by SetAllocLabel.
+const allocDebugLabelBit = 1 << 63
+
+// allocDebugNames maps the labels of the names used with
+// WithAllocDebugName to the names. It is never modified: a new name is
+// added by replacing the map with a copy, so readers need no lock.
+// Written with casp.
+var allocDebugNames *map[uint64]string
+
+// allocDebugLabel returns the allocation label for name, registering
+// name if it has not been used before.
+func allocDebugLabel(name string) uint64 {
+	// FNV-1a hash, so that a name keeps its label for the life
+	// of the program without a lock on the lookup path.
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(name); i++ {
+		h ^= uint64(name[i])
+		h *= 1099511628211
+	}
+	for {
+		old := (*map[uint64]string)(atomic.Loadp(unsafe.Pointer(&allocDebugNames)))
+		label := h | allocDebugLabelBit
+		if old != nil {
+			for {
+				n, ok := (*old)[label]
+				if !ok {
+					break
+				}
+				if n == name {
+					return label
+				}
+				// A hash collision: probe the next label.
+				label = (label + 1) | allocDebugLabelBit
+			}
+		}
+		m := make(map[uint64]string)
+		if old != nil {
+			for l, n := range *old {
+				m[l] = n
+			}
+		}
+		m[label] = name
+		p := new(map[uint64]string)
+		*p = m
+		if casp((*unsafe.Pointer)(unsafe.Pointer(&allocDebugNames)), unsafe.Pointer(old), unsafe.Pointer(p)) {
+			return label
+		}
+	}
+}
+
+// WithAllocDebugName calls fn with the allocations of the calling
+// goroutine, and of any goroutines fn starts, labeled with name, a
+// human-readable description of the work fn does such as
+// "parse config". AllocByDebugName, and the debug text form of the heap
+// profile in runtime/pprof, report the bytes allocated under each name,
+// grouping allocations by the operation they serve whatever the stacks
+// that made them. Calls may be nested; the innermost name applies.
+//
+// The name is recorded as an allocation label (see SetAllocLabel),
+// replacing the goroutine's label until fn returns, and counts against
+// the same limit on distinct labels. Labels with the top bit set are
+// reserved for names.
+func WithAllocDebugName(name string, fn func()) {
+	label := allocDebugLabel(name)
+	// Set the label only once the defer record, which may be
+	// allocated, exists.
+	defer SetAllocLabel(getg().alloclabel)
+	SetAllocLabel(label)
+	fn()
+}
+
+// AllocByDebugName returns the total number of bytes allocated under
+// each name used with WithAllocDebugName since the program started,
+// counted as for AllocByLabel.
+func AllocByDebugName() map[string]uint64 {
+	m := make(map[string]uint64)
+	names := (*map[uint64]string)(atomic.Loadp(unsafe.Pointer(&allocDebugNames)))
+	if names == nil {
+		return m
+	}
+	for label, bytes := range AllocByLabel() {
+		if name, ok := (*names)[label]; ok {
+			m[name] = bytes
+		}
+	}
+	return m
+}
+
+// A NamedPool attributes the heap objects allocated through it to a
+// name, so that PoolLiveStats can report how much memory each part of
+// a program holds. Create pools with NewNamedPool.
+type NamedPool struct {
+	objects uint64 // live objects; updated atomically
+	bytes   uint64 // live bytes; updated atomically
+	name    string
+}
+
+// namedPools holds every NamedPool ever created. Special records
+// refer to pools from memory the garbage collector does not scan,
+// so pools are never freed.
+var namedPools struct {
+	lock mutex
+	list []*NamedPool
+}
+
+// NewNamedPool returns a new pool for allocating objects attributed to
+// name. Pools are never freed, so a program should create a fixed set
+// of them, typically one per subsystem during initialization. Several
+// pools may share a name; PoolLiveStats reports their sum.
+func NewNamedPool(name string) *NamedPool {
+	p := &NamedPool{name: name}
+	lock(&namedPools.lock)
+	namedPools.list = append(namedPools.list, p)
+	unlock(&namedPools.lock)
+	return p
+}
+
+// Name returns the name of the pool.
+func (p *NamedPool) Name() string {
+	return p.name
+}
+
+// Alloc allocates a zeroed block of size bytes, like AllocDeferGC, and
+// counts it as live in the pool until the garbage collector frees it.
+// The arguments are as for AllocDeferGC: typ must be nil or a pointer
+// value such as (*T)(nil) describing the layout of the block.
+//
+// Each tracked object carries a special record of a few words outside
+// the heap, the same mechanism used by finalizers and the heap profiler,
+// which costs an extra lock and a small allocation per call and some
+// work for the sweeper when the object is freed. Tracking does not
+// delay freeing or interfere with finalizers. Zero-sized allocations
+// are not tracked.
+func (p *NamedPool) Alloc(size uintptr, typ interface{}) unsafe.Pointer {
+	t := allocElemType("NamedPool.Alloc", size, typ)
+	x := mallocgc(size, t, 0)
+	if size == 0 {
+		return x
+	}
+	atomic.Xadd64(&p.objects, 1)
+	atomic.Xadd64(&p.bytes, int64(size))
+	systemstack(func() {
+		setpoolspecial(x, p, size)
+	})
+	return x
+}
+
+// A PoolStat describes the live objects of the pools with one name.
+type PoolStat struct {
+	Objects uint64 // objects allocated and not yet freed
+	Bytes   uint64 // requested bytes of those objects
+}
+
+// PoolLiveStats returns the objects allocated through each NamedPool
+// that have not yet been freed, keyed by pool name. An object counts
+// as live until the sweeper frees it, which happens some time after
+// the garbage collection that finds it unreachable; after GC returns,
+// every unreachable object without a finalizer has been freed.
+func PoolLiveStats() map[string]PoolStat {
+	m := make(map[string]PoolStat)
+	lock(&namedPools.lock)
+	for _, p := range namedPools.list {
+		st := m[p.name]
+		st.Objects += atomic.Load64(&p.objects)
+		st.Bytes += atomic.Load64(&p.bytes)
+		m[p.name] = st
+	}
+	unlock(&namedPools.lock)
+	return m
+}
@@ -108,6 +108,33 @@ type mstats struct {
 	// heap_reachable is an estimate of the reachable heap bytes
 	// at the end of the previous GC.
 	heap_reachable uint64
+
+	// central_contended is the number of times an mcache refill
+	// found the mcentral lock already held. Updated atomically.
+	central_contended uint64
+
+	// pause_hist counts stop-the-world GC pauses by duration.
+	// See recordPause. Updated atomically.
+	pause_hist [pauseHistBuckets]uint64
+
+	// Allocation path counts, flushed from the mcaches.
+	// See SlowAllocStats.
+	nsmallalloc uint64 // small allocations other than tiny allocations
+	nrefill     uint64 // mcache span refills
+	nlargealloc uint64 // large object allocations
+
+	// GC trigger checks made by mallocgc, and those that found a
+	// cycle should start, flushed from the mcaches. See
+	// GCTriggerStats.
+	ntrigcheck uint64
+	ntrigfire  uint64
+
+	// heap_goals is a circular buffer of recent heap goals, most
+	// recent at [(heap_goals_n-1)%len]. See HeapGoalHistory.
+	// Protected by heap_goals_lock.
+	heap_goals      [256]HeapGoalSample
+	heap_goals_n    uint32
+	heap_goals_lock mutex
 }
 
 var memstats mstats
@@ -201,6 +228,1221 @@ func readmemstats_m(stats *MemStats) {
 	stats.HeapSys -= stats.StackInuse
 }
 
+// PerPCacheAlloc returns, for each P, the number of bytes of small
+// objects allocated from that P's cache since the P was created.
+// The result has one entry per P, indexed by P id, and is taken with
+// the world stopped so the counts are mutually consistent.
+func PerPCacheAlloc() []int {
+	for {
+		n := int(gomaxprocs)
+		counts := make([]int, n)
+		stopTheWorld("per-P cache alloc")
+		if int(gomaxprocs) != n {
+			// GOMAXPROCS changed before we stopped the world.
+			startTheWorld()
+			continue
+		}
+		for i := range counts {
+			if c := allp[i].mcache; c != nil {
+				counts[i] = int(c.local_cachealloc)
+			}
+		}
+		startTheWorld()
+		return counts
+	}
+}
+
+// An MCacheStat describes the small-object cache of one P. See
+// MCacheStats.
+type MCacheStat struct {
+	CacheAlloc uint64 // bytes of small objects allocated from the cache since the P was created
+	TinyUsed   uint64 // bytes used in the current 16-byte tiny block; 0 if there is none
+
+	// Free holds, for each size class, the number of free objects
+	// left in the span the P is allocating from, indexed by size
+	// class as for SpansPerClass. An entry of 0 means the P has no
+	// span of the class or its span is full, so that its next
+	// allocation in the class refills the cache.
+	Free []uint32
+}
+
+// MCacheStats returns a snapshot of the small-object cache of each P,
+// indexed by P id. Comparing the entries shows how evenly allocation is
+// spread over the Ps and how well each fills its tiny blocks, into
+// which pointer-free objects smaller than 16 bytes are packed. The
+// snapshot is taken with the world stopped, so the entries are mutually
+// consistent.
+func MCacheStats() []MCacheStat {
+	for {
+		n := int(gomaxprocs)
+		stats := make([]MCacheStat, n)
+		for i := range stats {
+			stats[i].Free = make([]uint32, _NumSizeClasses)
+		}
+		stopTheWorld("mcache stats")
+		if int(gomaxprocs) != n {
+			// GOMAXPROCS changed before we stopped the world.
+			startTheWorld()
+			continue
+		}
+		for i := range stats {
+			c := allp[i].mcache
+			if c == nil {
+				continue
+			}
+			st := &stats[i]
+			st.CacheAlloc = uint64(c.local_cachealloc)
+			if c.tiny != 0 {
+				st.TinyUsed = uint64(c.tinyoffset)
+			}
+			for j, s := range c.alloc {
+				if s != &emptymspan {
+					st.Free[j] = uint32(s.nelems - uintptr(s.allocCount))
+				}
+			}
+		}
+		startTheWorld()
+		return stats
+	}
+}
+
+// CentralCacheContention returns the number of times a P refilling
+// its cache of small-object spans had to wait for another P holding
+// the lock on the central free list for that size class. A count that grows quickly
+// relative to the number of GCs suggests that small allocations on
+// many Ps are contending for the central free lists.
+func CentralCacheContention() uint64 {
+	return atomic.Load64(&memstats.central_contended)
+}
+
+// HeapInUse returns the number of bytes in in-use heap spans, which
+// hold objects, not counting spans used for goroutine stacks. It is the
+// MemStats.HeapInuse value, without the cost of ReadMemStats.
+func HeapInUse() uintptr {
+	var n uint64
+	systemstack(func() {
+		lock(&mheap_.lock)
+		n = memstats.heap_inuse - memstats.stacks_inuse
+		unlock(&mheap_.lock)
+	})
+	return uintptr(n)
+}
+
+// HeapIdle returns the number of bytes in idle heap spans, which are
+// free for reuse, including any already returned to the operating
+// system. It is the MemStats.HeapIdle value, without the cost of
+// ReadMemStats.
+func HeapIdle() uintptr {
+	var n uint64
+	systemstack(func() {
+		lock(&mheap_.lock)
+		n = memstats.heap_idle
+		unlock(&mheap_.lock)
+	})
+	return uintptr(n)
+}
+
+// PageStats returns a page-level breakdown of the address space of the
+// heap arena. reserved is the address space set aside for the heap,
+// whether or not it has been mapped; on 64-bit systems this is mostly
+// a large reservation that is never used and costs no memory.
+// committed is the part of the mapped arena the heap has not returned
+// to the operating system, which is the most the heap can have
+// resident in RAM, and released is the mapped part returned with
+// madvise or the like (MemStats.HeapReleased), which costs no RAM until
+// it is reused. committed+released is MemStats.HeapSys.
+//
+// Pages of committed memory that have never been touched may not be
+// resident either, so committed is an upper bound on the heap's share
+// of the resident set size, which is what container memory limits
+// usually apply to.
+func PageStats() (reserved, committed, released uintptr) {
+	systemstack(func() {
+		lock(&mheap_.lock)
+		reserved = uintptr(memstats.heap_sys) + mheap_.arena_end - mheap_.arena_used
+		committed = uintptr(memstats.heap_sys - memstats.heap_released)
+		released = uintptr(memstats.heap_released)
+		unlock(&mheap_.lock)
+	})
+	return
+}
+
+// GCMetadataBytes returns the number of bytes of memory obtained from
+// the system for garbage collector metadata. This is mostly the heap
+// bitmap, which takes 2 bits for every pointer-sized word of the
+// mapped heap arena, and the mark and allocation bits of each span,
+// one bit per object, plus the collector's work buffers and finalizer
+// queue. It is the MemStats.GCSys value, without the cost of
+// ReadMemStats.
+func GCMetadataBytes() uintptr {
+	return uintptr(atomic.Load64(&memstats.gc_sys))
+}
+
+// SpansPerClass returns the number of in-use heap spans of each size
+// class, indexed by size class, counting spans cached by Ps as well as
+// those on the central lists. Entry 0 counts the spans of large objects,
+// each of which holds a single object. The object sizes of the other
+// classes are those listed in MemStats.BySize, in order. Multiplying
+// the counts by the span sizes shows which classes dominate the memory
+// used by the heap.
+func SpansPerClass() []int {
+	counts := make([]int, _NumSizeClasses)
+	systemstack(func() {
+		lock(&mheap_.lock)
+		for _, s := range h_allspans {
+			if s.state == mSpanInUse {
+				counts[s.sizeclass]++
+			}
+		}
+		unlock(&mheap_.lock)
+	})
+	return counts
+}
+
+// SpanStateCounts returns the number of spans the heap manages in each
+// state: inUse spans hold heap objects, free spans are unused memory
+// that the heap can reuse (whether or not it has been returned to the
+// operating system), and stack spans hold goroutine stacks. Other
+// counts span records that describe no memory, such as those of spans
+// merged into a neighbor when freed. A steadily growing number of
+// in-use spans with a stable amount of live heap points to spans that
+// are kept from being freed, for example by a few long-lived objects
+// scattered over many spans.
+func SpanStateCounts() (inUse, free, stack, other int) {
+	systemstack(func() {
+		lock(&mheap_.lock)
+		for _, s := range h_allspans {
+			switch s.state {
+			case _MSpanInUse:
+				inUse++
+			case _MSpanFree:
+				free++
+			case _MSpanStack:
+				stack++
+			default:
+				other++
+			}
+		}
+		unlock(&mheap_.lock)
+	})
+	return
+}
+
+// allocRateLast holds the allocation counts of each size class as of
+// the last call to AllocRatePerClass. It is protected by the world
+// being stopped.
+var allocRateLast [_NumSizeClasses]uint64
+
+// AllocRatePerClass returns the number of objects allocated in each size
+// class since the previous call, or since the program started for the
+// first call. Like SpansPerClass, the result is indexed by size class,
+// with entry 0 counting large objects. Calling it at a fixed interval
+// gives the allocation rate of each class; classes with a high rate
+// but few live objects are churning and are good candidates for reuse
+// through a sync.Pool. Objects smaller than 16 bytes that contain no
+// pointers are counted once for each 16-byte block they are packed into.
+func AllocRatePerClass() []uint64 {
+	counts := make([]uint64, _NumSizeClasses)
+	stopTheWorld("alloc rate per class")
+	systemstack(func() {
+		cachestats()
+		for i := range counts {
+			counts[i] = mheap_.nclassalloc[i] - allocRateLast[i]
+			allocRateLast[i] = mheap_.nclassalloc[i]
+		}
+	})
+	startTheWorld()
+	return counts
+}
+
+// A SpanUtil describes how full the in-use spans of one size class are.
+// See SpanUtilization.
+type SpanUtil struct {
+	Size    uint32 // object size of the class; 0 for large objects
+	Spans   uint64 // number of in-use spans
+	Objects uint64 // allocated objects in those spans
+	Bytes   uint64 // memory in those spans
+
+	// Histogram counts the spans by utilization, the fraction of a
+	// span's memory used by its allocated objects: Histogram[i]
+	// counts the spans with a utilization of at least i/10 and
+	// less than (i+1)/10, and full spans are in the last bucket.
+	Histogram [10]uint64
+}
+
+// SpanUtilization returns the utilization of the in-use heap spans of
+// each size class, indexed by size class as for SpansPerClass, with
+// entry 0 describing large objects. Memory in spans with a low
+// utilization is mostly unused but cannot be returned to the system or
+// used for objects of other sizes, so a class with many such spans is
+// fragmented, and objects in them are the best candidates for being
+// copied elsewhere to free whole spans. Objects are counted until their
+// span is swept after the collection that finds them unreachable. The
+// result has a fixed size whatever the size of the heap, but
+// SpanUtilization stops the world and examines every span, so it is
+// expensive for large heaps.
+func SpanUtilization() []SpanUtil {
+	stats := make([]SpanUtil, _NumSizeClasses)
+	for i := range stats {
+		stats[i].Size = uint32(class_to_size[i])
+	}
+	stopTheWorld("span utilization")
+	systemstack(func() {
+		lock(&mheap_.lock)
+		for _, s := range h_allspans {
+			if s.state != mSpanInUse {
+				continue
+			}
+			st := &stats[s.sizeclass]
+			used, spanBytes := s.utilization()
+			i := used * uint64(len(st.Histogram)) / spanBytes
+			if i >= uint64(len(st.Histogram)) {
+				i = uint64(len(st.Histogram)) - 1
+			}
+			st.Spans++
+			st.Objects += uint64(s.allocCount)
+			st.Bytes += spanBytes
+			st.Histogram[i]++
+		}
+		unlock(&mheap_.lock)
+	})
+	startTheWorld()
+	return stats
+}
+
+// utilization returns the bytes used by allocated objects in the in-use
+// span s and the size of its memory.
+func (s *mspan) utilization() (used, spanBytes uint64) {
+	return uint64(s.allocCount) * uint64(s.elemsize), uint64(s.npages << _PageShift)
+}
+
+// CompactableBytes estimates how much heap memory copying objects
+// between spans of the same size class could free, to weigh the benefit
+// of compacting the heap against its cost. The estimate is
+// conservative: only spans less than half used count as candidates to
+// be emptied, and no more of them than the allocated objects of the
+// class leave room for once packed into as few spans as possible.
+// Large objects have spans of their own and are never counted. Like
+// SpanUtilization, CompactableBytes counts objects until their span is
+// swept, and it stops the world and examines every span.
+func CompactableBytes() uintptr {
+	var spans, objects, sparse [_NumSizeClasses]uint64
+	stopTheWorld("compactable bytes")
+	systemstack(func() {
+		lock(&mheap_.lock)
+		for _, s := range h_allspans {
+			if s.state != mSpanInUse || s.sizeclass == 0 {
+				continue
+			}
+			spans[s.sizeclass]++
+			objects[s.sizeclass] += uint64(s.allocCount)
+			if used, spanBytes := s.utilization(); used*2 < spanBytes {
+				sparse[s.sizeclass]++
+			}
+		}
+		unlock(&mheap_.lock)
+	})
+	startTheWorld()
+
+	var total uint64
+	for i := 1; i < _NumSizeClasses; i++ {
+		if sparse[i] == 0 {
+			continue
+		}
+		npages := uint64(class_to_allocnpages[i])
+		perSpan := npages << _PageShift / uint64(class_to_size[i])
+		needed := (objects[i] + perSpan - 1) / perSpan
+		n := spans[i] - needed
+		if sparse[i] < n {
+			n = sparse[i]
+		}
+		total += n * npages << _PageShift
+	}
+	return uintptr(total)
+}
+
+// A PinnedSpan describes a span of small objects that SpanLeakReport
+// found kept in use by a single object.
+type PinnedSpan struct {
+	Object    uintptr // address of the object
+	Size      uintptr // object size of the span's size class
+	SpanBytes uintptr // memory in the span
+}
+
+// spanLeak holds the single-object spans found by the last call to
+// SpanLeakReport, mapping the base of each span to the address of its
+// object, and memstats.numgc at the time. It is protected by
+// spanLeakSema.
+var spanLeak struct {
+	spans map[uintptr]uintptr
+	numgc uint32
+}
+
+var spanLeakSema uint32 = 1
+
+// SpanLeakReport returns the spans of small objects that hold a single
+// allocated object, the same one as at the previous call, with at least
+// one garbage collection in between. Such an object keeps a whole span
+// of memory, up to several pages, in use for itself, and objects that
+// outlive most of those allocated around them, such as entries of a
+// cache filled once, can pin many spans this way, so that the heap
+// stays much larger than the live data. Call SpanLeakReport again
+// after some collections to find the spans that stay pinned.
+//
+// The first call, and calls with no collection since the previous one,
+// return nil. A span that was emptied and refilled with a single object
+// at the same address in between is reported as well. Large objects,
+// which have spans of their own, are never reported. SpanLeakReport
+// finishes the current sweep and then stops the world while it
+// examines every span, so it is expensive for large heaps.
+func SpanLeakReport() []PinnedSpan {
+	semacquire(&spanLeakSema, false)
+	ForceSweepComplete()
+	var l addrList
+	stopTheWorld("span leak report")
+	numgc := memstats.numgc
+	systemstack(func() {
+		lock(&mheap_.lock)
+		sg := mheap_.sweepgen
+		for _, s := range h_allspans {
+			if s.state != mSpanInUse || s.sizeclass == 0 || s.allocCount != 1 || s.sweepgen != sg {
+				continue
+			}
+			s.forEachAllocated(func(x uintptr) {
+				l.add(s.base())
+				l.add(x)
+				l.add(s.elemsize)
+				l.add(s.npages << _PageShift)
+			})
+		}
+		unlock(&mheap_.lock)
+	})
+	startTheWorld()
+
+	var pinned []PinnedSpan
+	cur := make(map[uintptr]uintptr, l.n/4)
+	for i := uintptr(0); i < l.n; i += 4 {
+		e := (*[4]uintptr)(add(l.buf, i*sys.PtrSize))
+		base, x := e[0], e[1]
+		cur[base] = x
+		if numgc != spanLeak.numgc && spanLeak.spans[base] == x {
+			pinned = append(pinned, PinnedSpan{x, e[2], e[3]})
+		}
+	}
+	l.free()
+	if spanLeak.spans == nil || numgc != spanLeak.numgc {
+		spanLeak.spans, spanLeak.numgc = cur, numgc
+	}
+	semrelease(&spanLeakSema)
+	return pinned
+}
+
+// A ClassScanStat describes the allocated objects of one size class,
+// split by whether the garbage collector has to scan them for pointers.
+type ClassScanStat struct {
+	Size          uint32 // object size of the class; 0 for large objects
+	ScanObjects   uint64 // allocated objects that contain pointers
+	ScanBytes     uint64 // bytes of those objects
+	NoScanObjects uint64 // allocated objects that contain no pointers
+	NoScanBytes   uint64 // bytes of those objects
+}
+
+// AllocClassHistogram returns the allocated heap objects of each size
+// class, indexed by size class as for SpansPerClass, counting separately
+// the objects that contain pointers, which the collector scans, and
+// those that do not, which it only marks. Entry 0 describes large
+// objects. Bytes are counted at the object size of the class, or the
+// rounded size of a large object. An object is counted until it is
+// freed, which happens when its span is swept after the first collection
+// that finds it unreachable. Pointer-free objects smaller than 16 bytes
+// share blocks (see SetLowFragMode), and each shared block counts as one
+// object. AllocClassHistogram stops the world and examines every
+// object in the heap, so it is expensive for large heaps.
+func AllocClassHistogram() []ClassScanStat {
+	stats := make([]ClassScanStat, _NumSizeClasses)
+	for i := range stats {
+		stats[i].Size = uint32(class_to_size[i])
+	}
+	stopTheWorld("alloc class histogram")
+	systemstack(func() {
+		lock(&mheap_.lock)
+		for _, s := range h_allspans {
+			if s.state != mSpanInUse {
+				continue
+			}
+			st := &stats[s.sizeclass]
+			size := uint64(s.elemsize)
+			s.forEachAllocated(func(x uintptr) {
+				if objectHasPointers(s, x) {
+					st.ScanObjects++
+					st.ScanBytes += size
+				} else {
+					st.NoScanObjects++
+					st.NoScanBytes += size
+				}
+			})
+		}
+		unlock(&mheap_.lock)
+	})
+	startTheWorld()
+	return stats
+}
+
+// forEachAllocated calls f with the address of each allocated object in
+// the in-use span s. The world must be stopped or s otherwise owned by
+// the caller.
+func (s *mspan) forEachAllocated(f func(x uintptr)) {
+	size := s.elemsize
+	for i := uintptr(0); i < s.nelems; i++ {
+		if i >= s.freeindex && s.isFree(i) {
+			continue
+		}
+		f(s.base() + i*size)
+	}
+}
+
+// objectHasPointers reports whether the heap bitmap of the allocated
+// object x in span s says that x contains pointers.
+func objectHasPointers(s *mspan, x uintptr) bool {
+	if atomic.Load(&s.lazybits) != 0 {
+		// Only objects with pointers defer their bitmap.
+		return true
+	}
+	h := heapBitsForAddr(x)
+	if s.elemsize == sys.PtrSize {
+		return h.isPointer()
+	}
+	return h.hasPointers(s.elemsize)
+}
+
+// A HeapCensus is a compact summary of the objects in the heap at one
+// moment, as taken by HeapSnapshot. It records how many objects of each
+// shape there were, not the objects themselves.
+type HeapCensus struct {
+	small [_NumSizeClasses][2]heapCount // by size class and pointers
+	large map[heapShape]heapCount
+}
+
+// A heapShape is what the heap records about an object: its rounded
+// size and whether it contains pointers.
+type heapShape struct {
+	size     uintptr
+	pointers bool
+}
+
+type heapCount struct {
+	objects int64
+	bytes   int64
+}
+
+// HeapSnapshot walks the heap and returns a census of the objects in
+// it. Because the heap does not record the type of each object, the
+// census groups objects by shape: their size, rounded up to the size
+// class, and whether they contain pointers. Objects that are unreachable
+// but have not yet been freed are included, so calling GC first gives
+// a census of live objects. HeapSnapshot stops the world and examines
+// every object in the heap, so it is expensive for large heaps, but the
+// census itself takes memory proportional to the number of distinct
+// shapes, not to the number of objects.
+//
+// Use HeapSnapshotDiff to compare two censuses.
+func HeapSnapshot() *HeapCensus {
+	c := &HeapCensus{large: make(map[heapShape]heapCount)}
+	var large []heapShape
+	stopTheWorld("heap snapshot")
+	// Count the large objects before allocating room for them.
+	// The world stays stopped, so the count cannot change.
+	n := 0
+	systemstack(func() {
+		lock(&mheap_.lock)
+		for _, s := range h_allspans {
+			if s.state == mSpanInUse && s.sizeclass == 0 && s.allocCount != 0 {
+				n++
+			}
+		}
+		unlock(&mheap_.lock)
+	})
+	large = make([]heapShape, 0, n)
+	systemstack(func() {
+		lock(&mheap_.lock)
+		for _, s := range h_allspans {
+			if s.state != mSpanInUse {
+				continue
+			}
+			if s.sizeclass == 0 {
+				if s.allocCount != 0 && len(large) < cap(large) {
+					large = append(large, heapShape{s.elemsize, objectHasPointers(s, s.base())})
+				}
+				continue
+			}
+			counts := &c.small[s.sizeclass]
+			size := int64(s.elemsize)
+			s.forEachAllocated(func(x uintptr) {
+				i := 0
+				if objectHasPointers(s, x) {
+					i = 1
+				}
+				counts[i].objects++
+				counts[i].bytes += size
+			})
+		}
+		unlock(&mheap_.lock)
+	})
+	startTheWorld()
+	for _, sh := range large {
+		hc := c.large[sh]
+		hc.objects++
+		hc.bytes += int64(sh.size)
+		c.large[sh] = hc
+	}
+	return c
+}
+
+// LiveBytesForType returns the memory used by, and the number of, heap
+// objects of type T, where typ is a pointer value such as (*T)(nil),
+// that have not yet been freed. Call GC first to count only reachable
+// objects. Like HeapSnapshot, LiveBytesForType stops the world and walks
+// the whole heap, but it looks only at the size class of T, and returns
+// just two numbers.
+//
+// The heap does not record the type of each object, so an object is
+// counted if it has the block size of a T, which is T's size rounded up
+// to its size class, and the same layout of pointers as a T. The result
+// is exact when no other type of that size class has T's pointer layout,
+// and otherwise also counts the objects of those types. In particular,
+// a type without pointers matches all other pointer-free objects of its
+// size class, and pointer-free types smaller than 16 bytes, which share
+// blocks, are never counted. Objects are found only when allocated
+// singly, such as by new(T), not as elements of arrays or slices.
+// The bytes are those of the blocks holding the objects.
+func LiveBytesForType(typ interface{}) (bytes, count uintptr) {
+	t := efaceOf(&typ)._type
+	if t == nil || t.kind&kindMask != kindPtr {
+		panic(plainError("runtime.LiveBytesForType: type argument is not a pointer"))
+	}
+	elem := (*ptrtype)(unsafe.Pointer(t)).elem
+	if elem.size == 0 || elem.ptrdata == 0 && elem.size < maxTinySize {
+		return 0, 0
+	}
+	var mask *byte
+	if elem.ptrdata != 0 {
+		mask = elem.gcdata
+		if elem.kind&kindGCProg != 0 {
+			buf := make([]byte, (elem.ptrdata/sys.PtrSize+7)/8)
+			runGCProg(addb(elem.gcdata, 4), nil, &buf[0], 1)
+			mask = &buf[0]
+		}
+	}
+	size := roundupsize(elem.size)
+
+	stopTheWorld("live bytes for type")
+	systemstack(func() {
+		lock(&mheap_.lock)
+		for _, s := range h_allspans {
+			if s.state != mSpanInUse || s.elemsize != size {
+				continue
+			}
+			s.forEachAllocated(func(x uintptr) {
+				if objectHasLayout(s, x, elem, mask) {
+					bytes += size
+					count++
+				}
+			})
+		}
+		unlock(&mheap_.lock)
+	})
+	startTheWorld()
+	return
+}
+
+// objectHasLayout reports whether the heap bitmap of the allocated
+// object x in span s matches the pointer layout of typ, given by mask.
+func objectHasLayout(s *mspan, x uintptr, typ *_type, mask *byte) bool {
+	if atomic.Load(&s.lazybits) == lazyBitsPending {
+		return s.lazytype == typ && s.lazysize == typ.size
+	}
+	if !objectHasPointers(s, x) {
+		return typ.ptrdata == 0
+	}
+	if typ.ptrdata == 0 {
+		return false
+	}
+	h := heapBitsForAddr(x)
+	for i := uintptr(0); i < typ.ptrdata/sys.PtrSize; i++ {
+		if h.isPointer() != (*addb(mask, i/8)>>(i%8)&1 != 0) {
+			return false
+		}
+		h = h.next()
+	}
+	return true
+}
+
+// A HeapDelta is the change in the objects of one shape between two
+// heap censuses, as returned by HeapSnapshotDiff.
+type HeapDelta struct {
+	Size     uintptr // object size, rounded up to the size class
+	Pointers bool    // whether the objects contain pointers
+	Objects  int64   // change in the number of objects
+	Bytes    int64   // change in the bytes used by the objects
+}
+
+// HeapSnapshotDiff returns how the heap changed from census a to census
+// b, taken by HeapSnapshot, with one entry for each shape of object
+// whose count changed. Entries are sorted by Bytes, largest growth
+// first, so when hunting a leak with snapshots taken some time apart,
+// the shapes at the start of the result are the ones accumulating.
+func HeapSnapshotDiff(a, b *HeapCensus) []HeapDelta {
+	var deltas []HeapDelta
+	add := func(sh heapShape, ac, bc heapCount) {
+		if ac.objects != bc.objects || ac.bytes != bc.bytes {
+			deltas = append(deltas, HeapDelta{sh.size, sh.pointers, bc.objects - ac.objects, bc.bytes - ac.bytes})
+		}
+	}
+	for class := 1; class < _NumSizeClasses; class++ {
+		for i := range a.small[class] {
+			add(heapShape{uintptr(class_to_size[class]), i == 1}, a.small[class][i], b.small[class][i])
+		}
+	}
+	for sh, ac := range a.large {
+		add(sh, ac, b.large[sh])
+	}
+	for sh, bc := range b.large {
+		if _, ok := a.large[sh]; !ok {
+			add(sh, heapCount{}, bc)
+		}
+	}
+	// Insertion sort; programs use few distinct shapes.
+	for i := 1; i < len(deltas); i++ {
+		for j := i; j > 0 && deltas[j].Bytes > deltas[j-1].Bytes; j-- {
+			deltas[j], deltas[j-1] = deltas[j-1], deltas[j]
+		}
+	}
+	return deltas
+}
+
+// ReachableFrom calls fn for each heap object reachable from root, which
+// must be a pointer, answering the question of what root keeps alive.
+// fn gets the address of the object and the size of its block, which
+// is the object's size rounded up to its size class; with
+// GODEBUG=objtypes=1, ObjectType gives its type. The first call is for
+// the object root points into. If root does not point into the heap,
+// fn is not called.
+//
+// ReachableFrom follows pointers as the garbage collector does, using
+// the heap bitmap, but without touching the collector's mark state. It
+// finds the objects with the world stopped, so the result is a
+// consistent snapshot, and then calls fn for each with the world
+// running, keeping all of them alive until it returns.
+func ReachableFrom(root interface{}, fn func(p unsafe.Pointer, size uintptr)) {
+	e := efaceOf(&root)
+	etyp := e._type
+	if etyp == nil {
+		return
+	}
+	if etyp.kind&kindMask != kindPtr {
+		panic(plainError("runtime.ReachableFrom: argument is " + etyp.string() + ", not pointer"))
+	}
+
+	stopTheWorld("reachable from")
+	var (
+		stack, found addrList
+		seen         addrSet
+	)
+	stack.add(uintptr(e.data))
+	for stack.n > 0 {
+		obj, _, _, _ := heapBitsForObject(stack.pop(), 0, 0)
+		if obj != 0 && seen.add(obj) {
+			found.add(obj)
+			addObjectPointers(obj, &stack)
+		}
+	}
+	// Only the result is allocated from the heap, after the walk,
+	// as in FinalizerBlockedBy.
+	objs := make([]unsafe.Pointer, found.n)
+	for i := range objs {
+		objs[i] = *(*unsafe.Pointer)(add(found.buf, uintptr(i)*sys.PtrSize))
+	}
+	stack.free()
+	found.free()
+	seen.free()
+	startTheWorld()
+
+	for _, p := range objs {
+		fn(p, spanOfUnchecked(uintptr(p)).elemsize)
+	}
+}
+
+// ForEachLiveObjectParallel calls fn with the address and size of every
+// allocated object in the heap. The spans of the heap are divided among
+// workers goroutines, the calling goroutine being one of them, so fn is
+// called concurrently and must be safe for concurrent use.
+//
+// Objects are not copied or stopped: the program keeps running during
+// the walk, and objects allocated meanwhile may or may not be reported.
+// No object is freed before ForEachLiveObjectParallel returns, because
+// it first waits for any running collection and its sweep to finish and
+// then keeps new collections from starting until the walk is done. For
+// the same reason fn must not call GC, ReadMemStats or anything else
+// that stops the world, which would deadlock. fn may allocate; the heap
+// grows without bound during the walk, as under AllocationBarrier.
+func ForEachLiveObjectParallel(workers int, fn func(p unsafe.Pointer, size uintptr)) {
+	if workers < 1 {
+		workers = 1
+	}
+	atomic.Xadd(&gcTriggerHolds, 1)
+	for {
+		// Holding worldsema keeps a new cycle from starting,
+		// but not a running one from finishing, so wait for
+		// that first.
+		semacquire(&worldsema, false)
+		if gcphase == _GCoff {
+			break
+		}
+		semrelease(&worldsema)
+		Gosched()
+	}
+	ForceSweepComplete()
+
+	w := new(heapWalk)
+	w.fn = fn
+	systemstack(func() {
+		lock(&mheap_.lock)
+		for _, s := range h_allspans {
+			if s.state == mSpanInUse {
+				w.spans.add(uintptr(unsafe.Pointer(s)))
+			}
+		}
+		unlock(&mheap_.lock)
+	})
+	w.running = uint32(workers)
+	for i := 1; i < workers; i++ {
+		go w.run()
+	}
+	w.run()
+	semacquire(&w.done, false)
+
+	w.spans.free()
+	semrelease(&worldsema)
+	atomic.Xadd(&gcTriggerHolds, -1)
+}
+
+// A heapWalk is the state shared by the workers of a
+// ForEachLiveObjectParallel call.
+type heapWalk struct {
+	spans   addrList // *mspan of the in-use spans to walk
+	next    uintptr  // index in spans of the next span to walk; accessed atomically
+	running uint32   // workers still walking; accessed atomically
+	done    uint32   // semaphore released by the last worker to finish
+	fn      func(p unsafe.Pointer, size uintptr)
+}
+
+// run walks spans of w until none are left.
+func (w *heapWalk) run() {
+	for {
+		i := atomic.Xadduintptr(&w.next, 1) - 1
+		if i >= w.spans.n {
+			break
+		}
+		s := *(**mspan)(add(w.spans.buf, i*sys.PtrSize))
+		// The span may still be allocated from. Slots below
+		// freeindex are allocated; one above it may be claimed
+		// after this check, in which case it is not reported.
+		size := s.elemsize
+		for j := uintptr(0); j < s.nelems; j++ {
+			if j >= atomic.Loaduintptr(&s.freeindex) && s.isFree(j) {
+				continue
+			}
+			w.fn(add(unsafe.Pointer(s.base()), j*size), size)
+		}
+	}
+	if atomic.Xadd(&w.running, -1) == 0 {
+		semrelease(&w.done)
+	}
+}
+
+// pauseHistBuckets is the number of buckets in the GC pause histogram.
+// Bucket 0 counts pauses of 0ns and bucket i counts pauses of
+// [1<<(i-1), 1<<i) ns, with the last bucket counting all longer pauses.
+const pauseHistBuckets = 48
+
+// recordPause adds a stop-the-world pause of ns nanoseconds to the
+// pause histogram.
+func recordPause(ns int64) {
+	i := 0
+	for ns > 0 && i < pauseHistBuckets-1 {
+		ns >>= 1
+		i++
+	}
+	atomic.Xadd64(&memstats.pause_hist[i], 1)
+}
+
+// A PauseBucket is one bucket of the histogram returned by
+// PauseHistogram.
+type PauseBucket struct {
+	MinNs uint64 // shortest pause counted in the bucket, in nanoseconds
+	MaxNs uint64 // longest pause counted in the bucket, in nanoseconds
+	Count uint64 // number of pauses in the bucket
+}
+
+// PauseHistogram returns the distribution of the durations of all
+// stop-the-world garbage collection pauses since the program started.
+// A concurrent collection stops the world twice, once at the start and
+// once at the end of marking, and each stop is counted separately. The
+// buckets are in increasing order of duration, each covering twice the
+// range of the previous one, and only buckets with a nonzero count are
+// returned.
+func PauseHistogram() []PauseBucket {
+	var buckets []PauseBucket
+	for i := range memstats.pause_hist {
+		n := atomic.Load64(&memstats.pause_hist[i])
+		if n == 0 {
+			continue
+		}
+		b := PauseBucket{Count: n}
+		if i > 0 {
+			b.MinNs = 1 << uint(i-1)
+			b.MaxNs = 1<<uint(i) - 1
+		}
+		if i == pauseHistBuckets-1 {
+			b.MaxNs = 1<<63 - 1
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets
+}
+
+// mallocCyclesHist is the histogram of fast-path allocation times
+// reported by MallocCycles. Bucket 0 counts times of 0 ticks and bucket
+// i counts times of [1<<(i-1), 1<<i) ticks. Updated atomically.
+var mallocCyclesHist [64]uint64
+
+// recordMallocCycles adds a fast-path allocation that took ticks CPU
+// ticks to mallocCyclesHist.
+func recordMallocCycles(ticks int64) {
+	i := 0
+	for ticks > 0 && i < len(mallocCyclesHist)-1 {
+		ticks >>= 1
+		i++
+	}
+	atomic.Xadd64(&mallocCyclesHist[i], 1)
+}
+
+// A MallocCycleBucket is one bucket of the histogram returned by
+// MallocCycles.
+type MallocCycleBucket struct {
+	MinCycles uint64 // shortest time counted in the bucket, in ticks
+	MaxCycles uint64 // longest time counted in the bucket, in ticks
+	Count     uint64 // number of allocations in the bucket
+}
+
+// MallocCycles returns the distribution of the times taken by the fast
+// path of small heap allocations, which takes a free object from the
+// current P's cached span, as sampled when the program runs with
+// GODEBUG=malloctsc=1. Without it, MallocCycles returns nil. With it,
+// every 64th small allocation on each P that is served by the fast path
+// is timed. The buckets are in increasing order of time, each covering
+// twice the range of the previous one, and only buckets with a nonzero
+// count are returned. It is meant for developing the allocator itself.
+//
+// Times are read from the CPU timestamp counter where the runtime uses
+// one, as on 386 and amd64, and are then in the counter's ticks, which
+// on most modern x86 processors run at a constant rate unrelated to the
+// actual clock speed, and which may not be synchronized between cores.
+// On other platforms the runtime substitutes a clock, often of much
+// coarser resolution than an allocation takes, so most samples fall in
+// the first bucket.
+func MallocCycles() []MallocCycleBucket {
+	var buckets []MallocCycleBucket
+	for i := range mallocCyclesHist {
+		n := atomic.Load64(&mallocCyclesHist[i])
+		if n == 0 {
+			continue
+		}
+		b := MallocCycleBucket{Count: n}
+		if i > 0 {
+			b.MinCycles = 1 << uint(i-1)
+			b.MaxCycles = 1<<uint(i) - 1
+		}
+		if i == len(mallocCyclesHist)-1 {
+			b.MaxCycles = 1<<63 - 1
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets
+}
+
+// allocSizeHistBuckets is the number of buckets in the allocation size
+// histogram. Bucket i counts allocations of [1<<(i-1), 1<<i) bytes, with
+// the last bucket counting all larger ones. Allocations of 0 bytes
+// take no memory and are not counted.
+const allocSizeHistBuckets = 48
+
+// allocSizeBucket returns the bucket of the allocation size histogram
+// that counts allocations of size bytes, which is the number of bits
+// needed to represent size. size must not be 0. mallocgc calls it for
+// every allocation, so rather than loop over the bits it takes the
+// exponent of size converted to float64, which is one less than the
+// bit length. The conversion may round a size above 1<<53 up to the
+// next power of 2, but such sizes fall in the last bucket anyway.
+func allocSizeBucket(size uintptr) int {
+	i := int(float64bits(float64(size))>>52) - 1022
+	if i >= allocSizeHistBuckets {
+		i = allocSizeHistBuckets - 1
+	}
+	return i
+}
+
+// An AllocSizeBucket is one bucket of the histogram returned by
+// AllocSizeHistogram.
+type AllocSizeBucket struct {
+	MinSize uint64 // smallest size counted in the bucket, in bytes
+	MaxSize uint64 // largest size counted in the bucket, in bytes
+	Count   uint64 // number of allocations in the bucket
+}
+
+// AllocSizeHistogram returns the distribution of the sizes requested by
+// heap allocations since the last call to ResetAllocSizeHistogram, or
+// since the program started. Sizes are those asked for, before rounding
+// up to a size class. The buckets are in increasing order of size, each
+// covering twice the range of the previous one, and only buckets with a
+// nonzero count are returned. AllocSizeHistogram stops the world to
+// collect the per-P counts.
+func AllocSizeHistogram() []AllocSizeBucket {
+	var counts [allocSizeHistBuckets]uint64
+	stopTheWorld("alloc size histogram")
+	systemstack(func() {
+		cachestats()
+		counts = mheap_.sizehist
+	})
+	startTheWorld()
+
+	var buckets []AllocSizeBucket
+	for i, n := range counts {
+		if n == 0 {
+			continue
+		}
+		b := AllocSizeBucket{MinSize: 1 << uint(i-1), MaxSize: 1<<uint(i) - 1, Count: n}
+		if i == allocSizeHistBuckets-1 {
+			b.MaxSize = 1<<64 - 1
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets
+}
+
+// ResetAllocSizeHistogram empties the histogram reported by
+// AllocSizeHistogram, so that the distribution of allocation sizes can
+// be measured for each phase of a program separately.
+func ResetAllocSizeHistogram() {
+	stopTheWorld("reset alloc size histogram")
+	systemstack(func() {
+		cachestats()
+		mheap_.sizehist = [allocSizeHistBuckets]uint64{}
+	})
+	startTheWorld()
+}
+
+// SlowAllocStats reports how heap allocations since the program started
+// were satisfied. refills is the number of small allocations that found
+// the P's cached span for their size class full and had to refill it
+// from the central free lists, which may take a lock and sweep or grow
+// the heap. largeAllocs is the number of allocations of large objects,
+// which always go to the heap. fastPath is the number of small
+// allocations served directly from the P's cache, including small
+// pointer-free objects packed into an existing tiny block.
+//
+// A high ratio of refills to fastPath suggests that allocation is
+// spread over many size classes or that spans are emptied quickly.
+// SlowAllocStats stops the world to collect the per-P counts.
+func SlowAllocStats() (refills, largeAllocs, fastPath uint64) {
+	stopTheWorld("slow alloc stats")
+	systemstack(func() {
+		lock(&mheap_.lock)
+		cachestats()
+		refills = memstats.nrefill
+		largeAllocs = memstats.nlargealloc
+		fastPath = memstats.tinyallocs + memstats.nsmallalloc - memstats.nrefill
+		unlock(&mheap_.lock)
+	})
+	startTheWorld()
+	return
+}
+
+// A HeapGoalSample describes the pacing of one garbage collection.
+// All sizes are of the live heap, in bytes.
+type HeapGoalSample struct {
+	NumGC   uint32 // number of the collection, as in MemStats.NumGC
+	Trigger uint64 // heap size when the collection started
+	Goal    uint64 // heap size the collection aimed to finish at
+	Peak    uint64 // heap size when marking finished
+	Marked  uint64 // bytes marked live by the collection
+	Forced  bool   // collection was forced, e.g. by GC, and had no goal
+}
+
+// recordHeapGoal records the pacing of the collection that is
+// finishing.
+func recordHeapGoal(s HeapGoalSample) {
+	lock(&memstats.heap_goals_lock)
+	memstats.heap_goals[memstats.heap_goals_n%uint32(len(memstats.heap_goals))] = s
+	memstats.heap_goals_n++
+	unlock(&memstats.heap_goals_lock)
+}
+
+// HeapGoalHistory returns the pacing of up to the last 256 garbage
+// collections, oldest first. A background collection is triggered
+// when the heap grows past Trigger and paced so that marking finishes
+// when the heap reaches Goal, which is derived from the heap marked by
+// the previous collection and GOGC. A Peak consistently above Goal
+// means that the program allocates faster than the collector expects,
+// and collections finish late; a Peak well below Goal means they
+// start earlier than necessary.
+func HeapGoalHistory() []HeapGoalSample {
+	// Allocate the result before taking the lock.
+	samples := make([]HeapGoalSample, len(memstats.heap_goals))
+	lock(&memstats.heap_goals_lock)
+	n := memstats.heap_goals_n
+	if n > uint32(len(samples)) {
+		for i := range samples {
+			samples[i] = memstats.heap_goals[(n+uint32(i))%uint32(len(samples))]
+		}
+	} else {
+		copy(samples, memstats.heap_goals[:n])
+		samples = samples[:n]
+	}
+	unlock(&memstats.heap_goals_lock)
+	return samples
+}
+
+// heapWatermarkNext is the lowest watermark set by SetHeapWatermarks
+// that is above the heap size, or ^uint64(0) if there is none.
+// mallocgc compares heap_live against it whenever heap_live grows.
+// Accessed atomically.
+var heapWatermarkNext uint64 = ^uint64(0)
+
+var heapWatermarks struct {
+	lock     mutex
+	levels   []uint64 // watermarks, in increasing order
+	fn       func(level uintptr)
+	crossed  int  // levels[:crossed] are at or below the heap size
+	notified int  // fn has been called for levels[:notified]
+	started  bool // heapWatermarkHelper is running
+	g        *g   // heapWatermarkHelper's goroutine
+	idle     bool // g is parked waiting for a crossing
+}
+
+// SetHeapWatermarks arranges for fn(level) to be called each time the
+// heap grows past one of the given levels, in bytes, replacing any
+// previous watermarks. A level fires again after a garbage collection
+// shrinks the heap below it and the heap grows past it once more.
+// Levels the heap is already above when SetHeapWatermarks is called
+// do not fire until then. SetHeapWatermarks(nil, nil) removes all
+// watermarks.
+//
+// The heap size is close to MemStats.HeapAlloc, but it counts whole
+// spans of memory as soon as the allocator fetches them for small
+// objects, and it is checked only then, so fn may be called somewhat
+// before or after HeapAlloc passes a level. The calls are
+// made one at a time, in increasing order of level when several levels
+// are passed at once, from a goroutine started for the purpose; fn
+// should not block for long, or later notifications are delayed.
+func SetHeapWatermarks(levels []uintptr, fn func(level uintptr)) {
+	sorted := make([]uint64, 0, len(levels))
+	if fn != nil {
+		for _, l := range levels {
+			// Insert l in order, dropping duplicates.
+			i := 0
+			for i < len(sorted) && sorted[i] < uint64(l) {
+				i++
+			}
+			if i < len(sorted) && sorted[i] == uint64(l) {
+				continue
+			}
+			sorted = append(sorted, 0)
+			copy(sorted[i+1:], sorted[i:])
+			sorted[i] = uint64(l)
+		}
+	}
+
+	lock(&heapWatermarks.lock)
+	heapWatermarks.levels = sorted
+	heapWatermarks.fn = fn
+	heapWatermarks.crossed = 0
+	heapWatermarks.notified = 0
+	heapWatermarkUpdate(atomic.Load64(&memstats.heap_live))
+	heapWatermarks.notified = heapWatermarks.crossed
+	start := !heapWatermarks.started && len(sorted) > 0
+	if start {
+		heapWatermarks.started = true
+	}
+	unlock(&heapWatermarks.lock)
+
+	if start {
+		go heapWatermarkHelper()
+	}
+}
+
+// heapWatermarkUpdate records that the heap size is now heap,
+// re-arming the watermarks above it and setting heapWatermarkNext.
+// It reports whether heapWatermarkHelper has new levels to notify.
+// heapWatermarks.lock must be held.
+func heapWatermarkUpdate(heap uint64) bool {
+	levels := heapWatermarks.levels
+	n := 0
+	for n < len(levels) && levels[n] <= heap {
+		n++
+	}
+	heapWatermarks.crossed = n
+	if heapWatermarks.notified > n {
+		heapWatermarks.notified = n
+	}
+	next := ^uint64(0)
+	if n < len(levels) {
+		next = levels[n]
+	}
+	atomic.Store64(&heapWatermarkNext, next)
+	return heapWatermarks.notified < n
+}
+
+// heapWatermarkCheck compares the heap size against the watermarks.
+// It is called by mallocgc when heap_live reaches heapWatermarkNext
+// and at the end of each GC cycle, when heap_live shrinks.
+func heapWatermarkCheck() {
+	var gp *g
+	lock(&heapWatermarks.lock)
+	if heapWatermarkUpdate(atomic.Load64(&memstats.heap_live)) && heapWatermarks.idle {
+		heapWatermarks.idle = false
+		gp = heapWatermarks.g
+	}
+	unlock(&heapWatermarks.lock)
+	// injectglist may allocate, and so call back into
+	// heapWatermarkCheck, so the lock must be released first.
+	if gp != nil {
+		gp.schedlink = 0
+		injectglist(gp)
+	}
+}
+
+// heapWatermarkHelper calls the SetHeapWatermarks callback for each
+// watermark the heap passes.
+func heapWatermarkHelper() {
+	lock(&heapWatermarks.lock)
+	heapWatermarks.g = getg()
+	for {
+		if heapWatermarks.notified >= heapWatermarks.crossed {
+			heapWatermarks.idle = true
+			goparkunlock(&heapWatermarks.lock, "heap watermark wait", traceEvGoBlock, 1)
+			lock(&heapWatermarks.lock)
+			continue
+		}
+		level := heapWatermarks.levels[heapWatermarks.notified]
+		heapWatermarks.notified++
+		fn := heapWatermarks.fn
+		unlock(&heapWatermarks.lock)
+		fn(uintptr(level))
+		lock(&heapWatermarks.lock)
+	}
+}
+
 //go:linkname readGCStats runtime/debug.readGCStats
 func readGCStats(pauses *[]uint64) {
 	systemstack(func() {
@@ -368,7 +1610,27 @@ func purgecachedstats(c *mcache) {
 	for i := 0; i < len(c.local_nsmallfree); i++ {
 		h.nsmallfree[i] += uint64(c.local_nsmallfree[i])
 		c.local_nsmallfree[i] = 0
+		h.nclassalloc[i] += uint64(c.local_nclassalloc[i])
+		c.local_nclassalloc[i] = 0
+	}
+	for i := range c.local_sizehist {
+		h.sizehist[i] += uint64(c.local_sizehist[i])
+		c.local_sizehist[i] = 0
+	}
+	memstats.nsmallalloc += uint64(c.local_nalloc)
+	c.local_nalloc = 0
+	memstats.nrefill += uint64(c.local_nrefill)
+	c.local_nrefill = 0
+	memstats.nlargealloc += uint64(c.local_nlarge)
+	c.local_nlarge = 0
+	memstats.ntrigcheck += uint64(c.local_ntrigcheck)
+	c.local_ntrigcheck = 0
+	memstats.ntrigfire += uint64(c.local_ntrigfire)
+	c.local_ntrigfire = 0
+	if c.local_maxalloc > atomic.Loaduintptr(&maxAllocSeen) {
+		noteMaxAlloc(c.local_maxalloc)
 	}
+	c.local_maxalloc = 0
 }
 
 // Atomically increases a given *system* memory stat. We are counting on this
@@ -0,0 +1,32 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build noscanalloc
+
+// Allocation of memory assumed to hold no pointers, enabled by the
+// noscanalloc build tag.
+
+package runtime
+
+import "unsafe"
+
+// AllocAssumeNoPointers allocates a zeroed block of size bytes that the
+// garbage collector treats as holding no pointers, whatever will be
+// stored in it. It is meant for code that allocates memory for values
+// whose type is only known at run time and that it can prove contain
+// no pointers, so that the collector skips scanning them, as it does
+// for the memory of new([n]byte).
+//
+// The collector never looks inside the block, so it must never hold
+// the only reference to a heap object: such an object is freed while
+// still in use, and its memory reused, which leads to memory
+// corruption and crashes long after the mistake. For this reason
+// AllocAssumeNoPointers is only available when the runtime is built
+// with the noscanalloc tag.
+func AllocAssumeNoPointers(size uintptr) unsafe.Pointer {
+	if int(size) < 0 {
+		panic(plainError("runtime.AllocAssumeNoPointers: size out of range"))
+	}
+	return mallocgc(size, nil, 0)
+}
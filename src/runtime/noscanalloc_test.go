@@ -0,0 +1,41 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build noscanalloc
+
+package runtime_test
+
+import (
+	"runtime"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestAllocAssumeNoPointers(t *testing.T) {
+	for _, size := range []uintptr{1, 100, 100 << 10} {
+		b := (*[100 << 10]byte)(runtime.AllocAssumeNoPointers(size))[:size:size]
+		for _, c := range b {
+			if c != 0 {
+				t.Fatalf("AllocAssumeNoPointers(%d) returned memory that is not zeroed", size)
+			}
+		}
+	}
+
+	// A pointer stored in the block does not keep its target alive.
+	p := (*[4]*[2]int)(runtime.AllocAssumeNoPointers(unsafe.Sizeof([4]*[2]int{})))
+	finalized := make(chan bool, 1)
+	x := new([2]int) // too big for the tiny allocator
+	runtime.SetFinalizer(x, func(*[2]int) { finalized <- true })
+	p[0] = x
+	x = nil
+	runtime.GC()
+	select {
+	case <-finalized:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("object referred to only from an AllocAssumeNoPointers block was not freed")
+	}
+	p[0] = nil
+	runtime.KeepAlive(p)
+}
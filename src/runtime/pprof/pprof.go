@@ -581,6 +581,18 @@ func writeHeap(w io.Writer, debug int) error {
 	fmt.Fprintf(w, "# NumGC = %d\n", s.NumGC)
 	fmt.Fprintf(w, "# DebugGC = %v\n", s.DebugGC)
 
+	if names := runtime.AllocByDebugName(); len(names) > 0 {
+		keys := make([]string, 0, len(names))
+		for name := range names {
+			keys = append(keys, name)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(w, "\n# runtime.AllocByDebugName\n")
+		for _, name := range keys {
+			fmt.Fprintf(w, "# %q = %d\n", name, names[name])
+		}
+	}
+
 	if tw != nil {
 		tw.Flush()
 	}
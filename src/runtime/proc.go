@@ -571,6 +571,9 @@ func gcprocs() int32 {
 	if n > _MaxGcproc {
 		n = _MaxGcproc
 	}
+	if limit := int32(atomic.Load(&gcWorkerLimit)); limit != 0 && n > limit {
+		n = limit
+	}
 	if n > sched.nmidle+1 { // one M is currently running
 		n = sched.nmidle + 1
 	}
@@ -587,6 +590,9 @@ func needaddgcproc() bool {
 	if n > _MaxGcproc {
 		n = _MaxGcproc
 	}
+	if limit := int32(atomic.Load(&gcWorkerLimit)); limit != 0 && n > limit {
+		n = limit
+	}
 	n -= sched.nmidle + 1 // one M is currently running
 	unlock(&sched.lock)
 	return n > 0
@@ -1907,7 +1913,7 @@ stop:
 	// We have nothing to do. If we're in the GC mark phase, can
 	// safely scan and blacken objects, and have work to do, run
 	// idle-time marking rather than give up the P.
-	if gcBlackenEnabled != 0 && _p_.gcBgMarkWorker != 0 && gcMarkWorkAvailable(_p_) {
+	if gcBlackenEnabled != 0 && _p_.gcBgMarkWorker != 0 && atomic.Load(&gcWorkerLimit) == 0 && gcMarkWorkAvailable(_p_) {
 		_p_.gcMarkWorkerMode = gcMarkWorkerIdleMode
 		gp := _p_.gcBgMarkWorker.ptr()
 		casgstatus(gp, _Gwaiting, _Grunnable)
@@ -2223,6 +2229,9 @@ func goexit0(gp *g) {
 	gp.writebuf = nil
 	gp.waitreason = ""
 	gp.param = nil
+	gp.alloclabel = 0
+	gp.noprofile = false
+	gp.noprofileinh = false
 
 	// Note that gp's stack scan is now "valid" because it has no
 	// stack. We could dequeueRescan, but that takes a lock and
@@ -2754,6 +2763,13 @@ func newproc1(fn *funcval, argp *uint8, narg int32, nret int32, callerpc uintptr
 	gostartcallfn(&newg.sched, fn)
 	newg.gopc = callerpc
 	newg.startpc = fn.fn
+	if curg := _g_.m.curg; curg != nil {
+		newg.alloclabel = curg.alloclabel
+		if curg.noprofileinh {
+			newg.noprofile = curg.noprofile
+			newg.noprofileinh = true
+		}
+	}
 	if isSystemGoroutine(newg) {
 		atomic.Xadd(&sched.ngsys, +1)
 	}
@@ -3257,6 +3273,9 @@ func procresize(nprocs int32) *p {
 			for i := range pp.deferpool {
 				pp.deferpool[i] = pp.deferpoolbuf[i][:0]
 			}
+			if allocTraceSize > 0 {
+				pp.alloctrace = newAllocTraceRing(allocTraceSize)
+			}
 			atomicstorep(unsafe.Pointer(&allp[i]), unsafe.Pointer(pp))
 		}
 		if pp.mcache == nil {
@@ -3540,6 +3559,7 @@ func sysmon() {
 
 	lastscavenge := nanotime()
 	nscavenge := 0
+	scavengemore := false // a scavenge pass was cut short by SetScavengePace
 
 	lasttrace := int64(0)
 	idle := 0 // how many cycles in succession we had not wokeup somebody
@@ -3611,10 +3631,12 @@ func sysmon() {
 			unlock(&forcegc.lock)
 		}
 		// scavenge heap once in a while
-		if lastscavenge+scavengelimit/2 < now {
-			mheap_.scavenge(int32(nscavenge), uint64(now), uint64(scavengelimit))
+		if lastscavenge+scavengelimit/2 < now || scavengemore && lastscavenge+scavengePaceTick <= now {
+			scavengemore = mheap_.scavenge(int32(nscavenge), uint64(now), uint64(scavengelimit), scavengeBudget(), atomic.Loaduintptr(&minHeap))
 			lastscavenge = now
-			nscavenge++
+			if !scavengemore {
+				nscavenge++
+			}
 		}
 		if debug.schedtrace > 0 && lasttrace+int64(debug.schedtrace)*1000000 <= now {
 			lasttrace = now
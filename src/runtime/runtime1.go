@@ -325,6 +325,9 @@ var debug struct {
 	gcstoptheworld    int32
 	gctrace           int32
 	invalidptr        int32
+	malloctsc         int32
+	noscancheck       int32
+	objtypes          int32
 	sbrk              int32
 	scavenge          int32
 	scheddetail       int32
@@ -344,6 +347,9 @@ var dbgvars = []dbgVar{
 	{"gcstoptheworld", &debug.gcstoptheworld},
 	{"gctrace", &debug.gctrace},
 	{"invalidptr", &debug.invalidptr},
+	{"malloctsc", &debug.malloctsc},
+	{"noscancheck", &debug.noscancheck},
+	{"objtypes", &debug.objtypes},
 	{"sbrk", &debug.sbrk},
 	{"scavenge", &debug.scavenge},
 	{"scheddetail", &debug.scheddetail},
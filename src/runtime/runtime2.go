@@ -354,6 +354,12 @@ type g struct {
 	racectx        uintptr
 	waiting        *sudog    // sudog structures this g is waiting on (that have a valid elem ptr); in lock order
 	cgoCtxt        []uintptr // cgo traceback context
+	alloclabel     uint64    // allocation label; see SetAllocLabel
+	noalloc        bool      // heap allocation throws; set while a SetFinalizerNoAlloc finalizer runs
+	noprofile      bool      // allocations are not sampled for the memory profile; see SetGoroutineProfileExclude
+	noprofileinh   bool      // new goroutines inherit noprofile; see SetGoroutineProfileExcludeInherit
+	allocbudgeton  bool      // allocbudget is in effect; see WithAllocBudget
+	allocbudget    int64     // bytes the goroutine may still allocate under WithAllocBudget
 
 	// Per-G GC state
 
@@ -484,6 +490,8 @@ type p struct {
 
 	tracebuf traceBufPtr
 
+	alloctrace *allocTraceRing // see EnableAllocTrace
+
 	palloc persistentAlloc // per-P to avoid mutex
 
 	// Per-P GC state
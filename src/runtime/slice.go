@@ -36,8 +36,11 @@ func maxSliceCap(elemsize uintptr) uintptr {
 	return _MaxMem / elemsize
 }
 
+// makeslice implements make([]T, len, cap). user is set by the
+// compiler for code outside the runtime; only such slices are subject
+// to SetAllocFill and SetAllocZeroPolicy.
 // TODO: take uintptrs instead of int64s?
-func makeslice(et *_type, len64, cap64 int64) slice {
+func makeslice(et *_type, len64, cap64 int64, user bool) slice {
 	// NOTE: The len > maxElements check here is not strictly necessary,
 	// but it produces a 'len out of range' error instead of a 'cap out of range' error
 	// when someone does make([]T, bignumber). 'cap out of range' is true too,
@@ -54,7 +57,11 @@ func makeslice(et *_type, len64, cap64 int64) slice {
 		panic(errorString("makeslice: cap out of range"))
 	}
 
-	p := mallocgc(et.size*uintptr(cap), et, true)
+	var flags uint32
+	if user {
+		flags = flagFill | flagZeroPolicy
+	}
+	p := mallocgc(et.size*uintptr(cap), et, flags)
 	return slice{p, len, cap}
 }
 
@@ -123,12 +130,12 @@ func growslice(et *_type, old slice, cap int) slice {
 
 	var p unsafe.Pointer
 	if et.kind&kindNoPointers != 0 {
-		p = mallocgc(capmem, nil, false)
+		p = mallocgc(capmem, nil, flagNoZero)
 		memmove(p, old.array, lenmem)
 		memclr(add(p, lenmem), capmem-lenmem)
 	} else {
 		// Note: can't use rawmem (which avoids zeroing of memory), because then GC can scan uninitialized memory.
-		p = mallocgc(capmem, et, true)
+		p = mallocgc(capmem, et, 0)
 		if !writeBarrier.enabled {
 			memmove(p, old.array, lenmem)
 		} else {
@@ -0,0 +1,28 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"runtime"
+	"time"
+)
+
+func init() {
+	register("FinalizerNoAlloc", FinalizerNoAlloc)
+}
+
+var finalizerSink []byte
+
+func FinalizerNoAlloc() {
+	func() {
+		x := new([16]int)
+		runtime.SetFinalizerNoAlloc(x, func(x *[16]int) {
+			finalizerSink = make([]byte, 100+x[0])
+		})
+	}()
+	runtime.GC()
+	time.Sleep(5 * time.Second)
+	println("finalizer did not run")
+}